@@ -1,9 +1,52 @@
 package bitcask_gown
 
+import (
+	"bitcask-gown/fio"
+	"bitcask-gown/metrics"
+	"log/slog"
+	"time"
+)
+
 type Options struct {
-	DirPath      string // 文件路径信息
-	DataFileSize int64  // 数据文件最大的大小
-	SyncWrites   bool   // 是否选择执行持久化
+	DirPath        string // 文件路径信息
+	DataFileSize   int64  // 数据文件最大的大小
+	SyncWrites     bool   // 是否选择执行持久化
+	ValueThreshold int    // 键值分离的阈值，value 超过该长度时写入 blob 文件，为 0 时不开启
+
+	InMemory            bool           // 所有文件都只保存在内存之中，不访问磁盘；关闭之后数据即丢失
+	IOType              fio.FileIOType // 数据文件的 IO 类型，fio.DirectFIO 会绕过 page cache 读写
+	PreallocateDataFile bool           // 创建活跃文件时，是否使用 fallocate 预分配 DataFileSize 大小的空间
+	WriteBufferSize     int            // 活跃文件的用户态写缓冲区大小，在 Sync 或者切换活跃文件时刷入文件；为 0 时不开启
+
+	// 在 SyncWrites 关闭时，由后台协程定期持久化：累计写入 BytesPerSync 字节，或者距离上次持久化超过 SyncInterval 时执行一次；
+	// 两者为 0 时均不开启。后台持久化失败后，之后的写入都会返回该错误。
+	BytesPerSync uint
+	SyncInterval time.Duration
+
+	// 热点 value 的缓存容量（字节），缓存以记录的位置为 key，按照 LRU 淘汰；为 0 时不开启
+	ValueCacheSize int64
+
+	// 运行指标，不为 nil 时统计各个操作的延迟、字节数以及错误，可以通过 Metrics.Handler 导出
+	Metrics *metrics.Metrics
+
+	// 内部事件的监听器，例如文件切换、持久化、恢复进度以及数据损坏；为 nil 时不监听
+	Listener Listener
+
+	// 结构化日志，记录打开、恢复、文件切换、持久化以及关闭的过程；为 nil 时不输出日志
+	Logger *slog.Logger
+
+	// 以只读模式打开，所有文件以只读方式打开，不会创建目录或者修改任何文件，可以用于只读挂载的文件系统；
+	// 写入操作返回 ErrReadOnly。不能与 Replica 以及 InMemory 同时设置
+	ReadOnly bool
+
+	// 以从库模式打开，数据只能通过 ReplicateFrom 从主库复制，写入操作返回 ErrReplicaReadOnly；不支持键值分离
+	Replica bool
+
+	// 合并 MergeValue 写入的操作数的方式，为 nil 时不能使用 MergeValue，也不能读取已有的操作数
+	MergeOperator MergeOperator
+
+	// 数据库所在的文件系统，为 nil 时使用操作系统的文件系统；不能与 InMemory 同时设置
+	FileSystem fio.FileSystem
 }
 
 var DefaultOptions = Options{
@@ -31,6 +74,30 @@ func checkOptions(opt Options) error {
 	if opt.DataFileSize <= 0 {
 		return ErrInvalidDataFileSize
 	}
+	if opt.ValueThreshold < 0 {
+		return ErrInvalidValueThreshold
+	}
+	if opt.SyncInterval < 0 {
+		return ErrInvalidSyncInterval
+	}
+	if opt.WriteBufferSize < 0 {
+		return ErrInvalidWriteBufferSize
+	}
+	if opt.IOType != fio.StandardFIO && opt.IOType != fio.DirectFIO {
+		return ErrInvalidIOType
+	}
+	if opt.ValueCacheSize < 0 {
+		return ErrInvalidValueCacheSize
+	}
+	if opt.Replica && opt.ValueThreshold > 0 {
+		return ErrReplicationUnsupported
+	}
+	if opt.InMemory && opt.FileSystem != nil {
+		return ErrInvalidFileSystem
+	}
+	if opt.ReadOnly && (opt.Replica || opt.InMemory) {
+		return ErrInvalidReadOnly
+	}
 
 	return nil
 }
@@ -0,0 +1,56 @@
+package bitcask_gown
+
+import "time"
+
+// addBytesWritten 累计自上次持久化以来写入的字节数（同时计入指标并通知复制协程），达到 BytesPerSync 时通知后台协程，调用方需要持有 db.lock
+func (db *DB) addBytesWritten(n int64) {
+	if db.option.Metrics != nil {
+		db.option.Metrics.AddBytesWritten(n)
+	}
+	db.bytesSinceSync += n
+	db.appended.notify()
+	if db.option.BytesPerSync > 0 && db.bytesSinceSync >= int64(db.option.BytesPerSync) {
+		// 通道已满说明后台协程已经收到了通知，无需重复发送
+		select {
+		case db.syncNotify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runBackgroundSync 后台持久化协程，在写入量达到 BytesPerSync 或者每隔 SyncInterval 时持久化活跃文件，直到 Close 被调用
+func (db *DB) runBackgroundSync() {
+	defer db.syncWg.Done()
+
+	var tick <-chan time.Time
+	if db.option.SyncInterval > 0 {
+		ticker := time.NewTicker(db.option.SyncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-db.syncClose:
+			return
+		case <-db.syncNotify:
+			db.backgroundSync()
+		case <-tick:
+			db.backgroundSync()
+		}
+	}
+}
+
+// backgroundSync 执行一次后台持久化，失败时记录错误，之后的写入都会返回该错误
+func (db *DB) backgroundSync() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.activeFile == nil || db.bytesSinceSync == 0 || db.syncErr != nil {
+		return
+	}
+	if err := db.syncActiveFiles(); err != nil {
+		db.logger.Error("background sync failed, rejecting further writes", "err", err)
+		db.syncErr = err
+	}
+}
@@ -0,0 +1,80 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/fio"
+	"bitcask-gown/utils"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSyncIO wraps an IOManager and fails every Sync.
+type failingSyncIO struct {
+	fio.IOManager
+}
+
+var errTestSync = errors.New("test: sync failed")
+
+func (f *failingSyncIO) Sync() error { return errTestSync }
+
+func pendingBytes(db *DB) int64 {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.bytesSinceSync
+}
+
+// TestDB_BytesPerSync ensures the background goroutine syncs once enough bytes accumulate.
+func TestDB_BytesPerSync(t *testing.T) {
+	setup := DefaultOptions
+	setup.BytesPerSync = 256
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+	assert.Greater(t, pendingBytes(db), int64(0))
+
+	for i := 1; i < 10; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Eventually(t, func() bool { return pendingBytes(db) < 256 }, time.Second, 5*time.Millisecond)
+}
+
+// TestDB_SyncInterval ensures the background goroutine syncs on its ticker.
+func TestDB_SyncInterval(t *testing.T) {
+	setup := DefaultOptions
+	setup.SyncInterval = 10 * time.Millisecond
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+	assert.Eventually(t, func() bool { return pendingBytes(db) == 0 }, time.Second, 5*time.Millisecond)
+}
+
+// TestDB_BackgroundSyncError ensures a failed background sync is surfaced on later writes.
+func TestDB_BackgroundSyncError(t *testing.T) {
+	setup := DefaultOptions
+	setup.SyncInterval = 5 * time.Millisecond
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+	db.lock.Lock()
+	realIO := db.activeFile.IOManager
+	db.activeFile.IOManager = &failingSyncIO{IOManager: realIO}
+	db.lock.Unlock()
+
+	require.NoError(t, db.Put(utils.GetTestKey(1), utils.RandomValue(8)))
+	assert.Eventually(t, func() bool {
+		return errors.Is(db.Put(utils.GetTestKey(2), utils.RandomValue(8)), errTestSync)
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, errTestSync, db.Delete(utils.GetTestKey(0)))
+
+	// 恢复 IOManager 之后，Close 依然能够正常停止后台协程
+	db.lock.Lock()
+	db.activeFile.IOManager = realIO
+	db.lock.Unlock()
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,81 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"io"
+	"strings"
+)
+
+// backupChunkSize 备份时每次复制的数据大小
+const backupChunkSize = 1024 * 1024
+
+// Backup 将数据库当前的所有数据文件以及 blob 文件复制到 dir 目录之中，之后可以直接以 dir 为 DirPath 打开。
+// 备份期间持有读锁，写入会被阻塞，读取不受影响；dir 位于数据库所在的文件系统之上，且不能已经包含数据文件。
+func (db *DB) Backup(dir string) error {
+	if err := db.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	fileNames, err := db.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		if strings.HasSuffix(fileName, data.DataFileNameSuffix) || strings.HasSuffix(fileName, data.BlobFileNameSuffix) {
+			return ErrBackupDirNotEmpty
+		}
+	}
+
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	dataFiles := make([]*data.DataFile, 0, len(db.oldFiles)+1)
+	for _, dataFile := range db.oldFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+	for _, dataFile := range dataFiles {
+		if err := db.backupFile(dataFile, dir, data.OpenDataFileWithOptions); err != nil {
+			return err
+		}
+	}
+
+	blobFiles := make([]*data.DataFile, 0, len(db.blobOldFiles)+1)
+	for _, blobFile := range db.blobOldFiles {
+		blobFiles = append(blobFiles, blobFile)
+	}
+	if db.blobActiveFile != nil {
+		blobFiles = append(blobFiles, db.blobActiveFile)
+	}
+	for _, blobFile := range blobFiles {
+		if err := db.backupFile(blobFile, dir, data.OpenBlobFile); err != nil {
+			return err
+		}
+	}
+	db.logger.Info("database backed up", "dir", dir, "files", len(dataFiles)+len(blobFiles))
+	return nil
+}
+
+// backupFile 将 src 之中有效的数据（不包括预分配的部分）复制到 dir 目录下同名的文件之中
+func (db *DB) backupFile(src *data.DataFile, dir string,
+	open func(dirPath string, fileId uint32, opt data.FileOptions) (*data.DataFile, error)) error {
+	dst, err := open(dir, src.FileID, data.FileOptions{FileSystem: db.fs})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	buf := make([]byte, backupChunkSize)
+	for offset := int64(0); offset < src.WriteOff; {
+		n := min(src.WriteOff-offset, backupChunkSize)
+		if m, err := src.ReadAt(buf[:n], offset); err != nil && !(err == io.EOF && int64(m) == n) {
+			return err
+		}
+		if err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return dst.Sync()
+}
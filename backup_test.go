@@ -0,0 +1,50 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/utils"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_Backup ensures a backup directory opens as an identical DB, including blob files and preallocated files.
+func TestDB_Backup(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 4 * 1024
+	setup.ValueThreshold = 128
+	setup.PreallocateDataFile = true
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(i*4)))
+	}
+	require.NoError(t, db.Delete(utils.GetTestKey(0)))
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	require.NoError(t, db.Backup(backupDir))
+	assert.Equal(t, ErrBackupDirNotEmpty, db.Backup(backupDir))
+
+	// 备份之后的写入不影响备份
+	require.NoError(t, db.Put([]byte("after-backup"), []byte("v")))
+
+	setup.DirPath = backupDir
+	backup, err := Open(setup)
+	require.NoError(t, err)
+	defer backup.Close()
+
+	assert.Equal(t, db.index.Size()-1, backup.index.Size())
+	_, err = backup.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = backup.Get([]byte("after-backup"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		want, err := db.Get(utils.GetTestKey(i))
+		require.NoError(t, err)
+		got, err := backup.Get(utils.GetTestKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
@@ -2,67 +2,112 @@ package bitcask_gown
 
 import (
 	"bitcask-gown/data"
+	"bitcask-gown/metrics"
+	"bytes"
 	"encoding/binary"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-const nonTxnSerialNum uint64 = 0
+const nonTxnSeqNumber uint64 = 0
 
 var txnFinKey = "txn-finished"
 
 type WriteBatch struct {
 	mu            *sync.Mutex // 务必有这个，否则只是通过 db 之中就会导致整个数据库读写都被阻塞
 	db            *DB
-	pendingWrites map[string]*data.LogRecord
+	pendingWrites map[string]*pendingWrite // pendingKey(bucket, key) -> 暂存的写入
 	setup         *WriteBatchSetup
 }
 
-func NewWriteBatch(db *DB, setup *WriteBatchSetup) *WriteBatch {
+// pendingWrite 暂存的一条写入，以及它所属 bucket 的名称
+type pendingWrite struct {
+	bucket string
+	record *data.LogRecord
+}
+
+// NewWriteBatch 创建一个绑定到 db 的批量写入
+func (db *DB) NewWriteBatch(setup WriteBatchSetup) *WriteBatch {
 	return &WriteBatch{
 		mu:            new(sync.Mutex),
 		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
-		setup:         setup,
+		pendingWrites: make(map[string]*pendingWrite),
+		setup:         &setup,
 	}
 }
 
+// BatchBucket 批量写入在某个 bucket 上的视图，通过它暂存的写入与同一批次之中的其他写入一起原子地提交
+type BatchBucket struct {
+	wb   *WriteBatch
+	name string
+}
+
+// Bucket 返回批量写入在名称为 name 的 bucket 上的视图，name 为空时即默认的 bucket；bucket 不存在时在提交时创建
+func (wb *WriteBatch) Bucket(name string) *BatchBucket {
+	return &BatchBucket{wb: wb, name: name}
+}
+
+// Put 暂存一条写入到 bucket 之中的数据
+func (bb *BatchBucket) Put(key, value []byte) error {
+	return bb.wb.put(bb.name, key, value)
+}
+
+// Delete 暂存一条删除 bucket 之中数据的操作
+func (bb *BatchBucket) Delete(key []byte) error {
+	return bb.wb.delete(bb.name, key)
+}
+
 // Put 将 key，value 以 logRecord 形式写入到 pendingWrites 之中
 func (wb *WriteBatch) Put(key, value []byte) error {
+	return wb.put("", key, value)
+}
+
+func (wb *WriteBatch) put(bucketName string, key, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := wb.db.checkWritable(); err != nil {
+		return err
+	}
 
 	// 仍然是加锁防止竞态条件
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	logRecord := data.NewLogRecord(key, value)
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[pendingKey(bucketName, key)] = &pendingWrite{bucket: bucketName, record: logRecord}
 
 	return nil
 }
 
 // Delete 向 pendWrites 之中写入 logRecord（类型为 toDelete 类型）
 func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.delete("", key)
+}
+
+func (wb *WriteBatch) delete(bucketName string, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := wb.db.checkWritable(); err != nil {
+		return err
+	}
 
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	// 检验 pendingWrites 是否已存在对应的 key
-	existRec, existPending := wb.pendingWrites[string(key)]
-	if existPending {
-		if existRec.Type == data.LogRecordToDelete {
-			return nil
-		}
-	} else {
-		// 去索引之中检查，看索引里有没有，如果也没有，直接返回
-		if pos, _ := wb.db.index.Get(key); pos == nil {
-			return nil
-		}
+	// 去索引之中检查，如果索引里没有，那么只需要把 pendingWrites 之中暂存的数据丢弃即可
+	pk := pendingKey(bucketName, key)
+	bkt := wb.db.lookupBucket(bucketName)
+	if bkt == nil {
+		delete(wb.pendingWrites, pk)
+		return nil
+	}
+	if pos, _ := bkt.index.Get(key); pos == nil {
+		delete(wb.pendingWrites, pk)
+		return nil
 	}
 
 	// 说明 key 存在于 pendingWrites （类型为Normal） 或者 Index 之中；构建的 rec 其中 value 没有保留必要。
@@ -71,21 +116,33 @@ func (wb *WriteBatch) Delete(key []byte) error {
 		Type: data.LogRecordToDelete,
 	}
 
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[pk] = &pendingWrite{bucket: bucketName, record: logRecord}
 	return nil
 }
 
 // Commit 将待写入区域的 logRecord 全部写入，并更新索引
-func (wb *WriteBatch) Commit() error {
+func (wb *WriteBatch) Commit() (err error) {
+	defer wb.db.observe(metrics.OpBatchCommit, time.Now(), &err)
+
+	if err := wb.db.checkWritable(); err != nil {
+		return err
+	}
+
 	// 锁住暂存区
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	// 检验 pendingWrites 是否有效
+	// 没有待写入的数据，无需提交
 	if len(wb.pendingWrites) == 0 {
-		return ErrPendingWritesInvalid
+		return nil
 	}
 
+	var seqNumber uint64
+	records := len(wb.pendingWrites)
+	defer func() {
+		wb.db.listener.OnBatchCommit(BatchCommitInfo{SeqNumber: seqNumber, Records: records, Err: err})
+	}()
+
 	// 检验单次写入是否超过了最大界限
 	if len(wb.pendingWrites) > int(wb.setup.MaxBatchNum) {
 		return ErrExceedMaxBatchNum
@@ -95,68 +152,127 @@ func (wb *WriteBatch) Commit() error {
 	wb.db.lock.Lock()
 	defer wb.db.lock.Unlock()
 
+	// 找到每条写入所属的 bucket；写入的 bucket 不存在时先创建，创建记录不属于事务，不随提交失败回滚。
+	// 删除所在的 bucket 在暂存之后被删除时，这条删除已经没有意义，直接跳过
+	buckets := make(map[string]*bucket)
+	for pk, pw := range wb.pendingWrites {
+		bkt := wb.db.bucketByName(pw.bucket)
+		if bkt == nil && pw.record.Type == data.LogRecordNormal {
+			if bkt, err = wb.db.createBucket(pw.bucket); err != nil {
+				return err
+			}
+		}
+		if bkt != nil {
+			buckets[pk] = bkt
+		}
+	}
+
 	// 获取当前最新的事务序列号
-	serialNum := atomic.AddUint64(&wb.db.serialNum, 1)
+	seqNumber = atomic.AddUint64(&wb.db.seqNumber, 1)
+
+	// 提交失败时回滚已经写入的部分
+	fid, offset := wb.db.activeFilePos()
 
 	// 创建 positions 用户存储 key - pos 的映射
 	positions := make(map[string]*data.LogRecordPos)
 
 	// 将所有的 logRecord 添加到 dataFile 之中
-	for _, rec := range wb.pendingWrites {
+	for pk, bkt := range buckets {
+		rec := wb.pendingWrites[pk].record
 		// appendLogRecord 是 db.go 之中的方法，负责追加写入到 activeFile
 		pos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   recKeyWithSerialNum(rec.Key, serialNum),
-			Value: rec.Value,
-			Type:  rec.Type,
+			Key:    addSeqToKey(rec.Key, seqNumber),
+			Value:  rec.Value,
+			Type:   rec.Type,
+			Bucket: bkt.id,
 		})
 
 		if err != nil {
+			wb.db.rollbackActiveFile(fid, offset)
 			return err
 		}
-		positions[string(rec.Key)] = pos // 将 key - pos 存储到 positions 之中，便于后期索引更新
+		positions[pk] = pos // 将 key - pos 存储到 positions 之中，便于后期索引更新
 	}
 
 	// 写入到最后，我们需要创建一个新的类型为 logRecordTxnFinshed 的记录（用以标志事务结束），然后写入到 dataFile 之中
 	lstRec := &data.LogRecord{
-		Key:  []byte(txnFinKey), // key 内容不重要，但是最好不要为空
+		Key:  addSeqToKey([]byte(txnFinKey), seqNumber), // key 内容不重要，但必须带上序列号，loadIndex 依靠它找到对应事务
 		Type: data.LogRecordTxnFinished,
 	}
-	_, err := wb.db.appendLogRecord(lstRec)
+	lstPos, err := wb.db.appendLogRecord(lstRec)
 	if err != nil {
+		wb.db.rollbackActiveFile(fid, offset)
 		return err
 	}
 
-	// 根据配置选择是否持久化
+	// 根据配置选择是否持久化；这里已经持有 db.lock，不能调用 db.Sync
 	if wb.setup.SyncWrites {
-		if err := wb.db.Sync(); err != nil {
+		if err := wb.db.syncActiveFiles(); err != nil {
+			wb.db.rollbackActiveFile(fid, offset)
 			return err
 		}
 	}
 
-	for _, rec := range wb.pendingWrites {
+	wb.db.reclaimSize += lstPos.Size // 事务完成的标记本身不再需要
+	for pk, bkt := range buckets {
+		rec, pos := wb.pendingWrites[pk].record, positions[pk]
 		if rec.Type == data.LogRecordNormal {
-			wb.db.index.Delete(rec.Key)
+			_ = wb.db.indexPut(bkt.index, rec.Key, pos)
 		} else if rec.Type == data.LogRecordToDelete {
-			wb.db.index.Put(rec.Key, positions[string(rec.Key)])
+			wb.db.indexDelete(bkt.index, rec.Key, pos)
 		}
 	}
+	wb.notifyWatchers(seqNumber, buckets, positions)
 
 	// 最后将其进行清空即可
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.pendingWrites = make(map[string]*pendingWrite)
 
 	return nil
 }
 
-// rec 之中，key + serialNum 编码
-func recKeyWithSerialNum(key []byte, serialNum uint64) []byte {
-	// 创建字节型数组 serialNumBytes
-	serialNumBytes := make([]byte, binary.MaxVarintLen64)
-	// 随后将 serialNum 放入到刚才创建的字节数组 serialNumBytes 之中
-	n := binary.PutUvarint(serialNumBytes[:], serialNum)
+// notifyWatchers 事务完成的标记写入之后，将整个批次在默认 bucket 之中的变更作为一组事件发布，按照 key 排序
+func (wb *WriteBatch) notifyWatchers(seqNumber uint64, buckets map[string]*bucket, positions map[string]*data.LogRecordPos) {
+	if !wb.db.watchHub.watching() {
+		return
+	}
+	events := make([]Event, 0, len(wb.pendingWrites))
+	for pk, bkt := range buckets {
+		if bkt != wb.db.defaultBucket {
+			continue
+		}
+		rec := wb.pendingWrites[pk].record
+		typ := EventPut
+		if rec.Type == data.LogRecordToDelete {
+			typ = EventDelete
+		}
+		events = append(events, newWatchEvent(typ, rec.Key, rec.Value, seqNumber, positions[pk]))
+	}
+	if len(events) == 0 {
+		return
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].Key, events[j].Key) < 0
+	})
+	wb.db.watchHub.publish(events)
+}
+
+// pendingKey 暂存区之中的 key：bucket 名称的长度 + bucket 名称 + key，不同 bucket 之中相同的 key 互不覆盖
+func pendingKey(bucketName string, key []byte) string {
+	buf := binary.AppendUvarint(nil, uint64(len(bucketName)))
+	buf = append(buf, bucketName...)
+	return string(append(buf, key...))
+}
+
+// rec 之中，key + seqNumber 编码
+func addSeqToKey(key []byte, seqNumber uint64) []byte {
+	// 创建字节型数组 seqBytes
+	seqBytes := make([]byte, binary.MaxVarintLen64)
+	// 随后将 seqNumber 放入到刚才创建的字节数组 seqBytes 之中
+	n := binary.PutUvarint(seqBytes[:], seqNumber)
 
-	// 创建一个新的数组 encKey，长度为原本 key 长度再加上 serialNumBytes 的长度
+	// 创建一个新的数组 encKey，长度为原本 key 长度再加上 seqBytes 的长度
 	encKey := make([]byte, n+len(key))
-	copy(encKey[:n], serialNumBytes[:n])
+	copy(encKey[:n], seqBytes[:n])
 	copy(encKey[n:], key)
 
 	return encKey
@@ -164,7 +280,7 @@ func recKeyWithSerialNum(key []byte, serialNum uint64) []byte {
 
 // 解析 logRecord.Key，获取对应的 key 以及 事务序列号
 func parseLogRecordKey(key []byte) ([]byte, uint64) {
-	serialNum, n := binary.Uvarint(key) // TODO: 我还不知道 Uvarint 方法是什么意思来
+	seqNumber, n := binary.Uvarint(key) // TODO: 我还不知道 Uvarint 方法是什么意思来
 	realKey := key[n:]
-	return realKey, serialNum
+	return realKey, seqNumber
 }
@@ -10,7 +10,7 @@ import (
 
 func openTestDB(t *testing.T) (*DB, func()) {
 	t.Helper()
-	setup := DefaultSetup
+	setup := DefaultOptions
 	setup.DirPath = t.TempDir() // 返回临时文件夹，测试完成后自动删除
 	db, err := Open(setup)
 	require.NoError(t, err)
@@ -0,0 +1,258 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
+)
+
+// 键值分离（参考 WiscKey）：value 长度超过 Options.ValueThreshold 的记录，其 value 会被写入单独的 blob 文件，
+// 主日志之中只保存一条 LogRecordBlobPtr 类型的记录，value 为 blob 的位置。这样数据文件保持较小，
+// blob 文件中的垃圾则由 RunBlobGC 根据索引之中仍然存活的指针进行回收。
+
+// loadBlobFiles 加载目录下所有的 blob 文件，id 最大的作为活跃 blob 文件
+func (db *DB) loadBlobFiles() error {
+	blobFileIds, err := db.listFileIds(data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for i, fileId := range blobFileIds {
+		blobFile, err := data.OpenBlobFile(db.option.DirPath, uint32(fileId), db.blobFileOptions())
+		if err != nil {
+			return err
+		}
+		if i == len(blobFileIds)-1 {
+			db.blobActiveFile = blobFile
+		} else {
+			db.blobOldFiles[blobFile.FileID] = blobFile
+		}
+	}
+	return nil
+}
+
+// blobFileOptions blob 文件的打开选项，blob 文件不使用预分配、写缓冲以及 Direct IO
+func (db *DB) blobFileOptions() data.FileOptions {
+	opt := data.FileOptions{FileSystem: db.fs}
+	if db.option.ReadOnly {
+		opt.IOType = fio.ReadOnlyFIO
+	}
+	return opt
+}
+
+// closeBlobFiles 关闭所有的 blob 文件
+func (db *DB) closeBlobFiles() error {
+	if db.blobActiveFile != nil {
+		if err := db.blobActiveFile.Close(); err != nil {
+			return err
+		}
+	}
+	for _, blobFile := range db.blobOldFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlob 将 record 写入活跃 blob 文件，返回需要写入主日志的指针记录
+func (db *DB) writeBlob(record *data.LogRecord) (*data.LogRecord, error) {
+	encRecord, size := data.EncodeLogRecord(record)
+	if err := db.rotateBlobFile(size); err != nil {
+		return nil, err
+	}
+
+	offset := db.blobActiveFile.WriteOff
+	if err := db.blobActiveFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.addBytesWritten(size)
+
+	blobPos := &data.LogRecordPos{
+		Fid:    db.blobActiveFile.FileID,
+		Offset: offset,
+		Size:   size,
+	}
+	return &data.LogRecord{
+		Key:    record.Key,
+		Value:  data.EncodeBlobPos(blobPos, size),
+		Type:   data.LogRecordBlobPtr,
+		Bucket: record.Bucket,
+	}, nil
+}
+
+// rotateBlobFile 与 rotateActiveFile 相同，保证活跃 blob 文件存在且能容纳 size 字节
+func (db *DB) rotateBlobFile(size int64) error {
+	if db.blobActiveFile != nil && db.blobActiveFile.WriteOff+size <= db.option.DataFileSize {
+		return nil
+	}
+
+	var newBlobFileID uint32 = 0
+	if db.blobActiveFile != nil {
+		if err := db.blobActiveFile.Sync(); err != nil {
+			return err
+		}
+		db.blobOldFiles[db.blobActiveFile.FileID] = db.blobActiveFile
+		newBlobFileID = db.blobActiveFile.FileID + 1
+	}
+
+	blobFile, err := data.OpenBlobFile(db.option.DirPath, newBlobFileID, db.blobFileOptions())
+	if err != nil {
+		return err
+	}
+	db.blobActiveFile = blobFile
+	return nil
+}
+
+// readBlob 根据主日志记录之中保存的指针，从 blob 文件之中读取真实的 value
+func (db *DB) readBlob(ptr []byte) ([]byte, error) {
+	blobPos, _ := data.DecodeBlobPos(ptr)
+
+	var blobFile *data.DataFile
+	if db.blobActiveFile != nil && db.blobActiveFile.FileID == blobPos.Fid {
+		blobFile = db.blobActiveFile
+	} else if db.blobOldFiles[blobPos.Fid] != nil {
+		blobFile = db.blobOldFiles[blobPos.Fid]
+	} else {
+		return nil, ErrDataFileNotFound
+	}
+
+	rec, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+// liveBlob 索引之中仍然引用着某个 blob 的 key
+type liveBlob struct {
+	bucket *bucket
+	key    []byte
+	pos    *data.LogRecordPos
+	head   *data.LogRecordPos // 链底引用该 blob 的操作数链的链头，重写时写入合并之后的 value
+}
+
+// RunBlobGC 回收 blob 文件之中的垃圾数据。扫描索引找出所有仍被引用的 blob，对于垃圾占比不低于 discardRatio 的旧 blob 文件，
+// 将其中存活的 value 重新写入（同时在主日志之中追加新的指针记录），随后删除该 blob 文件。
+func (db *DB) RunBlobGC(discardRatio float64) error {
+	if discardRatio <= 0 || discardRatio > 1 {
+		return ErrInvalidDiscardRatio
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(db.blobOldFiles) == 0 {
+		return nil
+	}
+
+	// 1. 扫描索引，统计每个 blob 文件之中仍然存活的数据
+	liveSize := make(map[uint32]int64)
+	liveBlobs := make(map[uint32][]liveBlob)
+	for _, bkt := range db.sortedBuckets() {
+		if err := db.collectLiveBlobs(bkt, liveSize, liveBlobs); err != nil {
+			return err
+		}
+	}
+
+	// 2. 找出垃圾占比达到阈值的旧 blob 文件；重写过程中可能产生新的旧文件，因此先确定候选列表
+	var candidates []*data.DataFile
+	for fid, blobFile := range db.blobOldFiles {
+		total := blobFile.WriteOff
+		if total == 0 || float64(total-liveSize[fid])/float64(total) >= discardRatio {
+			candidates = append(candidates, blobFile)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// 3. 将存活的 value 重新写入，appendLogRecord 会把它们写入活跃 blob 文件并追加新的指针记录
+	for _, blobFile := range candidates {
+		for _, lb := range liveBlobs[blobFile.FileID] {
+			value, err := db.readLiveBlob(blobFile, lb)
+			if err != nil {
+				return err
+			}
+			pos, err := db.appendLogRecord(&data.LogRecord{
+				Key:    addSeqToKey(lb.key, nonTxnSeqNumber),
+				Value:  value,
+				Type:   data.LogRecordNormal,
+				Bucket: lb.bucket.id,
+			})
+			if err != nil {
+				return err
+			}
+			if err := db.indexPut(lb.bucket.index, lb.key, pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 4. 新的指针以及 blob 必须先持久化，之后才能删除旧的 blob 文件
+	if db.blobActiveFile != nil {
+		if err := db.blobActiveFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, blobFile := range candidates {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+		if err := db.removeFile(data.FileName(blobFile.FileID, data.BlobFileNameSuffix)); err != nil {
+			return err
+		}
+		delete(db.blobOldFiles, blobFile.FileID)
+	}
+	return nil
+}
+
+// readLiveBlob 读取需要重写的 value；blob 位于操作数链的链底时，读取合并之后的 value，重写之后链随之失效
+func (db *DB) readLiveBlob(blobFile *data.DataFile, lb liveBlob) ([]byte, error) {
+	if lb.head != nil {
+		return db.readValueByPos(lb.head)
+	}
+	rec, _, err := blobFile.ReadLogRecord(lb.pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+// collectLiveBlobs 扫描 bkt 的索引，统计每个 blob 文件之中仍然存活的数据；调用方需要持有 db.lock
+func (db *DB) collectLiveBlobs(bkt *bucket, liveSize map[uint32]int64, liveBlobs map[uint32][]liveBlob) error {
+	iter := bkt.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		pos := iter.Value()
+		dataFile, err := db.getDataFile(pos.Fid)
+		if err != nil {
+			return err
+		}
+		rec, _, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil {
+			return err
+		}
+		var head *data.LogRecordPos
+		if rec.Type == data.LogRecordMerge {
+			if _, rec, _, err = db.walkMergeChain(rec); err != nil {
+				return err
+			}
+			head = pos
+		}
+		if rec == nil || rec.Type != data.LogRecordBlobPtr {
+			continue
+		}
+		blobPos, size := data.DecodeBlobPos(rec.Value)
+		liveSize[blobPos.Fid] += size
+		liveBlobs[blobPos.Fid] = append(liveBlobs[blobPos.Fid], liveBlob{bucket: bkt, key: iter.Key(), pos: blobPos, head: head})
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
+	"bitcask-gown/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func blobFileCount(t *testing.T, dirPath string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+	count := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			count++
+		}
+	}
+	return count
+}
+
+// TestDB_ValueSeparation ensures large values go to blob files and are resolved transparently.
+func TestDB_ValueSeparation(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.ValueThreshold = 32
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	small, large := []byte("small"), utils.RandomValue(128)
+	require.NoError(t, db.Put([]byte("small"), small))
+	require.NoError(t, db.Put([]byte("large"), large))
+	assert.NotNil(t, db.blobActiveFile)
+
+	// 主日志之中只保存了指针
+	pos, _ := db.index.Get([]byte("large"))
+	rec, _, err := db.activeFile.ReadLogRecord(pos.Offset)
+	require.NoError(t, err)
+	assert.Equal(t, data.LogRecordBlobPtr, rec.Type)
+
+	got, err := db.Get([]byte("large"))
+	require.NoError(t, err)
+	assert.Equal(t, large, got)
+
+	// 批量写入同样会进行键值分离
+	batch := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, batch.Put([]byte("batch-large"), large))
+	require.NoError(t, batch.Commit())
+	require.NoError(t, db.Close())
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(reopened)
+	for key, want := range map[string][]byte{"small": small, "large": large, "batch-large": large} {
+		got, err := reopened.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+// TestDB_GetReaderValueSeparation ensures GetReader resolves blob pointers instead of returning them.
+func TestDB_GetReaderValueSeparation(t *testing.T) {
+	setup := DefaultOptions
+	setup.ValueThreshold = 8
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	value := utils.RandomValue(100)
+	require.NoError(t, db.Put([]byte("large"), value))
+	reader, err := db.GetReader([]byte("large"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, value, got)
+}
+
+// TestDB_RunBlobGC ensures garbage blob files are rewritten and removed while live values survive.
+func TestDB_RunBlobGC(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 1024
+	setup.ValueThreshold = 16
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrInvalidDiscardRatio, db.RunBlobGC(0))
+
+	// 反复覆盖同一批 key，产生大量垃圾 blob
+	var latest = make(map[string][]byte)
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 5; i++ {
+			value := utils.RandomValue(64)
+			latest[string(utils.GetTestKey(i))] = value
+			require.NoError(t, db.Put(utils.GetTestKey(i), value))
+		}
+	}
+	before := blobFileCount(t, setup.DirPath)
+	require.NoError(t, db.RunBlobGC(0.5))
+	assert.Less(t, blobFileCount(t, setup.DirPath), before)
+
+	for key, want := range latest {
+		got, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	require.NoError(t, db.Close())
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(reopened)
+	for key, want := range latest {
+		got, err := reopened.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+// syncOrderFS tracks which files hold unsynced writes and records every data file synced while a blob file was still dirty.
+type syncOrderFS struct {
+	fio.FileSystem
+	mu         sync.Mutex
+	dirty      map[string]bool
+	violations []string
+}
+
+func (fs *syncOrderFS) OpenFile(name string, ioType fio.FileIOType, preallocSize int64) (fio.IOManager, error) {
+	ioManager, err := fs.FileSystem.OpenFile(name, ioType, preallocSize)
+	if err != nil {
+		return nil, err
+	}
+	return &syncOrderIO{IOManager: ioManager, name: filepath.Base(name), fs: fs}, nil
+}
+
+type syncOrderIO struct {
+	fio.IOManager
+	name string
+	fs   *syncOrderFS
+}
+
+func (f *syncOrderIO) Write(buf []byte) (int, error) {
+	f.fs.mu.Lock()
+	f.fs.dirty[f.name] = true
+	f.fs.mu.Unlock()
+	return f.IOManager.Write(buf)
+}
+
+func (f *syncOrderIO) Sync() error {
+	f.fs.mu.Lock()
+	if strings.HasSuffix(f.name, data.DataFileNameSuffix) {
+		for name, dirty := range f.fs.dirty {
+			if dirty && strings.HasSuffix(name, data.BlobFileNameSuffix) {
+				f.fs.violations = append(f.fs.violations, f.name+" before "+name)
+			}
+		}
+	}
+	f.fs.dirty[f.name] = false
+	f.fs.mu.Unlock()
+	return f.IOManager.Sync()
+}
+
+// TestDB_RotateSyncsBlobFirst ensures rotating the data file never syncs blob pointers before the blobs they reference.
+func TestDB_RotateSyncsBlobFirst(t *testing.T) {
+	fs := &syncOrderFS{FileSystem: fio.DefaultFileSystem, dirty: make(map[string]bool)}
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 1024
+	setup.ValueThreshold = 8
+	setup.FileSystem = fs
+	db, err := Open(setup)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Greater(t, len(db.oldFiles), 0)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	assert.Empty(t, fs.violations)
+}
@@ -0,0 +1,200 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/index"
+	"sort"
+)
+
+// Bucket 将一个数据库划分为多个独立的命名空间：所有 bucket 共享同一份日志以及数据文件，记录之中携带所属 bucket 的 id，
+// 每个 bucket 拥有自己的索引。bucket 在第一次写入时自动创建，删除一个 bucket 只需要追加一条记录并丢弃它的索引。
+// 默认的 bucket（即直接通过 DB 读写的键空间）id 为 0，名称为空。
+// bucket 之中的写入不会发布 Watch 事件，也不能通过 PutStream 以及迭代器访问。
+
+// defaultBucketID 默认 bucket 的 id
+const defaultBucketID uint32 = 0
+
+// bucket 一个命名空间在内存之中的状态，创建之后 id、name 以及 index 不再改变
+type bucket struct {
+	id    uint32
+	name  string
+	index index.Indexer
+}
+
+// Bucket 名称为 name 的 bucket 的句柄。句柄本身不持有状态，bucket 被删除之后通过它写入会重新创建一个空的 bucket
+type Bucket struct {
+	db   *DB
+	name string
+}
+
+// Bucket 返回名称为 name 的 bucket 的句柄，name 为空时即默认的 bucket，与直接通过 db 读写等价
+func (db *DB) Bucket(name string) *Bucket {
+	return &Bucket{db: db, name: name}
+}
+
+// Name 返回 bucket 的名称
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Put 向 bucket 之中写入一条数据，bucket 不存在时先创建
+func (b *Bucket) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := b.db.checkWritable(); err != nil {
+		return err
+	}
+	bkt, err := b.db.openBucket(b.name)
+	if err != nil {
+		return err
+	}
+	return b.db.put(bkt, key, value)
+}
+
+// Get 读取 bucket 之中 key 对应的 value，bucket 不存在时返回 ErrKeyNotFound
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	return b.db.get(b.name, key)
+}
+
+// Delete 删除 bucket 之中的一条数据，bucket 或者 key 不存在时不视为错误
+func (b *Bucket) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := b.db.checkWritable(); err != nil {
+		return err
+	}
+	bkt := b.db.lookupBucket(b.name)
+	if bkt == nil {
+		return nil
+	}
+	return b.db.delete(bkt, key)
+}
+
+// Buckets 返回所有 bucket 的名称（不包括默认的 bucket），按照名称排序
+func (db *DB) Buckets() []string {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropBucket 删除名称为 name 的 bucket 以及其中所有的数据。只追加一条删除记录并丢弃 bucket 的索引，不需要逐个删除 key
+func (db *DB) DropBucket(name string) error {
+	if name == "" {
+		return ErrInvalidBucketName
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bkt := db.buckets[name]
+	if bkt == nil {
+		return ErrBucketNotFound
+	}
+	pos, err := db.appendBucketRecord(data.LogRecordBucketDrop, bkt)
+	if err != nil {
+		return err
+	}
+	db.reclaimSize += pos.Size
+	db.removeBucket(bkt)
+	db.logger.Info("bucket dropped", "bucket", name, "id", bkt.id)
+	return nil
+}
+
+// lookupBucket 返回名称为 name 的 bucket，不存在时返回 nil
+func (db *DB) lookupBucket(name string) *bucket {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.bucketByName(name)
+}
+
+// bucketByName 返回名称为 name 的 bucket，不存在时返回 nil；调用方需要持有 db.lock
+func (db *DB) bucketByName(name string) *bucket {
+	if name == "" {
+		return db.defaultBucket
+	}
+	return db.buckets[name]
+}
+
+// openBucket 返回名称为 name 的 bucket，不存在时追加一条创建记录来创建它
+func (db *DB) openBucket(name string) (*bucket, error) {
+	if bkt := db.lookupBucket(name); bkt != nil {
+		return bkt, nil
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.createBucket(name)
+}
+
+// createBucket 创建名称为 name 的 bucket，已经存在时直接返回；调用方需要持有 db.lock
+func (db *DB) createBucket(name string) (*bucket, error) {
+	if bkt := db.bucketByName(name); bkt != nil {
+		return bkt, nil
+	}
+
+	bkt := &bucket{id: db.nextBucketID, name: name, index: index.NewBTree()}
+	if _, err := db.appendBucketRecord(data.LogRecordBucketCreate, bkt); err != nil {
+		return nil, err
+	}
+	db.addBucket(bkt)
+	db.logger.Info("bucket created", "bucket", name, "id", bkt.id)
+	return bkt, nil
+}
+
+// appendBucketRecord 追加一条创建或者删除 bucket 的记录，开启 SyncWrites 时立即持久化；调用方需要持有 db.lock
+func (db *DB) appendBucketRecord(typ data.LogRecordType, bkt *bucket) (*data.LogRecordPos, error) {
+	return db.appendLogRecord(&data.LogRecord{
+		Key:    addSeqToKey([]byte(bkt.name), nonTxnSeqNumber),
+		Type:   typ,
+		Bucket: bkt.id,
+	})
+}
+
+// addBucket 登记一个 bucket，之后分配的 id 都大于它的 id，被删除的 bucket 的 id 不会被重新使用
+func (db *DB) addBucket(bkt *bucket) {
+	db.buckets[bkt.name] = bkt
+	db.bucketIDs[bkt.id] = bkt
+	db.nextBucketID = max(db.nextBucketID, bkt.id+1)
+}
+
+// removeBucket 移除一个 bucket，其中所有存活的记录都计入可回收的空间
+func (db *DB) removeBucket(bkt *bucket) {
+	iter := bkt.index.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		db.reclaimSize += liveSize(iter.Value())
+	}
+	iter.Close()
+
+	delete(db.buckets, bkt.name)
+	delete(db.bucketIDs, bkt.id)
+}
+
+// sortedBuckets 返回包括默认 bucket 在内的所有 bucket，按照 id 排序；调用方需要持有 db.lock
+func (db *DB) sortedBuckets() []*bucket {
+	buckets := make([]*bucket, 0, len(db.bucketIDs))
+	for _, bkt := range db.bucketIDs {
+		buckets = append(buckets, bkt)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].id < buckets[j].id })
+	return buckets
+}
+
+// keyNum 返回所有 bucket 之中 key 的数量；调用方需要持有 db.lock
+func (db *DB) keyNum() int {
+	var n int
+	for _, bkt := range db.bucketIDs {
+		n += bkt.index.Size()
+	}
+	return n
+}
@@ -0,0 +1,158 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBucket_Isolation ensures the same key lives independently in different buckets and survives a restart.
+func TestBucket_Isolation(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4 * 1024
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	users, orders := db.Bucket("users"), db.Bucket("orders")
+	key := []byte("k")
+	require.NoError(t, db.Put(key, []byte("default")))
+	require.NoError(t, users.Put(key, []byte("user")))
+	require.NoError(t, orders.Put(key, []byte("order")))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, users.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	require.NoError(t, orders.Delete(key))
+	// 不存在的 bucket 之中删除以及读取不会创建它
+	require.NoError(t, db.Bucket("missing").Delete(key))
+	_, err = db.Bucket("missing").Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrKeyIsEmpty, users.Put(nil, []byte("v")))
+
+	check := func(db *DB) {
+		got, err := db.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("default"), got)
+		got, err = db.Bucket("users").Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("user"), got)
+		_, err = db.Bucket("orders").Get(key)
+		assert.Equal(t, ErrKeyNotFound, err)
+		assert.Equal(t, []string{"orders", "users"}, db.Buckets())
+		assert.Equal(t, 102, db.Stat().KeyNum)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	check(db)
+}
+
+// TestBucket_Drop ensures dropping a bucket discards its data cheaply, persists across restarts and frees the name.
+func TestBucket_Drop(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	tmp := db.Bucket("tmp")
+	for i := 0; i < 50; i++ {
+		require.NoError(t, tmp.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	require.NoError(t, db.Put([]byte("k"), []byte("v")))
+	reclaimBefore := db.Stat().ReclaimableSize
+
+	require.NoError(t, db.DropBucket("tmp"))
+	assert.Equal(t, ErrBucketNotFound, db.DropBucket("tmp"))
+	assert.Equal(t, ErrInvalidBucketName, db.DropBucket(""))
+	assert.Empty(t, db.Buckets())
+	assert.Equal(t, 1, db.Stat().KeyNum)
+	assert.Greater(t, db.Stat().ReclaimableSize, reclaimBefore)
+	_, err = tmp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 同名的 bucket 重新创建之后是一个空的 bucket
+	require.NoError(t, tmp.Put([]byte("fresh"), []byte("v")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	tmp = db.Bucket("tmp")
+	_, err = tmp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	got, err := tmp.Get([]byte("fresh"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), got)
+	assert.Equal(t, []string{"tmp"}, db.Buckets())
+	assert.Equal(t, 2, db.Stat().KeyNum)
+}
+
+// TestBucket_WriteBatch ensures a batch spanning several buckets commits atomically and is replayed after a restart.
+func TestBucket_WriteBatch(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, db.Bucket("a").Put([]byte("old"), []byte("v")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put([]byte("k"), []byte("default")))
+	require.NoError(t, wb.Bucket("a").Put([]byte("k"), []byte("a")))
+	require.NoError(t, wb.Bucket("a").Delete([]byte("old")))
+	require.NoError(t, wb.Bucket("b").Put([]byte("k"), []byte("b")))
+
+	// 提交之前其他读者看不到任何写入，bucket b 也尚未创建
+	_, err = db.Bucket("a").Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, []string{"a"}, db.Buckets())
+	require.NoError(t, wb.Commit())
+
+	check := func(db *DB) {
+		for _, name := range []string{"", "a", "b"} {
+			got, err := db.Bucket(name).Get([]byte("k"))
+			require.NoError(t, err)
+			want := name
+			if name == "" {
+				want = "default"
+			}
+			assert.Equal(t, []byte(want), got)
+		}
+		_, err := db.Bucket("a").Get([]byte("old"))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	check(db)
+}
+
+// TestBucket_BlobGC ensures blob GC rewrites live values of every bucket back into their own bucket.
+func TestBucket_BlobGC(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 4 * 1024
+	setup.ValueThreshold = 64
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	blobs := db.Bucket("blobs")
+	for i := 0; i < 100; i++ {
+		require.NoError(t, blobs.Put(utils.GetTestKey(i%10), utils.RandomValue(256)))
+	}
+	want, err := blobs.Get(utils.GetTestKey(3))
+	require.NoError(t, err)
+
+	require.NoError(t, db.RunBlobGC(0.5))
+	got, err := blobs.Get(utils.GetTestKey(3))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
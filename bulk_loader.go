@@ -0,0 +1,429 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
+	"bitcask-gown/index"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// 批量导入：BulkLoader 绕过 DB 的写入路径，直接以较大的写缓冲顺序写入新的数据文件，同时在内存之中构建这些记录的索引，
+// 并为每个数据文件写出提示文件，之后打开数据库时无需扫描这些数据文件。输入的 key 有序或者无序均可，
+// 同一个 key 出现多次时以最后一次为准。导入的数据不进行键值分离，也不会发布 Watch 事件。
+//
+// 通过 DB.NewBulkLoader 创建时，文件先写入数据目录下的暂存目录，Finish 时在持有 db.lock 的情况下重命名为紧跟在活跃文件之后的旧文件，
+// 因此导入的数据覆盖 Finish 之前写入的同名 key，又被之后的写入覆盖，与重启之后重放的结果一致。
+// 重命名之前先在暂存目录之中写入清单，记录文件移动到的位置，全部文件登记完成之后删除清单。打开数据库时，
+// 如果暂存目录之中仍然存在清单，说明登记没有完成，将已经移动到数据目录之中的文件删除；其余残留的暂存目录同样直接删除。
+// 通过 NewBulkLoader 创建时，文件直接写入一个没有被打开的数据目录，Finish 之后即可通过 Open 打开。
+
+const (
+	// bulkWriteBufferSize 批量导入时数据文件以及提示文件的写缓冲区大小
+	bulkWriteBufferSize = 4 * 1024 * 1024
+	// bulkStagingDirPrefix 数据目录下批量导入暂存目录的名称前缀
+	bulkStagingDirPrefix = "bulk-"
+	// bulkManifestFileName 暂存目录之中登记清单的文件名
+	bulkManifestFileName = "MANIFEST"
+)
+
+// BulkLoader 批量导入数据，不能并发使用
+type BulkLoader struct {
+	db       *DB // 为 nil 时直接写入 dirPath，文件 id 从 firstID 开始
+	fs       fio.FileSystem
+	dirPath  string
+	fileSize int64
+	firstID  uint32
+
+	dataFile    *data.DataFile
+	hintFile    *data.DataFile
+	fileNum     uint32       // 已经创建的数据文件数量，第 i 个文件的 id 为 firstID + i
+	index       *index.BTree // 导入的 key 的索引，位置之中的 Fid 为文件的序号
+	reclaimSize int64        // 被之后的同名 key 覆盖的记录大小
+	finished    bool
+}
+
+// NewBulkLoader 创建一个向 db 导入数据的 BulkLoader，导入的数据在 Finish 之后才对 db 可见
+func (db *DB) NewBulkLoader() (*BulkLoader, error) {
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+	stagingDir := db.fs.Join(db.option.DirPath, fmt.Sprintf("%s%d", bulkStagingDirPrefix, time.Now().UnixNano()))
+	if err := db.fs.MkdirAll(stagingDir); err != nil {
+		return nil, err
+	}
+	return &BulkLoader{
+		db:       db,
+		fs:       db.fs,
+		dirPath:  stagingDir,
+		fileSize: db.option.DataFileSize,
+		index:    index.NewBTree(),
+	}, nil
+}
+
+// NewBulkLoader 创建一个直接向 opt.DirPath 写入数据文件的 BulkLoader，导入期间该目录不能被打开。
+// 目录之中已经存在数据文件时，导入的文件排在它们之后
+func NewBulkLoader(opt Options) (*BulkLoader, error) {
+	if err := checkOptions(opt); err != nil {
+		return nil, err
+	}
+	if opt.InMemory || opt.ReadOnly {
+		return nil, ErrInvalidBulkLoader
+	}
+	fileSystem := opt.FileSystem
+	if fileSystem == nil {
+		fileSystem = fio.DefaultFileSystem
+	}
+	if err := fileSystem.MkdirAll(opt.DirPath); err != nil {
+		return nil, err
+	}
+	fileIds, err := listFileIds(fileSystem, opt.DirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstID uint32
+	if len(fileIds) > 0 {
+		firstID = uint32(fileIds[len(fileIds)-1]) + 1
+	}
+	return &BulkLoader{
+		fs:       fileSystem,
+		dirPath:  opt.DirPath,
+		fileSize: opt.DataFileSize,
+		firstID:  firstID,
+		index:    index.NewBTree(),
+	}, nil
+}
+
+// Add 导入一条数据
+func (bl *BulkLoader) Add(key, value []byte) error {
+	if bl.finished {
+		return ErrBulkLoaderFinished
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   addSeqToKey(key, nonTxnSeqNumber),
+		Value: value,
+		Type:  data.LogRecordNormal,
+	})
+	if bl.dataFile == nil || (bl.dataFile.WriteOff > 0 && bl.dataFile.WriteOff+size > bl.fileSize) {
+		if err := bl.rotate(); err != nil {
+			return err
+		}
+	}
+
+	pos := &data.LogRecordPos{Fid: bl.fileNum - 1, Offset: bl.dataFile.WriteOff, Size: size}
+	if err := bl.dataFile.Write(encRecord); err != nil {
+		return err
+	}
+	if err := bl.hintFile.WriteHintRecord(key, pos, binary.LittleEndian.Uint32(encRecord)); err != nil {
+		return err
+	}
+	if oldPos, exist := bl.index.Get(key); exist {
+		bl.reclaimSize += oldPos.Size
+	}
+	bl.index.Put(key, pos)
+	return nil
+}
+
+// Finish 写完所有的文件并持久化；通过 DB.NewBulkLoader 创建时，随后将文件登记到 db 之中并更新索引
+func (bl *BulkLoader) Finish() error {
+	if bl.finished {
+		return ErrBulkLoaderFinished
+	}
+	bl.finished = true
+
+	if err := bl.closeFiles(); err != nil {
+		return err
+	}
+	if bl.db == nil {
+		return nil
+	}
+	if err := bl.db.registerBulkFiles(bl); err != nil {
+		// 登记失败时文件都已经移回暂存目录，导入的数据无法再被使用，将其删除
+		_ = bl.removeFiles()
+		return err
+	}
+	// 暂存目录此时已经为空，删除失败（例如内存文件系统之中目录并不存在）不影响导入的结果
+	_ = bl.fs.Remove(bl.dirPath)
+	return nil
+}
+
+// Discard 放弃导入，删除已经写入的文件
+func (bl *BulkLoader) Discard() error {
+	if bl.finished {
+		return ErrBulkLoaderFinished
+	}
+	bl.finished = true
+
+	if err := bl.closeFiles(); err != nil {
+		return err
+	}
+	return bl.removeFiles()
+}
+
+// removeFiles 删除已经写入的文件，通过 DB.NewBulkLoader 创建时同时删除暂存目录
+func (bl *BulkLoader) removeFiles() error {
+	for i := uint32(0); i < bl.fileNum; i++ {
+		for _, suffix := range []string{data.DataFileNameSuffix, data.HintFileNameSuffix} {
+			if err := bl.fs.Remove(bl.fs.Join(bl.dirPath, data.FileName(bl.firstID+i, suffix))); err != nil {
+				return err
+			}
+		}
+	}
+	if bl.db != nil {
+		_ = bl.fs.Remove(bl.dirPath)
+	}
+	return nil
+}
+
+// rotate 写完当前的数据文件以及提示文件，创建下一对文件
+func (bl *BulkLoader) rotate() error {
+	if err := bl.closeFiles(); err != nil {
+		return err
+	}
+
+	opt := data.FileOptions{FileSystem: bl.fs, WriteBufferSize: bulkWriteBufferSize}
+	fileId := bl.firstID + bl.fileNum
+	dataFile, err := data.OpenDataFileWithOptions(bl.dirPath, fileId, opt)
+	if err != nil {
+		return err
+	}
+	hintFile, err := data.OpenHintFile(bl.dirPath, fileId, opt)
+	if err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+	bl.dataFile, bl.hintFile = dataFile, hintFile
+	bl.fileNum++
+	return nil
+}
+
+// closeFiles 持久化并关闭当前的数据文件以及提示文件；提示文件在数据文件之后持久化，不会指向不存在的记录
+func (bl *BulkLoader) closeFiles() error {
+	if bl.dataFile == nil {
+		return nil
+	}
+	dataFile, hintFile := bl.dataFile, bl.hintFile
+	bl.dataFile, bl.hintFile = nil, nil
+
+	for _, file := range []*data.DataFile{dataFile, hintFile} {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerBulkFiles 将 bl 写入的文件重命名为紧跟在活跃文件之后的旧文件，并将导入的索引合并到 db 的索引之中
+func (db *DB) registerBulkFiles(bl *BulkLoader) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if bl.fileNum == 0 {
+		return nil
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var firstID uint32
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		firstID = db.activeFile.FileID + 1
+	}
+
+	// 移动任何文件之前先持久化清单，中途崩溃时，打开数据库时根据清单删除已经移动的文件
+	if err := writeBulkManifest(db.fs, bl.dirPath, firstID, bl.fileNum); err != nil {
+		return err
+	}
+	manifest := db.fs.Join(bl.dirPath, bulkManifestFileName)
+
+	// 先将所有的文件移动到数据目录之中并打开，任何一步失败时，将已经移动的文件移回暂存目录，db 的状态保持不变
+	var moved [][2]string
+	var opened []*data.DataFile
+	undo := func() {
+		for _, dataFile := range opened {
+			_ = dataFile.Close()
+		}
+		var err error
+		for i := len(moved) - 1; i >= 0; i-- {
+			if renameErr := db.fs.Rename(moved[i][1], moved[i][0]); renameErr != nil {
+				db.logger.Error("rollback bulk load file failed", "file", moved[i][1], "err", renameErr)
+				err = renameErr
+			}
+		}
+		// 清单删除之后，之后的写入才能使用这些文件 id；否则拒绝写入，留待下一次打开时根据清单清理
+		if err == nil {
+			err = db.fs.Remove(manifest)
+		}
+		if err != nil && db.syncErr == nil {
+			db.syncErr = err
+		}
+	}
+	for i := uint32(0); i < bl.fileNum; i++ {
+		fileId := firstID + i
+		for _, suffix := range []string{data.DataFileNameSuffix, data.HintFileNameSuffix} {
+			src := bl.fs.Join(bl.dirPath, data.FileName(bl.firstID+i, suffix))
+			dst := db.fs.Join(db.option.DirPath, data.FileName(fileId, suffix))
+			if err := db.fs.Rename(src, dst); err != nil {
+				undo()
+				return err
+			}
+			moved = append(moved, [2]string{src, dst})
+		}
+		dataFile, err := data.OpenDataFileWithOptions(db.option.DirPath, fileId, db.oldFileOptions())
+		if err != nil {
+			undo()
+			return err
+		}
+		opened = append(opened, dataFile)
+	}
+	// 新的活跃文件紧跟在最后一个导入的文件之后
+	activeFile, err := data.OpenDataFileWithOptions(db.option.DirPath, firstID+bl.fileNum, db.dataFileOptions())
+	if err != nil {
+		undo()
+		return err
+	}
+	// 删除清单之后登记即完成
+	if err := db.fs.Remove(manifest); err != nil {
+		_ = activeFile.Close()
+		_ = db.removeFile(data.FileName(activeFile.FileID, data.DataFileNameSuffix))
+		undo()
+		return err
+	}
+
+	if db.activeFile != nil {
+		db.oldFiles[db.activeFile.FileID] = db.activeFile
+	}
+	for _, dataFile := range opened {
+		db.oldFiles[dataFile.FileID] = dataFile
+	}
+	db.activeFile = activeFile
+
+	iter := bl.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		pos := iter.Value()
+		pos.Fid += firstID
+		if err := db.indexPut(db.index, iter.Key(), pos); err != nil {
+			return err
+		}
+	}
+	db.reclaimSize += bl.reclaimSize
+	db.logger.Info("bulk load registered", "files", bl.fileNum, "first_file", firstID, "keys", bl.index.Size())
+	return nil
+}
+
+// writeBulkManifest 在暂存目录 dirPath 之中写入并持久化登记清单：导入的文件移动到的第一个 id、文件数量以及校验值
+func writeBulkManifest(fileSystem fio.FileSystem, dirPath string, firstID, fileNum uint32) error {
+	buf := make([]byte, 12)
+	binary.LittleEndian.PutUint32(buf[0:], firstID)
+	binary.LittleEndian.PutUint32(buf[4:], fileNum)
+	binary.LittleEndian.PutUint32(buf[8:], crc32.ChecksumIEEE(buf[:8]))
+
+	file, err := fileSystem.OpenFile(fileSystem.Join(dirPath, bulkManifestFileName), fio.StandardFIO, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readBulkManifest 读取暂存目录 dirPath 之中的登记清单；清单不完整时（写入清单的过程中崩溃），文件还没有移动，返回 ok 为 false
+func readBulkManifest(fileSystem fio.FileSystem, dirPath string) (firstID, fileNum uint32, ok bool, err error) {
+	file, err := fileSystem.OpenFile(fileSystem.Join(dirPath, bulkManifestFileName), fio.StandardFIO, 0)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 12)
+	if n, _ := file.Read(buf, 0); n < len(buf) {
+		return 0, 0, false, nil
+	}
+	if crc32.ChecksumIEEE(buf[:8]) != binary.LittleEndian.Uint32(buf[8:]) {
+		return 0, 0, false, nil
+	}
+	return binary.LittleEndian.Uint32(buf[0:]), binary.LittleEndian.Uint32(buf[4:]), true, nil
+}
+
+// recoverBulkLoads 清理上一次运行时残留的批量导入暂存目录，登记到一半的导入整体回滚
+func (db *DB) recoverBulkLoads() error {
+	names, err := db.fs.ReadDir(db.option.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, bulkStagingDirPrefix) {
+			continue
+		}
+		if err := db.discardBulkStaging(db.fs.Join(db.option.DirPath, name)); err != nil {
+			return err
+		}
+		db.logger.Warn("unfinished bulk load discarded", "dir", name)
+	}
+	return nil
+}
+
+// discardBulkStaging 删除暂存目录 stagingDir；其中存在清单时，先删除已经移动到数据目录之中的文件以及新建的活跃文件
+func (db *DB) discardBulkStaging(stagingDir string) error {
+	names, err := db.fs.ReadDir(stagingDir)
+	if err != nil {
+		return err
+	}
+	hasManifest := false
+	for _, name := range names {
+		hasManifest = hasManifest || name == bulkManifestFileName
+	}
+	if hasManifest {
+		firstID, fileNum, ok, err := readBulkManifest(db.fs, stagingDir)
+		if err != nil {
+			return err
+		}
+		// 导入的文件 id 为 [firstID, firstID+fileNum)，新的活跃文件 id 为 firstID+fileNum
+		for i := uint32(0); ok && i <= fileNum; i++ {
+			for _, suffix := range []string{data.DataFileNameSuffix, data.HintFileNameSuffix} {
+				err := db.removeFile(data.FileName(firstID+i, suffix))
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+	}
+	// 清单最后删除，中途失败时下一次打开仍然会回滚
+	for _, name := range names {
+		if name == bulkManifestFileName {
+			continue
+		}
+		if err := db.fs.Remove(db.fs.Join(stagingDir, name)); err != nil {
+			return err
+		}
+	}
+	if hasManifest {
+		if err := db.fs.Remove(db.fs.Join(stagingDir, bulkManifestFileName)); err != nil {
+			return err
+		}
+	}
+	return db.fs.Remove(stagingDir)
+}
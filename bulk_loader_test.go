@@ -0,0 +1,335 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
+	"bitcask-gown/utils"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBulkLoader_Directory ensures a prepared directory opens from hint files and keeps accepting writes.
+func TestBulkLoader_Directory(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 8 * 1024
+	loader, err := NewBulkLoader(setup)
+	require.NoError(t, err)
+
+	// 无序的输入，并且包含重复的 key，以最后一次为准
+	want := make(map[string][]byte)
+	for _, i := range rand.Perm(500) {
+		key, value := utils.GetTestKey(i%300), utils.RandomValue(32)
+		require.NoError(t, loader.Add(key, value))
+		want[string(key)] = value
+	}
+	assert.Equal(t, ErrKeyIsEmpty, loader.Add(nil, []byte("v")))
+	require.NoError(t, loader.Finish())
+	assert.Equal(t, ErrBulkLoaderFinished, loader.Add([]byte("k"), []byte("v")))
+	assert.Equal(t, ErrBulkLoaderFinished, loader.Finish())
+
+	hints, err := filepath.Glob(filepath.Join(setup.DirPath, "*"+data.HintFileNameSuffix))
+	require.NoError(t, err)
+	dataFiles, err := filepath.Glob(filepath.Join(setup.DirPath, "*"+data.DataFileNameSuffix))
+	require.NoError(t, err)
+	assert.Greater(t, len(dataFiles), 1)
+	assert.Len(t, hints, len(dataFiles))
+
+	check := func(db *DB) {
+		assert.Equal(t, len(want), db.Stat().KeyNum)
+		for key, value := range want {
+			got, err := db.Get([]byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, value, got)
+		}
+	}
+	db, err := Open(setup)
+	require.NoError(t, err)
+	check(db)
+
+	// 导入的最后一个文件成为活跃文件，之后追加的记录不在提示文件之中，重启时需要继续扫描
+	require.NoError(t, db.Put(utils.GetTestKey(0), []byte("updated")))
+	require.NoError(t, db.Put([]byte("new"), []byte("v")))
+	want[string(utils.GetTestKey(0))] = []byte("updated")
+	want["new"] = []byte("v")
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	check(db)
+
+	_, err = NewBulkLoader(Options{DirPath: setup.DirPath, DataFileSize: 1024, InMemory: true})
+	assert.Equal(t, ErrInvalidBulkLoader, err)
+}
+
+// TestBulkLoader_OpenDB ensures files loaded into an open DB sit between earlier and later writes, also after a restart.
+func TestBulkLoader_OpenDB(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 8 * 1024
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("existing"), []byte("old")))
+	require.NoError(t, db.Put([]byte("untouched"), []byte("v")))
+
+	loader, err := db.NewBulkLoader()
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, loader.Add([]byte(fmt.Sprintf("bulk-%03d", i)), utils.RandomValue(32)))
+	}
+	require.NoError(t, loader.Add([]byte("existing"), []byte("bulk")))
+	// 导入完成之前，导入的数据不可见
+	_, err = db.Get([]byte("bulk-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	require.NoError(t, loader.Finish())
+
+	require.NoError(t, db.Put([]byte("bulk-001"), []byte("later")))
+	check := func(db *DB) {
+		for key, value := range map[string]string{"existing": "bulk", "untouched": "v", "bulk-001": "later"} {
+			got, err := db.Get([]byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, []byte(value), got)
+		}
+		_, err := db.Get([]byte("bulk-299"))
+		assert.NoError(t, err)
+		assert.Equal(t, 302, db.Stat().KeyNum)
+	}
+	check(db)
+
+	// 暂存目录已经被删除
+	entries, err := os.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, entry.IsDir(), entry.Name())
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	check(db)
+}
+
+// TestBulkLoader_Discard ensures a discarded load leaves neither files nor keys behind.
+func TestBulkLoader_Discard(t *testing.T) {
+	setup := DefaultOptions
+	setup.FileSystem = fio.NewMemStore()
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	loader, err := db.NewBulkLoader()
+	require.NoError(t, err)
+	require.NoError(t, loader.Add([]byte("k"), []byte("v")))
+	require.NoError(t, loader.Discard())
+	assert.Equal(t, ErrBulkLoaderFinished, loader.Finish())
+
+	_, err = db.Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	names, err := setup.FileSystem.ReadDir(loader.dirPath)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	// 内存文件系统之上同样可以导入
+	loader, err = db.NewBulkLoader()
+	require.NoError(t, err)
+	require.NoError(t, loader.Add([]byte("k"), []byte("v")))
+	require.NoError(t, loader.Finish())
+	got, err := db.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), got)
+}
+
+// TestBulkLoader_StaleHint ensures a hint file that does not describe its data file is ignored and the data file is rescanned.
+func TestBulkLoader_StaleHint(t *testing.T) {
+	load := func(dirPath, prefix string) map[string][]byte {
+		setup := DefaultOptions
+		setup.DirPath = dirPath
+		loader, err := NewBulkLoader(setup)
+		require.NoError(t, err)
+		want := make(map[string][]byte)
+		for i := 0; i < 50; i++ {
+			key, value := []byte(fmt.Sprintf("%s-%03d", prefix, i)), utils.RandomValue(32)
+			require.NoError(t, loader.Add(key, value))
+			want[string(key)] = value
+		}
+		require.NoError(t, loader.Finish())
+		return want
+	}
+	dirPath, otherDir := t.TempDir(), t.TempDir()
+	want := load(dirPath, "aaa")
+	load(otherDir, "bbb")
+
+	// 另一份数据的提示文件，记录的位置以及长度与这份数据完全相同，只有 key 以及 CRC 不同
+	hintName := data.FileName(0, data.HintFileNameSuffix)
+	hint, err := os.ReadFile(filepath.Join(otherDir, hintName))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dirPath, hintName), hint, 0644))
+
+	setup := DefaultOptions
+	setup.DirPath = dirPath
+	db, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, len(want), db.Stat().KeyNum)
+	for key, value := range want {
+		got, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, value, got)
+	}
+	_, err = db.Get([]byte("bbb-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// renameFailingFS fails the failAt-th call to Rename.
+type renameFailingFS struct {
+	fio.FileSystem
+	calls, failAt int
+}
+
+func (fs *renameFailingFS) Rename(oldName, newName string) error {
+	fs.calls++
+	if fs.calls == fs.failAt {
+		return fio.ErrInjectedFault
+	}
+	return fs.FileSystem.Rename(oldName, newName)
+}
+
+// TestBulkLoader_RegisterRollback ensures a rename failing partway through Finish moves the renamed files back and leaves the DB unchanged.
+func TestBulkLoader_RegisterRollback(t *testing.T) {
+	fileSystem := &renameFailingFS{FileSystem: fio.DefaultFileSystem}
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 8 * 1024
+	setup.FileSystem = fileSystem
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("existing"), []byte("v")))
+	before, err := os.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+
+	loader, err := db.NewBulkLoader()
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, loader.Add([]byte(fmt.Sprintf("bulk-%03d", i)), utils.RandomValue(32)))
+	}
+	// 第二个数据文件的重命名失败，此时第一个数据文件以及提示文件已经移动到数据目录之中
+	fileSystem.failAt = 3
+	assert.ErrorIs(t, loader.Finish(), fio.ErrInjectedFault)
+	require.Greater(t, loader.fileNum, uint32(1))
+
+	after, err := os.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+	assert.Equal(t, len(before), len(after))
+	_, err = db.Get([]byte("bulk-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 之后的写入以及重启都不受影响
+	require.NoError(t, db.Put([]byte("later"), []byte("v")))
+	require.NoError(t, db.Close())
+	setup.FileSystem = nil
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 2, db.Stat().KeyNum)
+	_, err = db.Get([]byte("bulk-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// crashingFS fails every Rename and Remove from the failAt-th Rename on, as if the process died there.
+type crashingFS struct {
+	fio.FileSystem
+	calls, failAt int
+}
+
+func (fs *crashingFS) Rename(oldName, newName string) error {
+	fs.calls++
+	if fs.failAt > 0 && fs.calls >= fs.failAt {
+		return fio.ErrInjectedFault
+	}
+	return fs.FileSystem.Rename(oldName, newName)
+}
+
+func (fs *crashingFS) Remove(name string) error {
+	if fs.failAt > 0 && fs.calls >= fs.failAt {
+		return fio.ErrInjectedFault
+	}
+	return fs.FileSystem.Remove(name)
+}
+
+// TestBulkLoader_RecoverPartialRegister ensures Open rolls back a registration interrupted after some files were moved.
+func TestBulkLoader_RecoverPartialRegister(t *testing.T) {
+	fileSystem := &crashingFS{FileSystem: fio.DefaultFileSystem}
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 8 * 1024
+	setup.FileSystem = fileSystem
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("existing"), []byte("v")))
+	before, err := os.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+
+	loader, err := db.NewBulkLoader()
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, loader.Add([]byte(fmt.Sprintf("bulk-%03d", i)), utils.RandomValue(32)))
+	}
+	// 第一个数据文件以及提示文件移动之后“崩溃”，移回暂存目录同样失败，之后的写入被拒绝
+	fileSystem.failAt = 3
+	assert.ErrorIs(t, loader.Finish(), fio.ErrInjectedFault)
+	assert.ErrorIs(t, db.Put([]byte("later"), []byte("v")), fio.ErrInjectedFault)
+	require.NoError(t, db.Close())
+
+	setup.FileSystem = nil
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	after, err := os.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+	assert.Equal(t, len(before), len(after))
+	assert.Equal(t, 1, db.Stat().KeyNum)
+	_, err = db.Get([]byte("bulk-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 回滚之后的写入以及重启不受影响
+	require.NoError(t, db.Put([]byte("later"), []byte("v")))
+	db = reopenDB(t, db)
+	assert.Equal(t, 2, db.Stat().KeyNum)
+	_, err = db.Get([]byte("bulk-000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// TestBulkLoader_RemoveStaleStaging ensures Open removes the staging directory of a loader that never finished.
+func TestBulkLoader_RemoveStaleStaging(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("existing"), []byte("v")))
+
+	loader, err := db.NewBulkLoader()
+	require.NoError(t, err)
+	require.NoError(t, loader.Add([]byte("bulk"), []byte("v")))
+	require.NoError(t, loader.closeFiles())
+	require.NoError(t, db.Close())
+	staging, err := filepath.Glob(filepath.Join(setup.DirPath, bulkStagingDirPrefix+"*"))
+	require.NoError(t, err)
+	require.Len(t, staging, 1)
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	staging, err = filepath.Glob(filepath.Join(setup.DirPath, bulkStagingDirPrefix+"*"))
+	require.NoError(t, err)
+	assert.Empty(t, staging)
+	_, err = db.Get([]byte("bulk"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
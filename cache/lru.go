@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"bitcask-gown/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// shardCount 分片的数量，不同分片之间的读写互不阻塞
+const shardCount = 16
+
+// Stats 缓存的统计信息
+type Stats struct {
+	Hits    uint64 // 命中次数
+	Misses  uint64 // 未命中次数
+	Entries int    // 当前缓存的条目数量
+	Bytes   int64  // 当前缓存的 value 总字节数
+}
+
+// ValueCache 以 LogRecordPos 为 key 的分片 LRU value 缓存。
+// 由于某个位置上的记录一旦写入就不会再改变，覆盖写入只会产生新的位置，因此缓存的条目永远不需要失效，
+// 旧位置上的条目会随着不再被访问而被淘汰。
+type ValueCache struct {
+	shards [shardCount]*shard
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// shard 一个独立加锁的 LRU 分片
+type shard struct {
+	lock     *sync.Mutex
+	capacity int64
+	size     int64
+	items    map[data.LogRecordPos]*list.Element
+	lru      *list.List // 链表头部为最近访问的条目
+}
+
+type entry struct {
+	pos   data.LogRecordPos
+	value []byte
+}
+
+// NewValueCache 创建总容量为 capacity 字节的缓存，容量平均分配到每一个分片
+func NewValueCache(capacity int64) *ValueCache {
+	c := &ValueCache{}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			lock:     new(sync.Mutex),
+			capacity: capacity / shardCount,
+			items:    make(map[data.LogRecordPos]*list.Element),
+			lru:      list.New(),
+		}
+	}
+	return c
+}
+
+// Get 获取 pos 位置上缓存的 value，返回的是副本，调用方可以随意修改
+func (c *ValueCache) Get(pos *data.LogRecordPos) ([]byte, bool) {
+	s := c.shardOf(pos)
+	s.lock.Lock()
+	elem, ok := s.items[*pos]
+	if !ok {
+		s.lock.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	value := append([]byte{}, elem.Value.(*entry).value...)
+	s.lock.Unlock()
+
+	c.hits.Add(1)
+	return value, true
+}
+
+// Put 缓存 pos 位置上的 value，保存的是副本；超过单个分片容量的 value 不会被缓存
+func (c *ValueCache) Put(pos *data.LogRecordPos, value []byte) {
+	s := c.shardOf(pos)
+	if int64(len(value)) > s.capacity {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.items[*pos]; ok {
+		return
+	}
+	s.items[*pos] = s.lru.PushFront(&entry{pos: *pos, value: append([]byte{}, value...)})
+	s.size += int64(len(value))
+
+	// 淘汰最久未被访问的条目，直到容量满足限制
+	for s.size > s.capacity {
+		oldest := s.lru.Back()
+		e := oldest.Value.(*entry)
+		s.lru.Remove(oldest)
+		delete(s.items, e.pos)
+		s.size -= int64(len(e.value))
+	}
+}
+
+// Stats 返回缓存的统计信息
+func (c *ValueCache) Stats() Stats {
+	stats := Stats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for _, s := range c.shards {
+		s.lock.Lock()
+		stats.Entries += len(s.items)
+		stats.Bytes += s.size
+		s.lock.Unlock()
+	}
+	return stats
+}
+
+// shardOf 根据位置信息选择分片
+func (c *ValueCache) shardOf(pos *data.LogRecordPos) *shard {
+	h := uint64(pos.Fid)*0x9E3779B97F4A7C15 ^ uint64(pos.Offset)
+	h ^= h >> 29
+	return c.shards[h%shardCount]
+}
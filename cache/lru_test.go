@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"bitcask-gown/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache_GetPut(t *testing.T) {
+	c := NewValueCache(1024 * shardCount)
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+
+	_, ok := c.Get(pos)
+	assert.False(t, ok)
+
+	value := []byte("bitcask-go")
+	c.Put(pos, value)
+	value[0] = 'x' // 缓存保存的是副本
+	got, ok := c.Get(pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bitcask-go"), got)
+
+	got[0] = 'y' // 返回的也是副本
+	got, _ = c.Get(pos)
+	assert.Equal(t, []byte("bitcask-go"), got)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, int64(len(value)), stats.Bytes)
+}
+
+func TestValueCache_Evict(t *testing.T) {
+	c := NewValueCache(100 * shardCount)
+	for i := 0; i < 1000; i++ {
+		c.Put(&data.LogRecordPos{Fid: uint32(i % 3), Offset: int64(i)}, make([]byte, 10))
+	}
+	stats := c.Stats()
+	assert.LessOrEqual(t, stats.Bytes, int64(100*shardCount))
+	assert.Equal(t, int64(stats.Entries*10), stats.Bytes)
+
+	// 超过分片容量的 value 不会被缓存
+	big := &data.LogRecordPos{Fid: 9, Offset: 9}
+	c.Put(big, make([]byte, 101))
+	_, ok := c.Get(big)
+	assert.False(t, ok)
+
+	// 最近访问的条目不会被淘汰
+	hot := &data.LogRecordPos{Fid: 0, Offset: 999}
+	c.Put(hot, make([]byte, 10))
+	for i := 1000; i < 2000; i++ {
+		_, ok := c.Get(hot)
+		assert.True(t, ok)
+		c.Put(&data.LogRecordPos{Fid: 0, Offset: int64(i)}, make([]byte, 10))
+	}
+}
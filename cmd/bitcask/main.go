@@ -0,0 +1,159 @@
+// bitcask 是 bitcask-gown 数据目录的命令行工具，目前支持以 JSON Lines 或者 CSV 格式导出以及导入数据。
+//
+//	bitcask export -dir DIR [-format jsonl|csv] [-prefix PREFIX] [-serial] [-out FILE]
+//	bitcask import -dir DIR [-format jsonl|csv] [-batch N] [-sync] [-in FILE]
+package main
+
+import (
+	bitcask "bitcask-gown"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: bitcask <command> [flags]
+
+commands:
+  export    write the keyspace (or a prefix of it) as JSON Lines or CSV
+  import    load JSON Lines or CSV produced by export
+
+run "bitcask <command> -h" for the flags of a command
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "bitcask:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run 执行 args 指定的子命令，数据默认从 stdin 读取、写出到 stdout
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "export":
+		return runExport(args[1:], stdout, stderr)
+	case "import":
+		return runImport(args[1:], stdin, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runExport 以只读的方式打开数据目录并导出数据
+func runExport(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", "", "data directory (required)")
+	format := fs.String("format", "jsonl", "output format: jsonl or csv")
+	prefix := fs.String("prefix", "", "only export keys with this prefix")
+	serial := fs.Bool("serial", false, "include the serial number of the record that wrote each key (0 outside write batches)")
+	out := fs.String("out", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("export: -dir is required")
+	}
+	if *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("export: unknown format %q", *format)
+	}
+
+	opt := bitcask.DefaultOptions
+	opt.DirPath = *dir
+	opt.ReadOnly = true
+	db, err := bitcask.Open(opt)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w := stdout
+	var file *os.File
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	exportOpts := bitcask.ExportOptions{Prefix: []byte(*prefix), Serial: *serial}
+	var n int
+	if *format == "csv" {
+		n, err = db.ExportCSV(w, exportOpts)
+	} else {
+		n, err = db.ExportJSONL(w, exportOpts)
+	}
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stderr, "exported %d records\n", n)
+	return nil
+}
+
+// runImport 打开数据目录并分批导入数据
+func runImport(args []string, stdin io.Reader, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", "", "data directory (required)")
+	format := fs.String("format", "jsonl", "input format: jsonl or csv")
+	batch := fs.Uint("batch", 1000, "maximum number of keys committed in one write batch")
+	sync := fs.Bool("sync", false, "sync every committed batch to disk")
+	in := fs.String("in", "", "input file, defaults to stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("import: -dir is required")
+	}
+	if *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("import: unknown format %q", *format)
+	}
+
+	r := stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	opt := bitcask.DefaultOptions
+	opt.DirPath = *dir
+	db, err := bitcask.Open(opt)
+	if err != nil {
+		return err
+	}
+
+	setup := bitcask.WriteBatchSetup{MaxBatchNum: *batch, SyncWrites: *sync}
+	var n int
+	if *format == "csv" {
+		n, err = db.ImportCSV(r, setup)
+	} else {
+		n, err = db.ImportJSONL(r, setup)
+	}
+	fmt.Fprintf(stderr, "imported %d records\n", n)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	bitcask "bitcask-gown"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRun_ExportImport ensures the export and import subcommands round-trip a prefix through a file and stdin.
+func TestRun_ExportImport(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	opt := bitcask.DefaultOptions
+	opt.DirPath = srcDir
+	db, err := bitcask.Open(opt)
+	require.NoError(t, err)
+	for _, key := range []string{"app:a", "app:b", "other"} {
+		require.NoError(t, db.Put([]byte(key), []byte("v-"+key)))
+	}
+	require.NoError(t, db.Close())
+
+	for _, format := range []string{"jsonl", "csv"} {
+		out := filepath.Join(t.TempDir(), "dump."+format)
+		var stderr bytes.Buffer
+		require.NoError(t, run([]string{"export", "-dir", srcDir, "-format", format, "-prefix", "app:", "-serial", "-out", out}, nil, nil, &stderr))
+		assert.Equal(t, "exported 2 records\n", stderr.String())
+
+		stderr.Reset()
+		require.NoError(t, run([]string{"import", "-dir", dstDir, "-format", format, "-batch", "1", "-in", out}, nil, nil, &stderr))
+		assert.Equal(t, "imported 2 records\n", stderr.String())
+	}
+
+	// 从标准输入导入，导出到标准输出
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("key,value\nb3RoZXI=,eA==\n")
+	require.NoError(t, run([]string{"import", "-dir", dstDir, "-format", "csv"}, stdin, nil, &stderr))
+	require.NoError(t, run([]string{"export", "-dir", dstDir, "-format", "csv"}, nil, &stdout, &stderr))
+	assert.Equal(t, "key,value\nYXBwOmE=,di1hcHA6YQ==\nYXBwOmI=,di1hcHA6Yg==\nb3RoZXI=,eA==\n", stdout.String())
+}
+
+// TestRun_Errors ensures bad invocations are reported instead of touching any data.
+func TestRun_Errors(t *testing.T) {
+	var stderr bytes.Buffer
+	assert.Error(t, run(nil, nil, nil, &stderr))
+	assert.Error(t, run([]string{"unknown"}, nil, nil, &stderr))
+	assert.Error(t, run([]string{"export"}, nil, nil, &stderr))
+	assert.Error(t, run([]string{"export", "-dir", t.TempDir(), "-format", "xml"}, nil, nil, &stderr))
+	assert.Error(t, run([]string{"import", "-dir", t.TempDir(), "-batch", "0"}, strings.NewReader(`{"key":"aw==","value":""}`), nil, &stderr))
+}
@@ -0,0 +1,85 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/metrics"
+	"bytes"
+	"time"
+)
+
+// 条件写入：检查 key 当前的 value 与写入都在持有 db.lock 的情况下完成，期间不会有其他写入插入，
+// 因此可以在其上实现分布式锁、计数器等需要原子读-改-写的功能。条件满足时只追加一条记录。
+
+// CompareAndSwap 当 key 存在且当前的 value 等于 old 时，将其替换为 new；返回是否完成了替换
+func (db *DB) CompareAndSwap(key, old, new []byte) (swapped bool, err error) {
+	defer db.observe(metrics.OpPut, time.Now(), &err)
+
+	return db.conditionalWrite(key, data.NewLogRecord(key, new), func(value []byte, exists bool) bool {
+		return exists && bytes.Equal(value, old)
+	})
+}
+
+// PutIfAbsent 当 key 不存在时写入 value；返回是否完成了写入
+func (db *DB) PutIfAbsent(key, value []byte) (written bool, err error) {
+	defer db.observe(metrics.OpPut, time.Now(), &err)
+
+	return db.conditionalWrite(key, data.NewLogRecord(key, value), func(_ []byte, exists bool) bool {
+		return !exists
+	})
+}
+
+// DeleteIfEquals 当 key 存在且当前的 value 等于 value 时删除它；返回是否完成了删除
+func (db *DB) DeleteIfEquals(key, value []byte) (deleted bool, err error) {
+	defer db.observe(metrics.OpDelete, time.Now(), &err)
+
+	record := &data.LogRecord{Key: key, Type: data.LogRecordToDelete}
+	return db.conditionalWrite(key, record, func(current []byte, exists bool) bool {
+		return exists && bytes.Equal(current, value)
+	})
+}
+
+// conditionalWrite 持有 db.lock 读取 key 当前的 value，cond 返回 true 时追加 record（key 尚未编码序列号）并更新索引
+func (db *DB) conditionalWrite(key []byte, record *data.LogRecord, cond func(value []byte, exists bool) bool) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if err := db.checkWritable(); err != nil {
+		return false, err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var current []byte
+	pos, exists := db.index.Get(key)
+	if exists {
+		value, err := db.getValueByPos(pos)
+		if err != nil {
+			return false, err
+		}
+		current = value
+	}
+	if !cond(current, exists) {
+		return false, nil
+	}
+
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:   addSeqToKey(key, nonTxnSeqNumber),
+		Value: record.Value,
+		Type:  record.Type,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if record.Type == data.LogRecordToDelete {
+		db.indexDelete(db.index, key, pos)
+		db.notifyWatchers(EventDelete, key, nil, nonTxnSeqNumber, pos)
+		return true, nil
+	}
+	if err := db.indexPut(db.index, key, pos); err != nil {
+		return false, err
+	}
+	db.notifyWatchers(EventPut, key, record.Value, nonTxnSeqNumber, pos)
+	return true, nil
+}
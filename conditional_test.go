@@ -0,0 +1,100 @@
+package bitcask_gown
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_ConditionalWrites ensures CompareAndSwap, PutIfAbsent and DeleteIfEquals only write when their condition holds.
+func TestDB_ConditionalWrites(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+	key := []byte("lock")
+
+	ok, err := db.CompareAndSwap(key, nil, []byte("a"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.PutIfAbsent(key, []byte("owner-1"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(key, []byte("owner-2"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.CompareAndSwap(key, []byte("owner-2"), []byte("owner-3"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(key, []byte("owner-1"), []byte("owner-3"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = db.DeleteIfEquals(key, []byte("owner-1"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	got, err := db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("owner-3"), got)
+
+	require.NoError(t, db.Put([]byte("other"), []byte("v")))
+	ok, err = db.DeleteIfEquals([]byte("other"), []byte("v"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = db.PutIfAbsent(nil, []byte("v"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 条件写入的结果在重启之后仍然有效
+	require.NoError(t, db.Close())
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	got, err = db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("owner-3"), got)
+	_, err = db.Get([]byte("other"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// TestDB_CompareAndSwapCounter ensures concurrent CAS loops never lose an increment.
+func TestDB_CompareAndSwapCounter(t *testing.T) {
+	setup := DefaultOptions
+	setup.SyncWrites = true
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	key := []byte("counter")
+	require.NoError(t, db.Put(key, []byte("0")))
+
+	const workers, increments = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					cur, err := db.Get(key)
+					require.NoError(t, err)
+					n, _ := strconv.Atoi(string(cur))
+					ok, err := db.CompareAndSwap(key, cur, []byte(strconv.Itoa(n+1)))
+					require.NoError(t, err)
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*increments), string(got))
+}
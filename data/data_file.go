@@ -6,68 +6,237 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
-const DataFileNameSuffix = ".data"
+const (
+	DataFileNameSuffix = ".data"
+	// BlobFileNameSuffix 键值分离模式下，存放较大 value 的 blob 文件后缀
+	BlobFileNameSuffix = ".blob"
+	// StreamFileNameSuffix 流式写入过程中的数据文件后缀，写入完成之后重命名为数据文件
+	StreamFileNameSuffix = ".stream"
+)
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC          = errors.New("invalid crc value, log record maybe corrupted")
+	ErrIncompleteLogRecord = errors.New("incomplete log record, it may be left by an interrupted write")
 )
 
 // DataFile 负责文件部分内容，
 type DataFile struct {
 	FileID    uint32        // 文件 ID 号
-	WriteOff  int64         // 告知对于当前文件，已经写入到了哪里
+	WriteOff  int64         // 告知对于当前文件，已经写入到了哪里（包含尚在写缓冲区之中的数据）
 	IOManager fio.IOManager // 命名基于它是用来读写字节的
+
+	writeBuf     []byte        // 用户态写缓冲区，尚未写入 IOManager 的数据
+	writeBufSize int           // 写缓冲区的容量，为 0 时不使用写缓冲区
+	bufLock      *sync.RWMutex // 写缓冲区可能在持有 db 锁之外被读取（例如流式读取），需要单独的锁
+}
+
+// FileOptions 打开数据文件时的可选项
+type FileOptions struct {
+	IOType          fio.FileIOType // 文件 IO 的类型
+	PreallocSize    int64          // 大于 0 时，使用 fallocate 将文件预分配到该大小
+	FileSystem      fio.FileSystem // 文件所在的文件系统，为 nil 时使用 fio.DefaultFileSystem
+	WriteBufferSize int            // 大于 0 时，写入先进入用户态缓冲区，缓冲区写满、Sync 或者 Close 时才真正写入文件
 }
 
 // OpenDataFile 打开或创建新的数据文件
 func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
-	// 1. 拼接文件名，例如：/tmp/bitcask/000000001.data
-	fileName := filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
-	return newDataFile(fileName, fileId)
+	return OpenDataFileWithOptions(dirPath, fileId, FileOptions{})
+}
+
+// OpenDataFileWithOptions 按照 opt 打开或创建新的数据文件
+func OpenDataFileWithOptions(dirPath string, fileId uint32, opt FileOptions) (*DataFile, error) {
+	return newDataFile(dirPath, fileId, DataFileNameSuffix, opt)
+}
+
+// OpenBlobFile 打开或创建新的 blob 文件，其记录格式与数据文件相同
+func OpenBlobFile(dirPath string, fileId uint32, opt FileOptions) (*DataFile, error) {
+	return newDataFile(dirPath, fileId, BlobFileNameSuffix, opt)
+}
+
+// OpenStreamFile 打开或创建流式写入使用的数据文件，其中只有一条流式写入的记录
+func OpenStreamFile(dirPath string, fileId uint32, opt FileOptions) (*DataFile, error) {
+	return newDataFile(dirPath, fileId, StreamFileNameSuffix, opt)
+}
+
+// GetDataFileName 根据文件 id 以及后缀拼接出操作系统文件系统之中完整的文件路径
+func GetDataFileName(dirPath string, fileId uint32, suffix string) string {
+	return filepath.Join(dirPath, FileName(fileId, suffix))
+}
+
+// FileName 根据文件 id 以及后缀得到不包含目录部分的文件名，例如：000000001.data
+func FileName(fileId uint32, suffix string) string {
+	return fmt.Sprintf("%09d", fileId) + suffix
 }
 
-func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
-	ioManager, err := fio.NewFileIOManager(fileName)
+func newDataFile(dirPath string, fileId uint32, suffix string, opt FileOptions) (*DataFile, error) {
+	fileSystem := opt.FileSystem
+	if fileSystem == nil {
+		fileSystem = fio.DefaultFileSystem
+	}
+
+	// 拼接文件名，例如：/tmp/bitcask/000000001.data
+	fileName := fileSystem.Join(dirPath, FileName(fileId, suffix))
+	ioManager, err := fileSystem.OpenFile(fileName, opt.IOType, opt.PreallocSize)
 	if err != nil {
 		return nil, err
 	}
 
-	// 根据当前文件大小来获取 WriteOff 偏移量
+	// 根据当前文件大小来获取 WriteOff 偏移量；对于预分配的文件，真实的末尾需要 loadIndex 扫描之后通过 SetWriteOff 设置
 	size, err := ioManager.Size()
 	if err != nil {
 		return nil, err
 	}
 
 	return &DataFile{
-		FileID:    fileId,
-		WriteOff:  size,
-		IOManager: ioManager,
+		FileID:       fileId,
+		WriteOff:     size,
+		IOManager:    ioManager,
+		writeBufSize: opt.WriteBufferSize,
+		bufLock:      new(sync.RWMutex),
 	}, nil
 }
 
+// Sync 将写缓冲区之中的数据写入文件，随后持久化
 func (fio *DataFile) Sync() error {
+	fio.bufLock.Lock()
+	err := fio.flush()
+	fio.bufLock.Unlock()
+	if err != nil {
+		return err
+	}
 	return fio.IOManager.Sync()
 }
 
 func (fio *DataFile) Write(buf []byte) error {
-	n, err := fio.IOManager.Write(buf)
+	if fio.writeBufSize <= 0 {
+		n, err := fio.IOManager.Write(buf)
+		// 递增其 WriteOff 的字段；即使写入失败，已经写入的部分也要计算在内，与文件保持一致，便于调用方回滚
+		fio.WriteOff += int64(n)
+		return err
+	}
+
+	fio.bufLock.Lock()
+	defer fio.bufLock.Unlock()
+
+	fio.writeBuf = append(fio.writeBuf, buf...)
+	fio.WriteOff += int64(len(buf))
+	if len(fio.writeBuf) >= fio.writeBufSize {
+		return fio.flush()
+	}
+	return nil
+}
+
+// flush 将写缓冲区之中的数据写入 IOManager，写入失败的部分仍然保留在缓冲区之中，调用方需要持有 bufLock
+func (fio *DataFile) flush() error {
+	if len(fio.writeBuf) == 0 {
+		return nil
+	}
+	n, err := fio.IOManager.Write(fio.writeBuf)
+	fio.writeBuf = fio.writeBuf[n:]
 	if err != nil {
 		return err
 	}
-	// 递增其 WriteOff 的字段
-	fio.WriteOff += int64(n)
+	fio.writeBuf = fio.writeBuf[:0]
+	return nil
+}
+
+// SetWriteOff 设置数据的末尾位置，用于重新打开预分配的文件之后，告知其真实的写入位置
+func (fio *DataFile) SetWriteOff(offset int64) {
+	fio.WriteOff = offset
+	if setter, ok := fio.IOManager.(interface{ SetWriteOffset(int64) }); ok {
+		setter.SetWriteOffset(offset)
+	}
+}
+
+// Truncate 将数据截断到 offset，丢弃 offset 之后的数据（包括写缓冲区之中的部分），用于回滚失败的写入以及丢弃损坏的尾部
+func (fio *DataFile) Truncate(offset int64) error {
+	fio.bufLock.Lock()
+	defer fio.bufLock.Unlock()
+
+	flushedOff := fio.WriteOff - int64(len(fio.writeBuf))
+	if offset >= flushedOff {
+		fio.writeBuf = fio.writeBuf[:offset-flushedOff]
+		fio.WriteOff = offset
+		return nil
+	}
+
+	fio.writeBuf = fio.writeBuf[:0]
+	fio.WriteOff = flushedOff
+	if err := fio.IOManager.Truncate(offset); err != nil {
+		return err
+	}
+	fio.WriteOff = offset
 	return nil
 }
 
 func (fio *DataFile) Close() error {
+	fio.bufLock.Lock()
+	err := fio.flush()
+	fio.bufLock.Unlock()
+	if err != nil {
+		return err
+	}
 	return fio.IOManager.Close()
 }
 
 // ReadLogRecord 从 fio 这个 DataFile 之中读取 LogRecord 以及 Size 信息
+// 对于流式写入的记录（LogRecordStream），为了避免把巨大的 value 读入内存，这里只读取并校验 header + key，Value 为 nil，
+// 需要 value 时通过 NewValueReader 读取。
 func (fio *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
-	fileSize, err := fio.IOManager.Size()
+	header, headerSize, err := fio.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 在读取到 header 之后，我们转向获取对应的 keySize，valueSize
+	keySize, valueSize := int64(header.KeySize), int64(header.ValueSize)
+	var recSize = headerSize + keySize + valueSize
+
+	logRecord := &LogRecord{
+		Type:   header.Type,
+		Bucket: header.Bucket,
+	}
+
+	readSize := keySize + valueSize
+	if header.Type == LogRecordStream {
+		readSize = keySize
+		recSize += streamTrailerSize
+	}
+
+	// 记录超出了数据的末尾，说明这是一次被中断的写入；提前判断也避免了根据损坏的长度分配巨大的内存
+	fileSize, err := fio.size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+recSize > fileSize {
+		return nil, 0, ErrIncompleteLogRecord
+	}
+
+	buf, err := fio.readNBytes(headerSize+readSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kvBuf := buf[headerSize:]
+	logRecord.Key = kvBuf[:keySize]
+	if header.Type != LogRecordStream {
+		logRecord.Value = kvBuf[keySize:]
+	}
+
+	// 在计算其中 CRC 校验值的时候，我们不将其中 crc 部分考虑在内
+	crc := getLogRecordCRC(logRecord, buf[4:headerSize])
+	if crc != header.CRC {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, recSize, nil
+}
+
+// readLogRecordHeader 读取 offset 处的 header，读到文件末尾时返回 io.EOF
+func (fio *DataFile) readLogRecordHeader(offset int64) (*logRecordHeader, int64, error) {
+	fileSize, err := fio.size()
 	if err != nil {
 		return nil, 0, err
 	}
@@ -77,6 +246,9 @@ func (fio *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if heaSize+offset > fileSize {
 		heaSize = fileSize - offset
 	}
+	if heaSize <= 0 {
+		return nil, 0, io.EOF
+	}
 
 	// buf 长度为 heaSize 的长度
 	buf, err := fio.readNBytes(heaSize, offset)
@@ -86,44 +258,109 @@ func (fio *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	header, headerSize := decodeLogRecordHeader(buf)
 	if header == nil {
+		// 剩余的字节无法构成 header：全部为 0 说明已经到达末尾，否则是中断的写入留下的不完整记录
+		for _, b := range buf {
+			if b != 0 {
+				return nil, 0, ErrIncompleteLogRecord
+			}
+		}
 		return nil, 0, io.EOF
 	}
 
 	if header.CRC == 0 && header.KeySize == 0 && header.ValueSize == 0 {
 		return nil, 0, io.EOF
 	}
+	return header, headerSize, nil
+}
 
-	// 在读取到 header 之后，我们转向获取对应的 keySize，valueSize
-	keySize, valueSize := int64(header.KeySize), int64(header.ValueSize)
-	var recSize = headerSize + keySize + valueSize
-
-	logRecord := &LogRecord{
-		Type: header.Type,
-	}
-
-	kvBuf, err := fio.readNBytes(keySize+valueSize, offset+headerSize)
+// IsTornTail 判断 offset 处无法读取的记录是否是被中断的写入留下的尾部：记录一直延伸到数据的末尾，或者它之后只有预分配填充的 0。
+// 之后还有其他数据时，说明损坏发生在已经写完的记录之中，不能当作尾部丢弃
+func (fio *DataFile) IsTornTail(offset int64) (bool, error) {
+	fileSize, err := fio.size()
 	if err != nil {
-		return nil, 0, err
+		return false, err
 	}
 
-	key, value := kvBuf[:keySize], kvBuf[keySize:]
-	logRecord.Key = key
-	logRecord.Value = value
+	// header 无法解析时，无从得知记录的长度，只能从 header 的最大长度之后开始检查
+	end := offset + maxLogRecordHeaderSize
+	header, headerSize, err := fio.readLogRecordHeader(offset)
+	if err == nil {
+		end = offset + headerSize + int64(header.KeySize) + int64(header.ValueSize)
+		if header.Type == LogRecordStream {
+			end += streamTrailerSize
+		}
+	} else if err != ErrIncompleteLogRecord && err != io.EOF {
+		return false, err
+	}
 
-	// 在计算其中 CRC 校验值的时候，我们不将其中 crc 部分考虑在内
-	crc := getLogRecordCRC(logRecord, buf[4:headerSize])
-	if crc != header.CRC {
-		return nil, 0, ErrInvalidCRC
+	buf := make([]byte, 64*1024)
+	for end < fileSize {
+		n := min(int64(len(buf)), fileSize-end)
+		if _, err := fio.readAt(buf[:n], end); err != nil {
+			return false, err
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		end += n
 	}
-	return logRecord, recSize, nil
+	return true, nil
 }
 
 // 从 offest 的位置上开始，读取 df 上的前 N 个字节，将其存储在 buf 变量上
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
-	_, err = df.IOManager.Read(b, offset)
+	_, err = df.readAt(b, offset)
 	if err != nil {
 		return nil, err
 	}
 	return
 }
+
+// size 数据的大小，包括尚在写缓冲区之中的部分
+func (df *DataFile) size() (int64, error) {
+	df.bufLock.RLock()
+	defer df.bufLock.RUnlock()
+
+	size, err := df.IOManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	return size + int64(len(df.writeBuf)), nil
+}
+
+// ReadAt 从 offset 处读取原始的字节到 b 中，包括尚在写缓冲区之中的数据，用于复制数据文件
+func (df *DataFile) ReadAt(b []byte, offset int64) (int, error) {
+	return df.readAt(b, offset)
+}
+
+// readAt 从 offset 处读取数据到 b 中，已经写入文件的部分通过 IOManager 读取，尚在写缓冲区之中的部分直接从缓冲区复制
+func (df *DataFile) readAt(b []byte, offset int64) (int, error) {
+	df.bufLock.RLock()
+	defer df.bufLock.RUnlock()
+
+	if len(df.writeBuf) == 0 {
+		return df.IOManager.Read(b, offset)
+	}
+	flushedOff := df.WriteOff - int64(len(df.writeBuf))
+	if offset+int64(len(b)) <= flushedOff {
+		return df.IOManager.Read(b, offset)
+	}
+
+	var n int
+	if offset < flushedOff {
+		m, err := df.IOManager.Read(b[:flushedOff-offset], offset)
+		n += m
+		if err != nil && !(err == io.EOF && n == int(flushedOff-offset)) {
+			return n, err
+		}
+	}
+
+	n += copy(b[n:], df.writeBuf[offset+int64(n)-flushedOff:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
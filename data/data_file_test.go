@@ -1,8 +1,11 @@
 package data
 
 import (
+	"bitcask-gown/fio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -130,3 +133,113 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_Prealloc(t *testing.T) {
+	tmpDir := t.TempDir()
+	opt := FileOptions{PreallocSize: 4096}
+	dataFile, err := OpenDataFileWithOptions(tmpDir, 1, opt)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), dataFile.WriteOff)
+
+	stat, err := os.Stat(GetDataFileName(tmpDir, 1, DataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4096), stat.Size())
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")}
+	enc, size := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(enc))
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开之后，物理大小为预分配的大小，扫描到全 0 的 header 时视为末尾
+	dataFile, err = OpenDataFileWithOptions(tmpDir, 1, opt)
+	assert.Nil(t, err)
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	_, _, err = dataFile.ReadLogRecord(readSize)
+	assert.Equal(t, io.EOF, err)
+
+	// 设置真实的写入位置之后，新的数据紧接着已有数据写入
+	dataFile.SetWriteOff(size)
+	assert.Nil(t, dataFile.Write(enc))
+	readRec, _, err = dataFile.ReadLogRecord(size)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+}
+
+func TestDataFile_WriteBuffer(t *testing.T) {
+	tmpDir := t.TempDir()
+	dataFile, err := OpenDataFileWithOptions(tmpDir, 1, FileOptions{WriteBufferSize: 64})
+	assert.Nil(t, err)
+
+	rec1 := &LogRecord{Key: []byte("k1"), Value: []byte("v1")}
+	enc1, size1 := EncodeLogRecord(rec1)
+	assert.Nil(t, dataFile.Write(enc1))
+
+	// 数据尚在缓冲区之中，也能够被读取
+	flushed, _ := dataFile.IOManager.Size()
+	assert.Equal(t, int64(0), flushed)
+	readRec, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec)
+
+	// 超过缓冲区大小时刷入文件，跨越文件与缓冲区的读取同样正确
+	rec2 := &LogRecord{Key: []byte("k2"), Value: []byte(strings.Repeat("v", 80))}
+	enc2, _ := EncodeLogRecord(rec2)
+	assert.Nil(t, dataFile.Write(enc2))
+	assert.Nil(t, dataFile.Write(enc1))
+	readRec, _, err = dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec)
+
+	assert.Nil(t, dataFile.Sync())
+	flushed, _ = dataFile.IOManager.Size()
+	assert.Equal(t, dataFile.WriteOff, flushed)
+}
+
+func TestDataFile_DirectIO(t *testing.T) {
+	tmpDir := t.TempDir()
+	opt := FileOptions{IOType: fio.DirectFIO}
+	dataFile, err := OpenDataFileWithOptions(tmpDir, 1, opt)
+	if err != nil {
+		t.Skipf("direct io is not available: %v", err)
+	}
+
+	// 写入若干条不按块对齐的记录，最后一个块始终是不完整的
+	var recs []*LogRecord
+	var offsets []int64
+	for i := 0; i < 300; i++ {
+		rec := &LogRecord{Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte(strings.Repeat("v", i))}
+		enc, _ := EncodeLogRecord(rec)
+		offsets = append(offsets, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Write(enc))
+		recs = append(recs, rec)
+	}
+	writeOff := dataFile.WriteOff
+	assert.Nil(t, dataFile.Sync())
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开之后，物理大小按块对齐，末尾的补齐部分为 0
+	stat, err := os.Stat(GetDataFileName(tmpDir, 1, DataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size()%4096)
+
+	dataFile, err = OpenDataFileWithOptions(tmpDir, 1, opt)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(writeOff)
+	assert.Equal(t, io.EOF, err)
+
+	// 设置真实的写入位置之后继续写入，不完整块之中已有的数据不会被覆盖
+	dataFile.SetWriteOff(writeOff)
+	last := &LogRecord{Key: []byte("last"), Value: []byte("value")}
+	enc, _ := EncodeLogRecord(last)
+	assert.Nil(t, dataFile.Write(enc))
+	recs, offsets = append(recs, last), append(offsets, writeOff)
+
+	for i, rec := range recs {
+		readRec, _, err := dataFile.ReadLogRecord(offsets[i])
+		assert.Nil(t, err)
+		assert.Equal(t, rec, readRec)
+	}
+	assert.Nil(t, dataFile.Close())
+}
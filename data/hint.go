@@ -0,0 +1,81 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HintFileNameSuffix 提示文件的后缀。提示文件与同 id 的数据文件对应，按照写入的顺序保存数据文件开头一段记录的 key 以及位置，
+// 加载索引时读取提示文件即可得到这部分记录的索引，无需读取其中的 value
+const HintFileNameSuffix = ".hint"
+
+// ErrInvalidHint 提示的内容无法解析，例如由旧版本写入的提示文件
+var ErrInvalidHint = errors.New("invalid hint record")
+
+// Hint 提示文件之中的一条提示
+type Hint struct {
+	Key []byte        // 记录的 key
+	Pos *LogRecordPos // 记录在数据文件之中的位置，Fid 即提示文件的 id
+	CRC uint32        // 记录的 CRC，用于确认数据文件之中的记录与提示一致
+}
+
+// OpenHintFile 打开或创建新的提示文件，其中的每一条提示也以 LogRecord 的格式保存，带有 CRC 校验
+func OpenHintFile(dirPath string, fileId uint32, opt FileOptions) (*DataFile, error) {
+	return newDataFile(dirPath, fileId, HintFileNameSuffix, opt)
+}
+
+// WriteHintRecord 向提示文件追加一条提示：key、它在对应数据文件之中的位置以及记录的 CRC
+func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos, crc uint32) error {
+	buf := make([]byte, binary.MaxVarintLen64*2+4)
+	index := binary.PutVarint(buf, pos.Offset)
+	index += binary.PutVarint(buf[index:], pos.Size)
+	binary.LittleEndian.PutUint32(buf[index:], crc)
+
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: key, Value: buf[:index+4], Type: LogRecordNormal})
+	return df.Write(encRecord)
+}
+
+// ReadHintRecord 读取提示文件 offset 处的提示，返回提示以及它的长度
+func (df *DataFile) ReadHintRecord(offset int64) (*Hint, int64, error) {
+	record, size, err := df.ReadLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	recOffset, n := binary.Varint(record.Value)
+	if n <= 0 {
+		return nil, 0, ErrInvalidHint
+	}
+	recSize, m := binary.Varint(record.Value[n:])
+	if m <= 0 || len(record.Value) != n+m+4 {
+		return nil, 0, ErrInvalidHint
+	}
+	return &Hint{
+		Key: record.Key,
+		Pos: &LogRecordPos{Fid: df.FileID, Offset: recOffset, Size: recSize},
+		CRC: binary.LittleEndian.Uint32(record.Value[n+m:]),
+	}, size, nil
+}
+
+// MatchesHint 检查数据文件之中 hint 指向的记录是否与提示一致：记录的 key 为 recordKey，CRC 以及长度与提示相同，并且是默认 bucket 之中的普通记录。
+// 只读取记录的 header 以及 key，不读取 value
+func (df *DataFile) MatchesHint(hint *Hint, recordKey []byte) (bool, error) {
+	header, headerSize, err := df.readLogRecordHeader(hint.Pos.Offset)
+	if err == io.EOF || err == ErrIncompleteLogRecord {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if header.CRC != hint.CRC || header.Type != LogRecordNormal || header.Bucket != 0 ||
+		int(header.KeySize) != len(recordKey) || headerSize+int64(header.KeySize)+int64(header.ValueSize) != hint.Pos.Size {
+		return false, nil
+	}
+
+	key, err := df.readNBytes(int64(header.KeySize), hint.Pos.Offset+headerSize)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(key, recordKey), nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHintFile 提示按照写入的顺序读出，位置的 Fid 为提示文件的 id
+func TestHintFile(t *testing.T) {
+	hintFile, err := OpenHintFile(t.TempDir(), 7, FileOptions{})
+	require.NoError(t, err)
+	defer hintFile.Close()
+
+	require.NoError(t, hintFile.WriteHintRecord([]byte("a"), &LogRecordPos{Offset: 0, Size: 20}, 1))
+	require.NoError(t, hintFile.WriteHintRecord([]byte("b"), &LogRecordPos{Offset: 20, Size: 4096}, 2))
+
+	hint, size, err := hintFile.ReadHintRecord(0)
+	require.NoError(t, err)
+	assert.Equal(t, &Hint{Key: []byte("a"), Pos: &LogRecordPos{Fid: 7, Offset: 0, Size: 20}, CRC: 1}, hint)
+
+	hint, size2, err := hintFile.ReadHintRecord(size)
+	require.NoError(t, err)
+	assert.Equal(t, &Hint{Key: []byte("b"), Pos: &LogRecordPos{Fid: 7, Offset: 20, Size: 4096}, CRC: 2}, hint)
+
+	_, _, err = hintFile.ReadHintRecord(size + size2)
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestDataFile_MatchesHint 只有 key、CRC 以及长度都与记录一致的提示才能通过校验
+func TestDataFile_MatchesHint(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer dataFile.Close()
+
+	encA, sizeA := EncodeLogRecord(&LogRecord{Key: []byte("a"), Value: []byte("value-a"), Type: LogRecordNormal})
+	encB, sizeB := EncodeLogRecord(&LogRecord{Key: []byte("b"), Value: []byte("value-bb"), Type: LogRecordNormal})
+	require.NoError(t, dataFile.Write(encA))
+	require.NoError(t, dataFile.Write(encB))
+	crcA, crcB := binary.LittleEndian.Uint32(encA), binary.LittleEndian.Uint32(encB)
+
+	hintA := &Hint{Key: []byte("a"), Pos: &LogRecordPos{Offset: 0, Size: sizeA}, CRC: crcA}
+	hintB := &Hint{Key: []byte("b"), Pos: &LogRecordPos{Offset: sizeA, Size: sizeB}, CRC: crcB}
+	for _, c := range []struct {
+		hint *Hint
+		key  string
+		ok   bool
+	}{
+		{hintA, "a", true},
+		{hintB, "b", true},
+		{hintA, "b", false},
+		{&Hint{Pos: hintA.Pos, CRC: crcB}, "a", false},
+		{&Hint{Pos: &LogRecordPos{Offset: sizeA, Size: sizeA}, CRC: crcB}, "b", false},
+		{&Hint{Pos: &LogRecordPos{Offset: 3, Size: sizeB}, CRC: crcB}, "b", false},
+		{&Hint{Pos: &LogRecordPos{Offset: sizeA + sizeB, Size: sizeB}, CRC: crcB}, "b", false},
+	} {
+		ok, err := dataFile.MatchesHint(c.hint, []byte(c.key))
+		require.NoError(t, err)
+		assert.Equal(t, c.ok, ok, "hint %+v key %s", c.hint, c.key)
+	}
+}
@@ -11,16 +11,52 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordToDelete
 	LogRecordTxnFinished
+	// LogRecordStream 通过流式写入的普通数据，value 的 CRC 位于记录尾部
+	LogRecordStream
+	// LogRecordBlobPtr 键值分离模式下的普通数据，value 保存的是其在 blob 文件之中的位置
+	LogRecordBlobPtr
+	// LogRecordBucketCreate 创建 bucket，key 为 bucket 的名称，Bucket 为分配给它的 id
+	LogRecordBucketCreate
+	// LogRecordBucketDrop 删除 bucket 以及其中所有的数据，此前属于该 bucket 的记录全部失效
+	LogRecordBucketDrop
+	// LogRecordMerge 合并操作数，value 保存操作数以及同一个 key 上一条记录的位置，读取时由 MergeOperator 合并
+	LogRecordMerge
+	// LogRecordRangeDelete 范围墓碑，删除 [key, value) 范围内此前写入的所有 key，value 为空时没有上界
+	LogRecordRangeDelete
 )
 
-// 定义 LogRecord 的头部信息最大值是15. crc(4) + Type(1) + KeySize(5) + ValueSize(5) = 15
-const maxLogRecordHeaderSize = 4 + 1 + binary.MaxVarintLen32*2
+// ChecksumType 记录的 CRC 所使用的校验算法
+type ChecksumType = byte
+
+const (
+	// ChecksumIEEE 使用 IEEE 多项式的 CRC32，旧版本写入的记录全部使用该算法
+	ChecksumIEEE ChecksumType = iota
+	// ChecksumCastagnoli 使用 Castagnoli 多项式的 CRC32C，在支持 SSE4.2 / ARMv8 CRC 指令的 CPU 上有硬件加速
+	ChecksumCastagnoli
+)
+
+// DefaultChecksum 新写入的记录默认使用的校验算法
+const DefaultChecksum = ChecksumCastagnoli
+
+// checksumCastagnoliFlag 校验算法记录在 header 之中 Type 字节的最高位：置位表示 CRC32C，否则为 IEEE。
+// 旧版本写入的记录该位均为 0，因此读取时依然按照 IEEE 校验。
+const checksumCastagnoliFlag byte = 0x80
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// bucketFlag 记录属于默认 bucket 以外的 bucket 时，Type 字节的次高位置位，header 在 ValueSize 之后还有一个 uvarint 的 bucket id。
+// 默认 bucket 的记录不设置该位，与旧版本写入的格式完全一致。
+const bucketFlag byte = 0x40
+
+// 定义 LogRecord 的头部信息最大值是20. crc(4) + Type(1) + KeySize(5) + ValueSize(5) + Bucket(5) = 20
+const maxLogRecordHeaderSize = 4 + 1 + binary.MaxVarintLen32*3
 
 // LogRecord 我们是以类似日志写入的方式来追加 LogRecord，同时增加 Type 来表示这是一个新增数据或者待删除数据。
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Bucket uint32 // 记录所属的 bucket，0 为默认的 bucket
 }
 
 // NewLogRecord 创建一条新的 LogRecord，返回其位置信息（不是实例）。
@@ -36,14 +72,69 @@ func NewLogRecord(key, value []byte) *LogRecord {
 type logRecordHeader struct {
 	CRC       uint32        // 校验值
 	Type      LogRecordType // 类型
+	Checksum  ChecksumType  // CRC 所使用的校验算法
 	KeySize   uint32        // 变长类型，Key 的长度大小
 	ValueSize uint32        // Value 的长度
+	Bucket    uint32        // 所属的 bucket，只有设置了 bucketFlag 时才会编码
 }
 
 // LogRecordPos 记录存储的文件名称 Fid 以及对应的位置 Offset
 type LogRecordPos struct {
 	Fid    uint32
 	Offset int64
+	Size   int64 // 记录在数据文件之中占用的字节数，记录被覆盖或者删除后，这部分空间可以被回收
+	// 合并操作数链的链头之前的记录占用的字节数，链被覆盖或者删除时与 Size 一起回收；其他记录为 0
+	ChainSize int64
+}
+
+// EncodeBlobPos 将 blob 记录的位置以及长度编码为主日志记录的 value
+func EncodeBlobPos(pos *LogRecordPos, size int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
+	index := binary.PutUvarint(buf, uint64(pos.Fid))
+	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], size)
+	return buf[:index]
+}
+
+// DecodeBlobPos 解码主日志记录之中保存的 blob 位置以及长度
+func DecodeBlobPos(buf []byte) (*LogRecordPos, int64) {
+	fid, index := binary.Uvarint(buf)
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	size, _ := binary.Varint(buf[index:])
+	return &LogRecordPos{Fid: uint32(fid), Offset: offset}, size
+}
+
+// EncodeMergeOperand 编码合并操作数记录的 value：链的长度 depth、上一条记录的位置 prev（key 此前不存在时为 nil）以及操作数
+func EncodeMergeOperand(prev *LogRecordPos, depth int, operand []byte) []byte {
+	const maxSize = binary.MaxVarintLen64 + 1 + binary.MaxVarintLen32 + binary.MaxVarintLen64
+	buf := make([]byte, maxSize, maxSize+len(operand))
+	index := binary.PutUvarint(buf, uint64(depth))
+	if prev == nil {
+		buf[index] = 0
+		index++
+	} else {
+		buf[index] = 1
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(prev.Fid))
+		index += binary.PutVarint(buf[index:], prev.Offset)
+	}
+	return append(buf[:index], operand...)
+}
+
+// DecodeMergeOperand 解码合并操作数记录的 value，返回上一条记录的位置、链的长度以及操作数
+func DecodeMergeOperand(buf []byte) (*LogRecordPos, int, []byte) {
+	depth, index := binary.Uvarint(buf)
+	hasPrev := buf[index] == 1
+	index++
+	if !hasPrev {
+		return nil, int(depth), buf[index:]
+	}
+	fid, n := binary.Uvarint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	return &LogRecordPos{Fid: uint32(fid), Offset: offset}, int(depth), buf[index:]
 }
 
 // TxnLogRecord 主要是用于在事务处理之中的数据信息
@@ -52,17 +143,15 @@ type TxnLogRecord struct {
 	Pos    *LogRecordPos
 }
 
-// EncodeLogRecord 将 LogRecord 进行编码操作，转换为 []byte 字节数组
+// EncodeLogRecord 将 LogRecord 进行编码操作，转换为 []byte 字节数组，CRC 使用 DefaultChecksum 计算
 func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
-	tempBuf := make([]byte, maxLogRecordHeaderSize)
-	tempBuf[4] = record.Type
-	keySize, valueSize := len(record.Key), len(record.Value)
-	// 应该从索引值 5 之后写入
-	index := binary.PutVarint(tempBuf[5:], int64(keySize))
-	// 从索引值 5 + index 开始写入
-	index += binary.PutVarint(tempBuf[5+index:], int64(valueSize))
+	return EncodeLogRecordWithChecksum(record, DefaultChecksum)
+}
 
-	headerSize := 5 + index // 5 是代表其中 CRC + Type 得到的类型
+// EncodeLogRecordWithChecksum 将 LogRecord 编码为 []byte 字节数组，CRC 使用 checksum 指定的算法计算
+func EncodeLogRecordWithChecksum(record *LogRecord, checksum ChecksumType) ([]byte, int64) {
+	keySize, valueSize := len(record.Key), len(record.Value)
+	tempBuf, headerSize := encodeLogRecordHeader(record.Type, checksum, record.Bucket, keySize, int64(valueSize))
 	// 将 crc 也考虑在内；其中之前的实现，使用的 CheckSumIEEE 方法，包含了 headerBody 以及 record
 	crc := getLogRecordCRC(record, tempBuf[4:headerSize])
 	binary.LittleEndian.PutUint32(tempBuf, crc)
@@ -78,6 +167,25 @@ func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
 	return buf, int64(recSize)
 }
 
+// encodeLogRecordHeader 编码 header 之中除 CRC 以外的部分，CRC 所在的前 4 个字节由调用方填充
+func encodeLogRecordHeader(typ LogRecordType, checksum ChecksumType, bucket uint32, keySize int, valueSize int64) ([]byte, int) {
+	tempBuf := make([]byte, maxLogRecordHeaderSize)
+	tempBuf[4] = typ
+	if checksum == ChecksumCastagnoli {
+		tempBuf[4] |= checksumCastagnoliFlag
+	}
+	// 应该从索引值 5 之后写入
+	index := binary.PutVarint(tempBuf[5:], int64(keySize))
+	// 从索引值 5 + index 开始写入
+	index += binary.PutVarint(tempBuf[5+index:], valueSize)
+	if bucket != 0 {
+		tempBuf[4] |= bucketFlag
+		index += binary.PutUvarint(tempBuf[5+index:], uint64(bucket))
+	}
+
+	return tempBuf, 5 + index // 5 是代表其中 CRC + Type 得到的类型
+}
+
 // 对字节数组之中的 Header 信息进行解码，将其由 []byte 转化为 logRecordHeader
 func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	if len(buf) < 5 {
@@ -86,28 +194,56 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	crc, typ := binary.LittleEndian.Uint32(buf[0:4]), buf[4]
 	header := &logRecordHeader{
-		CRC:  crc,
-		Type: typ,
+		CRC:      crc,
+		Type:     typ &^ (checksumCastagnoliFlag | bucketFlag),
+		Checksum: ChecksumIEEE,
+	}
+	if typ&checksumCastagnoliFlag != 0 {
+		header.Checksum = ChecksumCastagnoli
 	}
 
 	var headerSize uint32 = 5
 	// 取出对应的 Key 以及其对应长度 kl
 	keySize, kl := binary.Varint(buf[5:])
+	if kl <= 0 || keySize < 0 {
+		return nil, 0
+	}
 	header.KeySize = uint32(keySize)
 	headerSize += uint32(kl)
 
 	// 取出对应 Value 以及对应长度 vl
 	valueSize, vl := binary.Varint(buf[headerSize:])
+	if vl <= 0 || valueSize < 0 {
+		return nil, 0
+	}
 	header.ValueSize = uint32(valueSize)
 	headerSize += uint32(vl)
 
+	if typ&bucketFlag != 0 {
+		bucket, bl := binary.Uvarint(buf[headerSize:])
+		if bl <= 0 {
+			return nil, 0
+		}
+		header.Bucket = uint32(bucket)
+		headerSize += uint32(bl)
+	}
+
 	return header, int64(headerSize)
 }
 
-// 计算出 CRC 校验值，先算 HeaderBody 部分，随后累加计算 Key, Value 的内容
+// 计算出 CRC 校验值，先算 HeaderBody 部分，随后累加计算 Key, Value 的内容；所用的算法由 headerBody 之中的 Type 字节决定
 func getLogRecordCRC(rec *LogRecord, headerBody []byte) uint32 {
-	crc := crc32.ChecksumIEEE(headerBody)
-	crc = crc32.Update(crc, crc32.IEEETable, rec.Key)
-	crc = crc32.Update(crc, crc32.IEEETable, rec.Value)
+	table := checksumTable(headerBody[0])
+	crc := crc32.Checksum(headerBody, table)
+	crc = crc32.Update(crc, table, rec.Key)
+	crc = crc32.Update(crc, table, rec.Value)
 	return crc
 }
+
+// checksumTable 根据 header 之中原始的 Type 字节，返回记录所使用的 CRC 表
+func checksumTable(rawType byte) *crc32.Table {
+	if rawType&checksumCastagnoliFlag != 0 {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
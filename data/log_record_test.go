@@ -126,3 +126,82 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:]) // crc32.Size is constant, which val is 4
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeBlobPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 7, Offset: 123456}
+	buf := EncodeBlobPos(pos, 4096)
+
+	decPos, size := DecodeBlobPos(buf)
+	assert.Equal(t, pos, decPos)
+	assert.Equal(t, int64(4096), size)
+}
+
+func TestEncodeMergeOperand(t *testing.T) {
+	prev := &LogRecordPos{Fid: 3, Offset: 98765}
+	decPrev, depth, operand := DecodeMergeOperand(EncodeMergeOperand(prev, 12, []byte("operand")))
+	assert.Equal(t, prev, decPrev)
+	assert.Equal(t, 12, depth)
+	assert.Equal(t, []byte("operand"), operand)
+
+	decPrev, depth, operand = DecodeMergeOperand(EncodeMergeOperand(nil, 1, nil))
+	assert.Nil(t, decPrev)
+	assert.Equal(t, 1, depth)
+	assert.Empty(t, operand)
+}
+
+// TestLogRecordChecksum 新记录默认使用 CRC32C，旧的 IEEE 记录依然可以被读取和校验
+func TestLogRecordChecksum(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordToDelete}
+	oldBuf, oldSize := EncodeLogRecordWithChecksum(rec, ChecksumIEEE)
+	newBuf, newSize := EncodeLogRecord(rec)
+	assert.Equal(t, []byte{43, 153, 86, 17, 1, 8, 20}, oldBuf[:7]) // 与旧版本写入的格式完全一致
+	assert.Equal(t, LogRecordToDelete|checksumCastagnoliFlag, newBuf[4])
+	assert.Equal(t, oldSize, newSize)
+
+	assert.Nil(t, dataFile.Write(oldBuf))
+	assert.Nil(t, dataFile.Write(newBuf))
+
+	for _, offset := range []int64{0, oldSize} {
+		readRec, size, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, rec, readRec)
+		assert.Equal(t, oldSize, size)
+	}
+
+	header, _ := decodeLogRecordHeader(newBuf)
+	assert.Equal(t, ChecksumCastagnoli, header.Checksum)
+	assert.Equal(t, crc32.Checksum(newBuf[4:], crc32.MakeTable(crc32.Castagnoli)), header.CRC)
+
+	// 翻转算法标志位之后，CRC 不再匹配
+	newBuf[4] &^= checksumCastagnoliFlag
+	assert.Nil(t, dataFile.Write(newBuf))
+	_, _, err = dataFile.ReadLogRecord(oldSize * 2)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+// TestLogRecordBucket 非默认 bucket 的记录在 header 之中携带 bucket id，默认 bucket 的记录格式不变
+func TestLogRecordBucket(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Bucket: 300}
+	buf, size := EncodeLogRecord(rec)
+	plainBuf, plainSize := EncodeLogRecord(&LogRecord{Key: rec.Key, Value: rec.Value})
+	assert.Equal(t, LogRecordNormal|checksumCastagnoliFlag|bucketFlag, buf[4])
+	assert.Equal(t, plainSize+2, size) // 300 编码为两个字节的 uvarint
+
+	assert.Nil(t, dataFile.Write(buf))
+	assert.Nil(t, dataFile.Write(plainBuf))
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+	readRec, _, err = dataFile.ReadLogRecord(size)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), readRec.Bucket)
+}
@@ -0,0 +1,191 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math"
+)
+
+// streamChunkSize 流式读写时每次搬运的字节数
+const streamChunkSize = 64 * 1024
+
+// streamTrailerSize 流式记录在 value 之后追加的 CRC 尾部长度
+const streamTrailerSize = crc32.Size
+
+var (
+	ErrValueTooLarge = errors.New("value size exceeds the limit of a single log record")
+)
+
+// 流式记录的格式为 header | key | value | valueCRC(4)。由于 CRC 位于 header 之中，而 value 在写入 header 时还没有读到，
+// 因此 header 之中的 CRC 只覆盖 headerBody + key，value 的 CRC 在写入过程中增量计算，最后作为尾部追加。
+
+// WriteStream 将 key 以及从 r 之中读取的 size 个字节，以 LogRecordStream 类型写入数据文件，返回写入的记录长度。
+// 如果 r 提前结束，剩余部分会用 0 填充以保证文件仍然可以被顺序解析，同时返回 io.ErrUnexpectedEOF，调用方不应当索引这条记录。
+func (df *DataFile) WriteStream(key []byte, r io.Reader, size int64) (int64, error) {
+	if size < 0 || size > math.MaxUint32 {
+		return 0, ErrValueTooLarge
+	}
+
+	header, headerSize := encodeLogRecordHeader(LogRecordStream, DefaultChecksum, 0, len(key), size)
+	table := checksumTable(header[4])
+	crc := crc32.Checksum(header[4:headerSize], table)
+	crc = crc32.Update(crc, table, key)
+	binary.LittleEndian.PutUint32(header, crc)
+
+	headBuf := make([]byte, int(headerSize)+len(key))
+	copy(headBuf, header[:headerSize])
+	copy(headBuf[headerSize:], key)
+	if err := df.Write(headBuf); err != nil {
+		return 0, err
+	}
+
+	// 增量计算 value 的 CRC，同时分块写入
+	var valueCRC uint32
+	var readErr error
+	buf := make([]byte, streamChunkSize)
+	remain := size
+	for remain > 0 {
+		chunk := buf
+		if remain < int64(len(chunk)) {
+			chunk = chunk[:remain]
+		}
+		if readErr == nil {
+			var n int
+			n, readErr = io.ReadFull(r, chunk)
+			if readErr == io.EOF {
+				readErr = io.ErrUnexpectedEOF
+			}
+			// 读取失败之后，剩余的部分都填充为 0
+			clear(chunk[n:])
+		} else {
+			clear(chunk)
+		}
+		valueCRC = crc32.Update(valueCRC, table, chunk)
+		if err := df.Write(chunk); err != nil {
+			return 0, err
+		}
+		remain -= int64(len(chunk))
+	}
+
+	trailer := make([]byte, streamTrailerSize)
+	binary.LittleEndian.PutUint32(trailer, valueCRC)
+	if err := df.Write(trailer); err != nil {
+		return 0, err
+	}
+	if readErr != nil {
+		return 0, readErr
+	}
+	return int64(len(headBuf)) + size + streamTrailerSize, nil
+}
+
+// NewValueReader 返回 offset 处记录的 value 读取器。对于流式记录，value 按块读取并增量校验 CRC，
+// 读到末尾时若校验失败则返回 ErrInvalidCRC；普通记录则直接读取整条记录。
+func (df *DataFile) NewValueReader(offset int64) (io.ReadCloser, LogRecordType, error) {
+	// ReadLogRecord 对于流式记录只会读取并校验 header + key，保证了 valueSize 是可信的
+	rec, _, err := df.ReadLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rec.Type != LogRecordStream {
+		return &bytesReadCloser{buf: rec.Value}, rec.Type, nil
+	}
+
+	header, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader := &streamValueReader{
+		df:     df,
+		offset: offset + headerSize + int64(header.KeySize),
+		remain: int64(header.ValueSize),
+		table:  crc32.IEEETable,
+	}
+	if header.Checksum == ChecksumCastagnoli {
+		reader.table = castagnoliTable
+	}
+	return reader, rec.Type, nil
+}
+
+// streamValueReader 按块读取流式记录的 value，并在读完后校验尾部 CRC
+type streamValueReader struct {
+	df     *DataFile
+	offset int64
+	remain int64
+	table  *crc32.Table // value 尾部 CRC 所使用的算法，与 header 之中记录的一致
+	crc    uint32
+	err    error
+}
+
+func (sr *streamValueReader) Read(p []byte) (int, error) {
+	if sr.err != nil {
+		return 0, sr.err
+	}
+	if sr.remain == 0 {
+		sr.err = sr.verify()
+		return 0, sr.err
+	}
+
+	if int64(len(p)) > sr.remain {
+		p = p[:sr.remain]
+	}
+	n, err := sr.df.readAt(p, sr.offset)
+	sr.crc = crc32.Update(sr.crc, sr.table, p[:n])
+	sr.offset += int64(n)
+	sr.remain -= int64(n)
+	if err == io.EOF {
+		// value 还没读完文件就结束了，说明记录不完整
+		if sr.remain > 0 {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		sr.err = err
+	}
+	return n, err
+}
+
+// verify 读取尾部的 CRC，并与增量计算得到的值进行比较
+func (sr *streamValueReader) verify() error {
+	trailer, err := sr.df.readNBytes(streamTrailerSize, sr.offset)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(trailer) != sr.crc {
+		return ErrInvalidCRC
+	}
+	return io.EOF
+}
+
+func (sr *streamValueReader) Close() error {
+	sr.err = fs.ErrClosed
+	return nil
+}
+
+// bytesReadCloser 将已经读入内存的 value 包装为 io.ReadCloser
+type bytesReadCloser struct {
+	buf []byte
+}
+
+func (br *bytesReadCloser) Read(p []byte) (int, error) {
+	if len(br.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, br.buf)
+	br.buf = br.buf[n:]
+	return n, nil
+}
+
+func (br *bytesReadCloser) Close() error {
+	br.buf = nil
+	return nil
+}
+
+// MaxStreamRecordSize 流式记录在数据文件之中最多占用的字节数，用于判断活跃文件是否需要切换
+func MaxStreamRecordSize(keySize int, valueSize int64) int64 {
+	return maxLogRecordHeaderSize + int64(keySize) + valueSize + streamTrailerSize
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataFile_WriteStream(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("stream-value-"), 20000) // 跨越多个 streamChunkSize
+	size, err := dataFile.WriteStream([]byte("big"), bytes.NewReader(value), int64(len(value)))
+	require.NoError(t, err)
+	assert.Equal(t, dataFile.WriteOff, size)
+
+	// ReadLogRecord 只读取 key，不读取 value
+	rec, recSize, err := dataFile.ReadLogRecord(0)
+	require.NoError(t, err)
+	assert.Equal(t, size, recSize)
+	assert.Equal(t, LogRecordStream, rec.Type)
+	assert.Equal(t, []byte("big"), rec.Key)
+	assert.Nil(t, rec.Value)
+
+	reader, typ, err := dataFile.NewValueReader(0)
+	require.NoError(t, err)
+	assert.Equal(t, LogRecordStream, typ)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	require.NoError(t, reader.Close())
+
+	// 后续的普通记录仍然可以正常读取
+	enc, _ := EncodeLogRecord(NewLogRecord([]byte("k"), []byte("v")))
+	require.NoError(t, dataFile.Write(enc))
+	rec, _, err = dataFile.ReadLogRecord(size)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), rec.Value)
+}
+
+func TestDataFile_WriteStreamShortReader(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	_, err = dataFile.WriteStream([]byte("short"), bytes.NewReader([]byte("abc")), 10)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 被填充之后记录依然是完整的，文件可以继续顺序解析
+	_, recSize, err := dataFile.ReadLogRecord(0)
+	require.NoError(t, err)
+	assert.Equal(t, dataFile.WriteOff, recSize)
+}
+
+func TestDataFile_NewValueReaderCorrupted(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	value := []byte("some streamed value")
+	_, err = dataFile.WriteStream([]byte("k"), bytes.NewReader(value), int64(len(value)))
+	require.NoError(t, err)
+
+	reader, _, err := dataFile.NewValueReader(0)
+	require.NoError(t, err)
+	sr := reader.(*streamValueReader)
+	sr.crc = 1 // 模拟读取过程中数据被篡改
+	_, err = io.ReadAll(reader)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
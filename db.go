@@ -1,14 +1,20 @@
 package bitcask_gown
 
 import (
+	"bitcask-gown/cache"
 	"bitcask-gown/data"
+	"bitcask-gown/fio"
 	"bitcask-gown/index"
+	"bitcask-gown/metrics"
+	"errors"
+	"fmt"
 	"io"
-	"os"
+	"log/slog"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // DB 定义数据库，以及相应字段
@@ -18,105 +24,271 @@ type DB struct {
 	lock       *sync.RWMutex             // 支持并发，需要锁
 	activeFile *data.DataFile            // 当前正在执行写入的活跃文件
 	oldFiles   map[uint32]*data.DataFile // 已经“写满”的旧数据文件
-	index      index.Indexer             // 索引部分，存储数据位置信息的地方
-	serialNum  uint64                    // 事务序列号，全局递增
+	streaming  map[uint32]struct{}       // 正在进行流式写入、尚未登记为旧文件的数据文件 id
+	index      index.Indexer             // 索引部分，存储数据位置信息的地方，即默认 bucket 的索引
+	seqNumber  uint64                    // 事务序列号，全局递增
+
+	defaultBucket *bucket            // 默认的 bucket，其索引即 index
+	buckets       map[string]*bucket // 名称 -> bucket，不包括默认的 bucket
+	bucketIDs     map[uint32]*bucket // id -> bucket，包括默认的 bucket
+	nextBucketID  uint32             // 下一个新建的 bucket 所使用的 id
+
+	blobActiveFile *data.DataFile            // 键值分离模式下，当前写入的 blob 文件
+	blobOldFiles   map[uint32]*data.DataFile // 键值分离模式下，已经写满的 blob 文件
+
+	committer  *groupCommitter   // SyncWrites 模式下的组提交队列
+	fs         fio.FileSystem    // 数据库所在的文件系统，InMemory 模式下为内存文件系统
+	valueCache *cache.ValueCache // 热点 value 的缓存，未开启时为 nil
+	watchHub   *watchHub         // 变更事件的订阅者
+	appended   *appendSignal     // 数据文件有新的数据写入时通知复制协程
+
+	replayer  *logReplayer // 从库增量更新索引所使用的重放器，只在 Replica 模式下存在
+	replayOff int64        // 从库活跃文件之中已经重放到的位置
+
+	listener Listener     // 内部事件的监听器，未设置时为 NopListener
+	logger   *slog.Logger // 结构化日志，未设置时丢弃所有日志
+
+	reclaimSize int64 // 数据文件之中已经失效、可以被回收的字节数，在索引更新时增量维护
+
+	bytesSinceSync int64          // 自上一次持久化以来写入的字节数
+	syncErr        error          // 后台持久化或者回滚失败的错误，之后的写入都会返回该错误
+	syncNotify     chan struct{}  // 写入字节数达到 BytesPerSync 时，通知后台协程持久化
+	syncClose      chan struct{}  // 关闭后台持久化协程以及复制协程
+	syncWg         sync.WaitGroup // 等待后台持久化协程退出
+	closeOnce      sync.Once
 }
 
 // NewDB 创建数据库实例
 func NewDB(options Options) (*DB, error) {
+	fileSystem := options.FileSystem
+	if options.InMemory {
+		fileSystem = fio.NewMemStore()
+	} else if fileSystem == nil {
+		fileSystem = fio.DefaultFileSystem
+	}
+
+	var valueCache *cache.ValueCache
+	if options.ValueCacheSize > 0 {
+		valueCache = cache.NewValueCache(options.ValueCacheSize)
+	}
+
+	listener := options.Listener
+	if listener == nil {
+		listener = NopListener{}
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	defaultBucket := &bucket{id: defaultBucketID, index: index.NewBTree()}
 	return &DB{
 		option:     options,
 		fileIds:    []int{},
 		lock:       new(sync.RWMutex),
 		activeFile: nil,
 		oldFiles:   make(map[uint32]*data.DataFile),
-		index:      index.NewBTree(),
+		streaming:  make(map[uint32]struct{}),
+		index:      defaultBucket.index,
+
+		defaultBucket: defaultBucket,
+		buckets:       make(map[string]*bucket),
+		bucketIDs:     map[uint32]*bucket{defaultBucketID: defaultBucket},
+		nextBucketID:  defaultBucketID + 1,
+
+		blobOldFiles: make(map[uint32]*data.DataFile),
+		committer:    newGroupCommitter(),
+		fs:           fileSystem,
+		valueCache:   valueCache,
+		watchHub:     newWatchHub(),
+		appended:     newAppendSignal(),
+		listener:     listener,
+		logger:       logger,
+		syncNotify:   make(chan struct{}, 1),
+		syncClose:    make(chan struct{}),
 	}, nil
 }
 
-func Open(opt Options) (*DB, error) {
+func Open(opt Options) (_ *DB, err error) {
 	// 校验配置信息
 	if ok := checkOptions(opt); ok != nil {
 		return nil, ok
 	}
 
-	// 打开对应的 DirPath 文件夹，如果不存在的话，则创建一个新的文件夹
-	if _, err := os.Stat(opt.DirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(opt.DirPath, os.ModePerm); err != nil {
+	db, err := NewDB(opt)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			db.logger.Error("open database failed", "dir", opt.DirPath, "err", err)
+		} else {
+			db.logger.Info("database opened", "dir", opt.DirPath, "files", len(db.fileIds),
+				"keys", db.keyNum(), "duration", time.Since(start))
+		}
+		db.listener.OnOpen(OpenInfo{DirPath: opt.DirPath, Duration: time.Since(start), Err: err})
+	}()
+
+	db.logger.Info("opening database", "dir", opt.DirPath)
+
+	// 打开对应的 DirPath 文件夹，如果不存在的话，则创建一个新的文件夹；只读模式下目录必须已经存在
+	if !opt.ReadOnly {
+		db.logger.Debug("creating data directory", "dir", opt.DirPath)
+		if err := db.fs.MkdirAll(opt.DirPath); err != nil {
+			db.logger.Error("create data directory failed", "dir", opt.DirPath, "err", err)
 			return nil, err
 		}
 	}
 
-	db, err := NewDB(opt)
-	if err != nil {
-		return nil, err
+	// 上一次运行时没有完成的流式写入
+	if !opt.ReadOnly {
+		if err := db.recoverStreamFiles(); err != nil {
+			return nil, err
+		}
+		if err := db.recoverBulkLoads(); err != nil {
+			return nil, err
+		}
 	}
 
 	// 填充 db 结构体之中的 activeFile, oldFiles 字段
 	if err := db.loadDataFile(); err != nil {
+		_ = db.closeFiles()
+		return nil, err
+	}
+
+	// 加载键值分离模式下的 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		_ = db.closeFiles()
 		return nil, err
 	}
 
 	// 填充 db 结构体之中的 Indexer 字段
 	if err := db.loadIndex(); err != nil {
+		_ = db.closeFiles()
 		return nil, err
 	}
+
+	if opt.Metrics != nil {
+		db.registerMetricGauges()
+	}
+
+	// 按照 BytesPerSync 或者 SyncInterval 定期持久化
+	if !opt.ReadOnly && (opt.BytesPerSync > 0 || opt.SyncInterval > 0) {
+		db.syncWg.Add(1)
+		go db.runBackgroundSync()
+	}
 	return db, nil
 }
 
 // Put 向 db 之中添加一条新的 logRecord 信息，将 logRecord 添加到活跃文件之后，还要将其添加到索引之中。
-func (db *DB) Put(key []byte, value []byte) error {
+func (db *DB) Put(key []byte, value []byte) (err error) {
+	defer db.observe(metrics.OpPut, time.Now(), &err)
+
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	return db.put(db.defaultBucket, key, value)
+}
 
-	db.lock.Lock()
-	defer db.lock.Unlock()
-
+// put 向 bkt 之中写入一条数据
+func (db *DB) put(bkt *bucket, key []byte, value []byte) error {
 	logRecord := &data.LogRecord{
-		Key:   recKeyWithSerialNum(key, nonTxnSerialNum),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    addSeqToKey(key, nonTxnSeqNumber),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Bucket: bkt.id,
+	}
+	apply := func(pos *data.LogRecordPos) error {
+		// bucket 可能在写入之前被删除，此时记录在重启之后同样会被忽略
+		if db.bucketIDs[bkt.id] != bkt {
+			return ErrBucketNotFound
+		}
+		if err := db.indexPut(bkt.index, key, pos); err != nil {
+			return err
+		}
+		if bkt.id == defaultBucketID {
+			db.notifyWatchers(EventPut, key, value, nonTxnSeqNumber, pos)
+		}
+		return nil
 	}
 
+	// 需要持久化的写入走组提交，多个并发写入共享一次 fsync
+	if db.option.SyncWrites {
+		return db.groupCommit(logRecord, apply)
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
 	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFailed
-	}
-	return nil
+	return apply(pos)
 }
 
 // Get 根据 key 来获取对应的 value 值的信息
-func (db *DB) Get(key []byte) ([]byte, error) {
+func (db *DB) Get(key []byte) (_ []byte, err error) {
+	defer db.observe(metrics.OpGet, time.Now(), &err)
+	return db.get("", key)
+}
+
+// get 读取名称为 bucketName 的 bucket 之中 key 对应的 value，bucket 不存在时返回 ErrKeyNotFound
+func (db *DB) get(bucketName string, key []byte) ([]byte, error) {
 	// 仍然是老规矩加锁，这里注意是加读锁
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
-	pos, ok := db.index.Get(key)
+	bkt := db.bucketByName(bucketName)
+	if bkt == nil {
+		return nil, ErrKeyNotFound
+	}
+	pos, ok := bkt.index.Get(key)
 	if !ok {
-		return nil, ErrIndexNotFound
+		return nil, ErrKeyNotFound
 	}
 
 	val, err := db.getValueByPos(pos)
 	if err != nil {
 		return nil, err
 	}
+	if db.option.Metrics != nil {
+		db.option.Metrics.AddBytesRead(int64(len(val)))
+	}
 	return val, nil
 }
 
 // 通过 pos 来获取对应的 dataFile -> LogRecord -> Value
 func (db *DB) getValueByPos(pos *data.LogRecordPos) ([]byte, error) {
-	var dataFile *data.DataFile
-	if db.activeFile.FileID == pos.Fid {
-		dataFile = db.activeFile
-	} else if db.oldFiles[pos.Fid] != nil {
-		dataFile = db.oldFiles[pos.Fid]
-	} else {
-		return nil, ErrDataFileNotFound
+	// 记录一旦写入就不会改变，因此缓存之中的 value 一定是最新的
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(pos); ok {
+			return value, nil
+		}
+	}
+
+	value, err := db.readValueByPos(pos)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCRC) || errors.Is(err, data.ErrIncompleteLogRecord) {
+			db.listener.OnCorruption(CorruptionInfo{FileID: pos.Fid, Offset: pos.Offset, Err: err})
+		}
+		return nil, err
+	}
+	if db.valueCache != nil {
+		db.valueCache.Put(pos, value)
+	}
+	return value, nil
+}
+
+// readValueByPos 从数据文件之中读取 pos 位置上记录的 value
+func (db *DB) readValueByPos(pos *data.LogRecordPos) ([]byte, error) {
+	dataFile, err := db.getDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
 	}
 
 	rec, _, err := dataFile.ReadLogRecord(pos.Offset)
@@ -129,49 +301,140 @@ func (db *DB) getValueByPos(pos *data.LogRecordPos) ([]byte, error) {
 		return nil, ErrKeyNotFound
 	}
 
+	// 键值分离的记录，value 需要到 blob 文件之中读取
+	if rec.Type == data.LogRecordBlobPtr {
+		return db.readBlob(rec.Value)
+	}
+
+	// 合并操作数，需要沿着链读取所有的操作数并合并
+	if rec.Type == data.LogRecordMerge {
+		return db.foldMergeChain(rec, nil)
+	}
+
+	// 流式写入的记录，ReadLogRecord 不会读取 value，需要单独读取并校验
+	if rec.Type == data.LogRecordStream {
+		reader, _, err := dataFile.NewValueReader(pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+
 	return rec.Value, nil
 }
 
+// getDataFile 根据文件 id 找到对应的数据文件
+func (db *DB) getDataFile(fid uint32) (*data.DataFile, error) {
+	if db.activeFile != nil && db.activeFile.FileID == fid {
+		return db.activeFile, nil
+	}
+	if dataFile := db.oldFiles[fid]; dataFile != nil {
+		return dataFile, nil
+	}
+	return nil, ErrDataFileNotFound
+}
+
 // Delete 采用追加写入的方式来删除一条数据，并且更新索引
-func (db *DB) Delete(key []byte) error {
+func (db *DB) Delete(key []byte) (err error) {
+	defer db.observe(metrics.OpDelete, time.Now(), &err)
+
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	return db.delete(db.defaultBucket, key)
+}
 
-	db.lock.Lock()
-	defer db.lock.Unlock()
-
-	// 如果 Key 不存在的话，则进行返回。
-	if _, ok := db.index.Get(key); !ok {
-		return ErrKeyNotFound
+// delete 从 bkt 之中删除一条数据
+func (db *DB) delete(bkt *bucket, key []byte) error {
+	// 如果 Key 不存在的话，则直接返回，删除一个不存在的 key 不视为错误。
+	if _, ok := bkt.index.Get(key); !ok {
+		return nil
 	}
 
 	recToDelete := &data.LogRecord{
-		Key:  recKeyWithSerialNum(key, nonTxnSerialNum),
-		Type: data.LogRecordToDelete,
+		Key:    addSeqToKey(key, nonTxnSeqNumber),
+		Type:   data.LogRecordToDelete,
+		Bucket: bkt.id,
 	}
 
-	_, err := db.appendLogRecord(recToDelete)
+	if db.option.SyncWrites {
+		return db.groupCommit(recToDelete, func(pos *data.LogRecordPos) error {
+			if db.bucketIDs[bkt.id] != bkt {
+				return ErrBucketNotFound
+			}
+			db.indexDelete(bkt.index, key, pos)
+			if bkt.id == defaultBucketID {
+				db.notifyWatchers(EventDelete, key, nil, nonTxnSeqNumber, pos)
+			}
+			return nil
+		})
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	pos, err := db.appendLogRecord(recToDelete)
 	if err != nil {
 		return err
 	}
+	if db.bucketIDs[bkt.id] != bkt {
+		return ErrBucketNotFound
+	}
 
 	// 内存索引更新，ok 返回 true 的话，肯定返回 nil
-	if ok := db.index.Delete(key); ok {
+	if ok := db.indexDelete(bkt.index, key, pos); ok {
+		if bkt.id == defaultBucketID {
+			db.notifyWatchers(EventDelete, key, nil, nonTxnSeqNumber, pos)
+		}
 		return nil
 	}
 	return ErrIndexDeleteFailed
 }
 
 // Close 数据库关闭操作
-func (db *DB) Close() error {
+func (db *DB) Close() (err error) {
+	defer func() {
+		if err != nil {
+			db.logger.Error("close database failed", "dir", db.option.DirPath, "err", err)
+		} else {
+			db.logger.Info("database closed", "dir", db.option.DirPath)
+		}
+		db.listener.OnClose(CloseInfo{DirPath: db.option.DirPath, Err: err})
+	}()
+
+	// 先停止后台持久化协程，它同样需要获取 db.lock
+	db.closeOnce.Do(func() {
+		close(db.syncClose)
+		db.syncWg.Wait()
+	})
+
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	// 将 activeFile 关闭
-	err := db.activeFile.Close()
-	if err != nil {
-		return err
+	// 数据库关闭之后不会再有变更，关闭所有的订阅
+	db.watchHub.closeAll()
+
+	// 关闭之前把尚未持久化的数据刷盘
+	if db.bytesSinceSync > 0 && db.activeFile != nil {
+		if err := db.syncActiveFiles(); err != nil {
+			return err
+		}
+	}
+
+	return db.closeFiles()
+}
+
+// closeFiles 关闭所有已经打开的数据文件以及 blob 文件
+func (db *DB) closeFiles() error {
+	// 将 activeFile 关闭；从未写入过的空数据库没有 activeFile
+	if db.activeFile != nil {
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
 	}
 
 	for _, oldFile := range db.oldFiles {
@@ -181,61 +444,62 @@ func (db *DB) Close() error {
 		}
 	}
 
-	return nil
+	return db.closeBlobFiles()
 }
 
 // Sync 将数据库之中的当前 activeFile 进行持久化即可
-func (db *DB) Sync() error {
-	if db.activeFile == nil {
-		return ErrActiveFileNotExist
+func (db *DB) Sync() (err error) {
+	defer db.observe(metrics.OpSync, time.Now(), &err)
+
+	if db.option.ReadOnly {
+		return ErrReadOnly
 	}
 
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	err := db.activeFile.Sync()
-	if err != nil {
-		return err
+	if db.activeFile == nil {
+		return ErrActiveFileNotExist
 	}
 
-	return nil
+	return db.syncActiveFiles()
+}
+
+// CacheStats 返回 value 缓存的统计信息，未开启缓存时返回零值
+func (db *DB) CacheStats() cache.Stats {
+	if db.valueCache == nil {
+		return cache.Stats{}
+	}
+	return db.valueCache.Stats()
 }
 
 // 理解为 Put 方法的辅助函数，对于这种私有辅助方法，可以不加锁
 func (db *DB) appendLogRecord(record *data.LogRecord) (*data.LogRecordPos, error) {
-	// 说明是第一次创建的 db 数据库实例，其 fileID 为0.
-	if db.activeFile == nil {
-		if err := db.createActiveFile(); err != nil {
-			return nil, err
-		}
+	if db.syncErr != nil {
+		return nil, db.syncErr
+	}
+
+	record, err := db.separateValue(record)
+	if err != nil {
+		return nil, err
 	}
 
 	encRecord, size := data.EncodeLogRecord(record) // 后续会实现将 logRecord 解码
 
-	// 判断是否超过文件大小，如果超过则创建新的 activeFile；注意这里要执行类型转换
-	if db.activeFile.WriteOff+int64(size) > db.option.DataFileSize {
-		// 1.持久化活跃文件
-		if err := db.activeFile.IOManager.Sync(); err != nil {
-			return nil, err
-		}
-		// 2.保存旧活跃文件
-		oldActiveFile := db.activeFile
-		// 3.创建一个新的活跃文件（ID 递增）
-		if err := db.createActiveFile(); err != nil {
-			return nil, err
-		}
-		// 4.将“写满”的活跃文件，转换为旧文件
-		db.oldFiles[oldActiveFile.FileID] = oldActiveFile
+	if err := db.rotateActiveFile(size); err != nil {
+		return nil, err
 	}
 
-	offset := db.activeFile.WriteOff
+	fid, offset := db.activeFile.FileID, db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
+		db.rollbackActiveFile(fid, offset)
 		return nil, err
 	}
+	db.addBytesWritten(size)
 
 	if db.option.SyncWrites {
-		err := db.activeFile.Sync()
-		if err != nil {
+		if err := db.syncActiveFiles(); err != nil {
+			db.rollbackActiveFile(fid, offset)
 			return nil, err
 		}
 	}
@@ -243,17 +507,135 @@ func (db *DB) appendLogRecord(record *data.LogRecord) (*data.LogRecordPos, error
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileID,
 		Offset: offset,
+		Size:   size,
 	}
 	return pos, nil
 }
 
-// 对应两种case：1. 无活跃文件，创建 fileId = 0的活跃文件。2. 有活跃文件，则创建原活跃文件 fileId + 1的活跃文件
-func (db *DB) createActiveFile() error {
-	var newActiveFileID uint32 = 0
-	if db.activeFile != nil {
-		newActiveFileID = db.activeFile.FileID + 1
+// activeFilePos 当前活跃文件的 id 以及写入位置，用于写入失败时回滚；尚无活跃文件时，第一个活跃文件的 id 为 0
+func (db *DB) activeFilePos() (uint32, int64) {
+	if db.activeFile == nil {
+		return 0, 0
+	}
+	return db.activeFile.FileID, db.activeFile.WriteOff
+}
+
+// checkWritable 检查数据库是否接受写入
+func (db *DB) checkWritable() error {
+	if db.option.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.option.Replica {
+		return ErrReplicaReadOnly
+	}
+	return nil
+}
+
+// rollbackActiveFile 写入失败时，将数据截断回写入之前的位置 (fid, offset)，避免中断的写入残留的数据被之后的写入以及重启后的恢复读到。
+// 如果写入过程中切换了一次或者多次活跃文件，之后创建的文件（包括当前的活跃文件）之中只有本次写入的数据，将它们整体截断。
+// 回滚失败时，之后的写入都会返回该错误。
+func (db *DB) rollbackActiveFile(fid uint32, offset int64) {
+	if db.activeFile == nil {
+		return
+	}
+
+	var err error
+	for id := fid; id <= db.activeFile.FileID; id++ {
+		dataFile, getErr := db.getDataFile(id)
+		if getErr != nil {
+			continue
+		}
+		truncOffset := int64(0)
+		if id == fid {
+			truncOffset = offset
+		}
+		if truncErr := dataFile.Truncate(truncOffset); err == nil {
+			err = truncErr
+		}
+	}
+	if err != nil && db.syncErr == nil {
+		db.logger.Error("rollback failed write failed, rejecting further writes", "file", fid, "offset", offset, "err", err)
+		db.syncErr = err
+	}
+}
+
+// separateValue 开启键值分离时，较大的 value 写入 blob 文件，返回主日志之中只保存其位置的记录；否则原样返回
+func (db *DB) separateValue(record *data.LogRecord) (*data.LogRecord, error) {
+	if db.option.ValueThreshold > 0 && record.Type == data.LogRecordNormal && len(record.Value) > db.option.ValueThreshold {
+		return db.writeBlob(record)
+	}
+	return record, nil
+}
+
+// syncActiveFiles 持久化活跃文件；blob 需要先于指向它的记录持久化
+func (db *DB) syncActiveFiles() (err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			db.logger.Error("sync active file failed", "file", db.activeFile.FileID, "err", err)
+		} else {
+			db.logger.Debug("active file synced", "file", db.activeFile.FileID, "duration", time.Since(start))
+		}
+		db.listener.OnSync(SyncInfo{FileID: db.activeFile.FileID, Duration: time.Since(start), Err: err})
+	}()
+
+	if db.blobActiveFile != nil {
+		if err := db.blobActiveFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.bytesSinceSync = 0
+	return nil
+}
+
+// rotateActiveFile 保证活跃文件存在，并且在写入 size 字节后不会超过文件大小的限制，否则切换到新的活跃文件
+func (db *DB) rotateActiveFile(size int64) error {
+	// 说明是第一次创建的 db 数据库实例，其 fileID 为0.
+	if db.activeFile == nil {
+		return db.createActiveFile(0)
+	}
+
+	// 判断是否超过文件大小，如果超过则创建新的 activeFile；注意这里要执行类型转换
+	if db.activeFile.WriteOff+size > db.option.DataFileSize {
+		return db.switchActiveFile(db.activeFile.FileID + 1)
+	}
+	return nil
+}
+
+// switchActiveFile 持久化当前的活跃文件并将其转为旧文件，之后创建 id 为 fileID 的活跃文件
+func (db *DB) switchActiveFile(fileID uint32) error {
+	// 1.持久化活跃文件；其中的 blob 指针只有在 blob 持久化之后才有效，blob 文件需要先于它持久化
+	if db.blobActiveFile != nil {
+		if err := db.blobActiveFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	// 2.保存旧活跃文件
+	oldActiveFile := db.activeFile
+	// 3.创建一个新的活跃文件
+	if err := db.createActiveFile(fileID); err != nil {
+		return err
 	}
-	newActiveFile, err := data.OpenDataFile(db.option.DirPath, newActiveFileID)
+	// 4.将“写满”的活跃文件，转换为旧文件
+	db.oldFiles[oldActiveFile.FileID] = oldActiveFile
+	if db.option.Metrics != nil {
+		db.option.Metrics.IncRotation()
+	}
+	db.logger.Info("active file rotated", "old_file", oldActiveFile.FileID, "new_file", db.activeFile.FileID,
+		"old_size", oldActiveFile.WriteOff)
+	db.listener.OnFileRotate(FileRotateInfo{OldFileID: oldActiveFile.FileID, NewFileID: db.activeFile.FileID})
+	return nil
+}
+
+// createActiveFile 创建 id 为 fileID 的活跃文件
+func (db *DB) createActiveFile(fileID uint32) error {
+	newActiveFile, err := data.OpenDataFileWithOptions(db.option.DirPath, fileID, db.dataFileOptions())
 	if err != nil {
 		return err
 	}
@@ -261,42 +643,52 @@ func (db *DB) createActiveFile() error {
 	return nil
 }
 
+// dataFileOptions 活跃数据文件的打开选项
+func (db *DB) dataFileOptions() data.FileOptions {
+	opt := db.oldFileOptions()
+	if db.option.ReadOnly {
+		return opt
+	}
+	opt.WriteBufferSize = db.option.WriteBufferSize
+	if db.option.PreallocateDataFile {
+		opt.PreallocSize = db.option.DataFileSize
+	}
+	return opt
+}
+
+// oldFileOptions 旧数据文件的打开选项，旧文件只会被读取，不需要预分配以及写缓冲
+func (db *DB) oldFileOptions() data.FileOptions {
+	opt := data.FileOptions{
+		IOType:     db.option.IOType,
+		FileSystem: db.fs,
+	}
+	if db.option.ReadOnly {
+		opt.IOType = fio.ReadOnlyFIO
+	}
+	return opt
+}
+
 // 从磁盘之中加载数据文件
 func (db *DB) loadDataFile() error {
-	// 读取配置文件下其中的文件夹路径信息
-	dirEntries, err := os.ReadDir(db.option.DirPath)
+	dataFileIds, err := db.listFileIds(data.DataFileNameSuffix)
 	if err != nil {
 		return err
 	}
 
-	// 遍历路径下所有 .data 后缀文件，将其添加到 dataFileIds 数组之中
-	// 其中涉及到了很多我之前没接触过的方法：strings.HasSuffix, strings.Split ...
-	var dataFileIds []int
-
-	for _, dirEntry := range dirEntries {
-		if strings.HasSuffix(dirEntry.Name(), data.DataFileNameSuffix) {
-			splitNames := strings.Split(dirEntry.Name(), ".")
-			fileName, err := strconv.Atoi(splitNames[0]) // convert string to int
-			if err != nil {
-				return err
-			}
-			dataFileIds = append(dataFileIds, fileName)
-		}
-	}
-
-	// 对 dataFileIds 进行排序
-	sort.Ints(dataFileIds)
 	db.fileIds = dataFileIds
+	db.logger.Debug("loading data files", "dir", db.option.DirPath, "count", len(dataFileIds))
 
 	for i, fileId := range dataFileIds {
 		if i == len(dataFileIds)-1 {
-			db.activeFile, err = data.OpenDataFile(db.option.DirPath, uint32(fileId))
+			db.activeFile, err = data.OpenDataFileWithOptions(db.option.DirPath, uint32(fileId), db.dataFileOptions())
 			if err != nil {
+				db.logger.Error("open active file failed", "file", fileId, "err", err)
 				return err
 			}
 		} else {
-			oldFile, err := data.OpenDataFile(db.option.DirPath, uint32(fileId))
+			oldFile, err := data.OpenDataFileWithOptions(db.option.DirPath, uint32(fileId), db.oldFileOptions())
 			if err != nil {
+				db.logger.Error("open data file failed", "file", fileId, "err", err)
 				return err
 			}
 			db.oldFiles[oldFile.FileID] = oldFile
@@ -305,35 +697,96 @@ func (db *DB) loadDataFile() error {
 	return nil
 }
 
+// recoverStreamFiles 删除上一次运行时没有完成的流式写入文件，其中的记录没有完成标记，不会生效。
+// 同时在原位置创建同 id 的空数据文件，保持数据文件的 id 连续，复制时按照 id 依次发送数据文件
+func (db *DB) recoverStreamFiles() error {
+	fileIds, err := db.listFileIds(data.StreamFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fileId := range fileIds {
+		if err := db.removeFile(data.FileName(uint32(fileId), data.StreamFileNameSuffix)); err != nil {
+			return err
+		}
+		dataFile, err := data.OpenDataFileWithOptions(db.option.DirPath, uint32(fileId), db.oldFileOptions())
+		if err != nil {
+			return err
+		}
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+		db.logger.Warn("unfinished stream write discarded", "file", fileId)
+	}
+	return nil
+}
+
+// listFileIds 列出数据目录下所有带有 suffix 后缀的文件 id，并按照从小到大排序
+func (db *DB) listFileIds(suffix string) ([]int, error) {
+	return listFileIds(db.fs, db.option.DirPath, suffix)
+}
+
+// listFileIds 列出 dirPath 目录下所有后缀为 suffix 的文件的 id，按照从小到大排序
+func listFileIds(fileSystem fio.FileSystem, dirPath string, suffix string) ([]int, error) {
+	fileNames, err := fileSystem.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 遍历路径下所有指定后缀的文件，将其添加到 fileIds 数组之中
+	// 其中涉及到了很多我之前没接触过的方法：strings.HasSuffix, strings.Split ...
+	var fileIds []int
+
+	for _, fileName := range fileNames {
+		if strings.HasSuffix(fileName, suffix) {
+			splitNames := strings.Split(fileName, ".")
+			fileId, err := strconv.Atoi(splitNames[0]) // convert string to int
+			if err != nil {
+				return nil, err
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	// 对 fileIds 进行排序
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
+// removeFile 删除数据目录下名为 fileName 的文件
+func (db *DB) removeFile(fileName string) error {
+	return db.fs.Remove(db.fs.Join(db.option.DirPath, fileName))
+}
+
 // 在引入事务之后，其复杂度也相应增加。因为我们需要考虑类型 LogRecordTxnFinished 作为事务结束的标志；
 // 我吐槽一点，我认为这个方法写的很特么乱，纯粹是未来给自己找不痛快。
 func (db *DB) loadIndex() error {
+	replayer := newLogReplayer(db)
+	if db.option.Replica {
+		// 从库末尾未完成的事务，其余下的记录以及完成标记还会从主库复制过来
+		db.replayer = replayer
+	} else if !db.option.ReadOnly && db.option.MergeOperator != nil {
+		// 记录恢复之后仍然是操作数链的 key，加载完成之后将它们合并
+		replayer.mergeHeads = make(map[string]struct{})
+	}
+
 	// 判断是否存在数据文件，如果 fileIDs 为空，必然是不存在数据文件
 	if len(db.fileIds) == 0 {
 		return nil
 	}
-
-	updateIndex := func(typ data.LogRecordType, realKey []byte, pos *data.LogRecordPos) error {
-		// 更新内存索引
-		var ok bool
-		if typ == data.LogRecordToDelete {
-			ok = db.index.Delete(realKey) // 假设已经在 Index 之中被删除了，会导致返回错误。
-		} else {
-			ok = db.index.Put(realKey, pos)
-		}
-		if !ok {
-			return ErrIndexUpdateFailed
-		}
-		return nil
+	hintFileIds, err := db.listFileIds(data.HintFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	hinted := make(map[int]bool, len(hintFileIds))
+	for _, fileId := range hintFileIds {
+		hinted[fileId] = true
 	}
-
-	// 暂存事务的映射，即事务号 -> 事务 （logRecord 形成的数组）
-	txnBuf := make(map[uint64][]*data.TxnLogRecord)
-	var newestSerialNum uint64 = 0
 
 	var dataFile *data.DataFile
 	for i, fileId := range db.fileIds {
 		var offset int64 = 0
+		var records int
+		start := time.Now()
 		// 不要重复打开数据文件！已打开的存在于 db 结构体的 oldFiles, activeFile 字段之中
 		if i == len(db.fileIds)-1 {
 			dataFile = db.activeFile
@@ -341,6 +794,13 @@ func (db *DB) loadIndex() error {
 			dataFile = db.oldFiles[uint32(fileId)]
 		}
 
+		// 存在提示文件时，其覆盖的部分直接通过提示文件加载，只需要扫描在它之后追加的记录
+		if hinted[fileId] {
+			if offset, records, err = db.loadHintFile(replayer, dataFile); err != nil {
+				return err
+			}
+		}
+
 		// 持续读取，直到文件末尾 -- EOF
 		for {
 			// 根据 offset 从 DataFile 之中提取出 LogRecord；但其实是想要获取对应 LogRecord 的Key以及长度，以便于更新索引
@@ -349,6 +809,35 @@ func (db *DB) loadIndex() error {
 				if err == io.EOF {
 					break
 				}
+				// 活跃文件末尾的不完整或者损坏的记录，是崩溃时被中断的写入留下的，将其截断丢弃；
+				// 活跃文件中间的损坏以及旧文件之中的损坏则直接返回错误，不能丢弃之后已经持久化的数据
+				corruption := CorruptionInfo{FileID: uint32(fileId), Offset: offset, Err: err}
+				torn := false
+				if i == len(db.fileIds)-1 && (err == data.ErrIncompleteLogRecord || err == data.ErrInvalidCRC) {
+					var tornErr error
+					if torn, tornErr = dataFile.IsTornTail(offset); tornErr != nil {
+						return tornErr
+					}
+				}
+				if torn {
+					// 只读模式下不修改文件，只是忽略末尾的数据
+					if db.option.ReadOnly {
+						db.logger.Warn("ignoring torn tail of active file", "file", fileId, "offset", offset, "err", err)
+						db.listener.OnCorruption(corruption)
+						break
+					}
+					db.logger.Warn("truncating torn tail of active file", "file", fileId, "offset", offset, "err", err)
+					corruption.Truncated = true
+					db.listener.OnCorruption(corruption)
+					if err := dataFile.Truncate(offset); err != nil {
+						return err
+					}
+					break
+				}
+				if err == data.ErrIncompleteLogRecord || err == data.ErrInvalidCRC {
+					db.listener.OnCorruption(corruption)
+				}
+				db.logger.Error("replay data file failed", "file", fileId, "offset", offset, "err", err)
 				return err
 			}
 
@@ -356,51 +845,78 @@ func (db *DB) loadIndex() error {
 			pos := &data.LogRecordPos{
 				Fid:    uint32(fileId),
 				Offset: offset,
+				Size:   size,
 			}
 
-			// 解析 logRecord.Key，获取 realKey、txnSerialNum
-			realKey, serialNum := parseLogRecordKey(record.Key)
-
-			// 根据 txnSerialNum，如果不是事务，则立即更新内存索引
-			if serialNum == nonTxnSerialNum {
-				if err := updateIndex(record.Type, realKey, pos); err != nil {
-					return err
-				}
-			} else {
-				// 如果是事务的话...即读取到了事务结束的标志
-				TxnRecs := txnBuf[serialNum] //TODO: 如果一开始为空的话，是否还会建立一个新的数组呢？
-				if record.Type == data.LogRecordTxnFinished {
-					for _, TxnRec := range TxnRecs {
-						if err := updateIndex(TxnRec.Record.Type, realKey, TxnRec.Pos); err != nil {
-							return err
-						}
-					}
-					delete(txnBuf, serialNum) // 写入完毕后，执行删除操作
-				} else {
-					// 反之，如果没有读到事务结束标记，则将其记录到我们的 txnBuf 之中
-					record.Key = realKey // 感觉很奇怪，很突兀
-					txnRec := &data.TxnLogRecord{
-						Record: record,
-						Pos:    pos,
-					}
-					TxnRecs = append(TxnRecs, txnRec)
-				}
-			}
-
-			// 更新序列号
-			if serialNum > newestSerialNum {
-				newestSerialNum = serialNum
+			if err := replayer.replay(record, pos); err != nil {
+				return err
 			}
 
 			offset += size // 递增 offset 部分内容
+			records++
 		}
 
-		// 若为当前活跃文件，更新该文件 WriteOff
-		// TODO: 为什么是它来更新呢？为什么该 loadIndex 有那么多指责要做？？？
+		// 更新文件的 WriteOff 为数据真实的末尾：活跃文件之后从这里继续写入，预分配的旧文件末尾则是填充的 0
+		dataFile.SetWriteOff(offset)
 		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+			db.replayOff = offset
 		}
+		db.logger.Debug("data file replayed", "file", fileId, "records", records, "size", offset,
+			"progress", fmt.Sprintf("%d/%d", i+1, len(db.fileIds)), "duration", time.Since(start))
+		db.listener.OnRecoveryProgress(RecoveryInfo{
+			FileID:     uint32(fileId),
+			FilesDone:  i + 1,
+			FilesTotal: len(db.fileIds),
+			Records:    records,
+			Duration:   time.Since(start),
+		})
+	}
+	db.seqNumber = replayer.newestSeqNumber
+
+	if !db.option.Replica {
+		replayer.discardUnfinished()
+	}
+	db.logger.Info("index loaded", "files", len(db.fileIds), "keys", db.keyNum(), "seq", db.seqNumber)
+	return db.collapseMergeChains(replayer.mergeHeads)
+}
+
+// loadHintFile 通过 dataFile 对应的提示文件重建索引，返回提示文件覆盖的数据末尾以及其中记录的数量。
+// 每一条提示都会与数据文件之中对应记录的 header 以及 key 比对，提示文件损坏或者与数据文件不一致时忽略它，返回 0 从头扫描数据文件
+func (db *DB) loadHintFile(replayer *logReplayer, dataFile *data.DataFile) (int64, int, error) {
+	hintFile, err := data.OpenHintFile(db.option.DirPath, dataFile.FileID, db.oldFileOptions())
+	if err != nil {
+		return 0, 0, err
 	}
-	db.serialNum = newestSerialNum
-	return nil
+	defer hintFile.Close()
+
+	var keys [][]byte
+	var positions []*data.LogRecordPos
+	var offset, end int64
+	for {
+		hint, size, err := hintFile.ReadHintRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		matched := false
+		if err == nil && hint.Pos.Offset+hint.Pos.Size <= dataFile.WriteOff {
+			if matched, err = dataFile.MatchesHint(hint, addSeqToKey(hint.Key, nonTxnSeqNumber)); err != nil {
+				return 0, 0, err
+			}
+		}
+		if !matched {
+			db.logger.Warn("ignoring invalid hint file", "file", dataFile.FileID, "offset", offset, "err", err)
+			return 0, 0, nil
+		}
+		keys = append(keys, hint.Key)
+		positions = append(positions, hint.Pos)
+		end = max(end, hint.Pos.Offset+hint.Pos.Size)
+		offset += size
+	}
+
+	for i, key := range keys {
+		if err := replayer.replayHint(key, positions[i]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return end, len(keys), nil
 }
@@ -0,0 +1,90 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"errors"
+	"time"
+)
+
+// errorNames 错误变量及其名称，用于按照错误变量统计各个操作返回的错误；errors.go 以及 data 包之中的每一个错误变量都需要在这里登记
+var errorNames = []struct {
+	err  error
+	name string
+}{
+	{ErrKeyIsEmpty, "ErrKeyIsEmpty"},
+	{ErrIndexUpdateFailed, "ErrIndexUpdateFailed"},
+	{ErrIndexNotFound, "ErrIndexNotFound"},
+	{ErrDataFileNotFound, "ErrDataFileNotFound"},
+	{ErrDirPathIsEmpty, "ErrDirPathIsEmpty"},
+	{ErrInvalidDataFileSize, "ErrInvalidDataFileSize"},
+	{ErrKeyNotFound, "ErrKeyNotFound"},
+	{ErrIndexDeleteFailed, "ErrIndexDeleteFailed"},
+	{ErrPendingWritesInvalid, "ErrPendingWritesInvalid"},
+	{ErrExceedMaxBatchNum, "ErrExceedMaxBatchNum"},
+	{ErrActiveFileNotExist, "ErrActiveFileNotExist"},
+	{ErrInvalidValueThreshold, "ErrInvalidValueThreshold"},
+	{ErrInvalidDiscardRatio, "ErrInvalidDiscardRatio"},
+	{ErrInvalidSyncInterval, "ErrInvalidSyncInterval"},
+	{ErrInvalidWriteBufferSize, "ErrInvalidWriteBufferSize"},
+	{ErrInvalidIOType, "ErrInvalidIOType"},
+	{ErrInvalidValueCacheSize, "ErrInvalidValueCacheSize"},
+	{ErrReplicaReadOnly, "ErrReplicaReadOnly"},
+	{ErrNotReplica, "ErrNotReplica"},
+	{ErrReplicaDiverged, "ErrReplicaDiverged"},
+	{ErrReplicationUnsupported, "ErrReplicationUnsupported"},
+	{ErrInvalidFileSystem, "ErrInvalidFileSystem"},
+	{ErrBackupDirNotEmpty, "ErrBackupDirNotEmpty"},
+	{ErrReadOnly, "ErrReadOnly"},
+	{ErrInvalidReadOnly, "ErrInvalidReadOnly"},
+	{ErrBucketNotFound, "ErrBucketNotFound"},
+	{ErrInvalidBucketName, "ErrInvalidBucketName"},
+	{ErrMergeOperatorNotSet, "ErrMergeOperatorNotSet"},
+	{ErrInvalidMergeOperand, "ErrInvalidMergeOperand"},
+	{ErrInvalidRange, "ErrInvalidRange"},
+	{ErrInvalidBulkLoader, "ErrInvalidBulkLoader"},
+	{ErrBulkLoaderFinished, "ErrBulkLoaderFinished"},
+	{ErrInvalidImportData, "ErrInvalidImportData"},
+	{ErrDatabaseClosed, "ErrDatabaseClosed"},
+	{data.ErrInvalidCRC, "ErrInvalidCRC"},
+	{data.ErrIncompleteLogRecord, "ErrIncompleteLogRecord"},
+	{data.ErrValueTooLarge, "ErrValueTooLarge"},
+	{data.ErrInvalidHint, "ErrInvalidHint"},
+}
+
+// errorName 返回 err 对应的错误变量名称，不属于已知错误变量的统一记为 other
+func errorName(err error) string {
+	for _, e := range errorNames {
+		if errors.Is(err, e.err) {
+			return e.name
+		}
+	}
+	return "other"
+}
+
+// observe 记录一次 op 操作的耗时，以及 *errp 不为 nil 时的错误；通过 defer 调用，未开启指标时什么也不做
+func (db *DB) observe(op string, start time.Time, errp *error) {
+	if db.option.Metrics == nil {
+		return
+	}
+	db.option.Metrics.ObserveLatency(op, time.Since(start))
+	if *errp != nil {
+		db.option.Metrics.IncError(op, errorName(*errp))
+	}
+}
+
+// registerMetricGauges 将数据库的统计信息注册为瞬时值指标，导出时通过 Stat 获取
+func (db *DB) registerMetricGauges() {
+	m := db.option.Metrics
+	m.SetGauge("bitcask_data_files", "Number of data files.", func() float64 {
+		return float64(db.Stat().DataFileNum)
+	})
+	m.SetGauge("bitcask_keys", "Number of keys in the index.", func() float64 {
+		return float64(db.Stat().KeyNum)
+	})
+	m.SetGauge("bitcask_disk_bytes", "Total size of all data files.", func() float64 {
+		return float64(db.Stat().DiskSize)
+	})
+	m.SetGauge("bitcask_reclaimable_bytes", "Estimated bytes in data files that can be reclaimed.", func() float64 {
+		return float64(db.Stat().ReclaimableSize)
+	})
+}
@@ -0,0 +1,95 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/metrics"
+	"bitcask-gown/utils"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_Metrics ensures DB operations are reflected in the exported metrics.
+func TestDB_Metrics(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 256
+	setup.Metrics = metrics.New()
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	_, err := db.Get(utils.GetTestKey(0))
+	require.NoError(t, err)
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	require.NoError(t, db.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrKeyIsEmpty, db.Put(nil, nil))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put(utils.GetTestKey(2), utils.RandomValue(4)))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Sync())
+
+	var sb strings.Builder
+	require.NoError(t, setup.Metrics.WriteText(&sb))
+	text := sb.String()
+
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="put"} 11`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="get"} 2`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="delete"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="batch_commit"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="sync"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_errors_total{op="get",error="ErrKeyNotFound"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_errors_total{op="put",error="ErrKeyIsEmpty"} 1`+"\n")
+	assert.Contains(t, text, "bitcask_keys 9\n")
+	assert.NotContains(t, text, "bitcask_file_rotations_total 0\n")
+	assert.NotContains(t, text, "bitcask_written_bytes_total 0\n")
+	assert.Contains(t, text, "bitcask_read_bytes_total "+strconv.Itoa(len(utils.RandomValue(16)))+"\n")
+}
+
+// TestErrorNames ensures every exported Err* variable of this package and the data package has its own metric label.
+func TestErrorNames(t *testing.T) {
+	named := make(map[string]bool, len(errorNames))
+	for _, e := range errorNames {
+		assert.Equal(t, e.name, errorName(e.err))
+		named[e.name] = true
+	}
+
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	dataFiles, err := filepath.Glob(filepath.Join("data", "*.go"))
+	require.NoError(t, err)
+
+	var declared int
+	fset := token.NewFileSet()
+	for _, file := range append(files, dataFiles...) {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		require.NoError(t, err)
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					if ident.IsExported() && strings.HasPrefix(ident.Name, "Err") {
+						declared++
+						assert.True(t, named[ident.Name], "%s declared in %s has no entry in errorNames", ident.Name, file)
+					}
+				}
+			}
+		}
+	}
+	assert.Equal(t, len(errorNames), declared)
+}
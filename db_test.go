@@ -1,7 +1,11 @@
 package bitcask_gown
 
 import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
 	"bitcask-gown/utils"
+	"bytes"
+	"log/slog"
 	"os"
 	"testing"
 
@@ -128,3 +132,138 @@ func TestDB_RestartPersistsData(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, val, got)
 }
+
+// TestDB_PreallocAndWriteBuffer ensures preallocated, buffered data files replay correctly after restart.
+func TestDB_PreallocAndWriteBuffer(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4096
+	setup.PreallocateDataFile = true
+	setup.WriteBufferSize = 512
+
+	db, err := Open(setup)
+	require.NoError(t, err)
+	values := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(32)
+		require.NoError(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	got, err := db.Get(utils.GetTestKey(99))
+	require.NoError(t, err)
+	assert.Equal(t, values[99], got)
+	require.NoError(t, db.Close())
+
+	// 重启后继续写入，新的数据必须紧跟在已有数据之后，而不是预分配区域之后
+	for round := 0; round < 2; round++ {
+		db, err = Open(setup)
+		require.NoError(t, err)
+		values[round] = utils.RandomValue(16)
+		require.NoError(t, db.Put(utils.GetTestKey(round), values[round]))
+		require.NoError(t, db.Close())
+	}
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(reopened)
+	for i, want := range values {
+		got, err := reopened.Get(utils.GetTestKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+// TestDB_DirectIO ensures a DB backed by O_DIRECT files works across restarts.
+func TestDB_DirectIO(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 8192
+	setup.IOType = fio.DirectFIO
+
+	db, err := Open(setup)
+	require.NoError(t, err)
+	values := make(map[int][]byte)
+	for i := 0; i < 200; i++ {
+		values[i] = utils.RandomValue(i % 50)
+		if err := db.Put(utils.GetTestKey(i), values[i]); err != nil {
+			destroyDB(db)
+			t.Skipf("direct io is not available: %v", err)
+		}
+	}
+	require.NoError(t, db.Close())
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, reopened.Put(utils.GetTestKey(0), []byte("after-restart")))
+	values[0] = []byte("after-restart")
+	defer destroyDB(reopened)
+	for i, want := range values {
+		got, err := reopened.Get(utils.GetTestKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+// TestDB_ValueCache ensures repeated reads hit the cache and overwrites are never served stale.
+func TestDB_ValueCache(t *testing.T) {
+	setup := DefaultOptions
+	setup.ValueCacheSize = 1 << 20
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	key := utils.GetTestKey(0)
+	require.NoError(t, db.Put(key, []byte("v1")))
+	for i := 0; i < 3; i++ {
+		got, err := db.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), got)
+	}
+	stats := db.CacheStats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	require.NoError(t, db.Put(key, []byte("v2")))
+	got, err := db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), got)
+
+	require.NoError(t, db.Delete(key))
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	setup.ValueCacheSize = -1
+	_, err = Open(setup)
+	assert.Equal(t, ErrInvalidValueCacheSize, err)
+}
+
+// TestDB_Logger ensures open, replay, rotation, sync and close are logged.
+func TestDB_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	setup := DefaultOptions
+	setup.DataFileSize = 256
+	setup.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db, cleanup := newDB(t, setup)
+	defer func() { cleanup() }()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	require.NoError(t, db.Sync())
+
+	// 写入一条没有完成标记的事务记录，重新打开时会被丢弃
+	db.lock.Lock()
+	_, err := db.appendLogRecord(&data.LogRecord{Key: addSeqToKey([]byte("k"), 100), Value: []byte("v")})
+	db.lock.Unlock()
+	require.NoError(t, err)
+
+	setup.DirPath = db.option.DirPath
+	require.NoError(t, db.Close())
+	db, err = Open(setup)
+	require.NoError(t, err)
+
+	logs := buf.String()
+	for _, msg := range []string{"opening database", "creating data directory", "database opened", "active file rotated",
+		"active file synced", "database closed", "loading data files", "data file replayed", "index loaded"} {
+		assert.Contains(t, logs, "msg=\""+msg+"\"")
+	}
+	assert.Contains(t, logs, `msg="discarded unfinished transactions" txns=1 records=1`)
+}
@@ -0,0 +1,103 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/metrics"
+	"bytes"
+	"time"
+)
+
+// 范围删除：DeleteRange 以及 DeletePrefix 只追加一条 LogRecordRangeDelete 记录（范围墓碑），key 为范围的起点，value 为终点，
+// 随后在一次加锁之中从索引里删除整个范围，不需要为每个 key 写入墓碑。
+// 重启时范围墓碑与其他记录一样按照写入的顺序重放，只会删除在它之前写入的 key。
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，end 为 nil 时删除 start 之后的全部 key
+func (db *DB) DeleteRange(start, end []byte) (err error) {
+	defer db.observe(metrics.OpDelete, time.Now(), &err)
+
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	return db.deleteRange(start, end)
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key
+func (db *DB) DeletePrefix(prefix []byte) (err error) {
+	defer db.observe(metrics.OpDelete, time.Now(), &err)
+
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	return db.deleteRange(prefix, prefixEnd(prefix))
+}
+
+// deleteRange 追加一条范围墓碑，并从默认 bucket 的索引之中删除 [start, end) 范围内的 key
+func (db *DB) deleteRange(start, end []byte) error {
+	record := &data.LogRecord{
+		Key:   addSeqToKey(start, nonTxnSeqNumber),
+		Value: end,
+		Type:  data.LogRecordRangeDelete,
+	}
+	apply := func(pos *data.LogRecordPos) error {
+		removed := db.indexDeleteRange(db.index, start, end, pos)
+		db.logger.Debug("range deleted", "keys", removed)
+		db.notifyRangeDelete(start, end, pos)
+		return nil
+	}
+
+	if db.option.SyncWrites {
+		return db.groupCommit(record, apply)
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	pos, err := db.appendLogRecord(record)
+	if err != nil {
+		return err
+	}
+	return apply(pos)
+}
+
+// notifyRangeDelete 发布范围删除的事件；调用方需要持有 db.lock
+func (db *DB) notifyRangeDelete(start, end []byte, pos *data.LogRecordPos) {
+	if !db.watchHub.watching() {
+		return
+	}
+	e := newWatchEvent(EventDeleteRange, start, nil, nonTxnSeqNumber, pos)
+	if end != nil {
+		e.End = append([]byte{}, end...)
+	}
+	db.watchHub.publish([]Event{e})
+}
+
+// rangeEnd 将范围墓碑之中保存的终点还原，为空时表示没有上界
+func rangeEnd(value []byte) []byte {
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
+
+// prefixEnd 返回大于所有以 prefix 为前缀的 key 的最小的 key，prefix 全部由 0xff 组成时返回 nil，即没有上界
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// keyInRange 判断 key 是否位于 [start, end) 范围内，end 为 nil 时没有上界
+func keyInRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && (end == nil || bytes.Compare(key, end) < 0)
+}
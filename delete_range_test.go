@@ -0,0 +1,102 @@
+package bitcask_gown
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_DeleteRange ensures range and prefix deletes drop whole ranges and replay in order with later writes.
+func TestDB_DeleteRange(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4 * 1024
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	for _, tenant := range []string{"a", "b", "c"} {
+		for i := 0; i < 50; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("tenant/%s/%03d", tenant, i)), []byte("v")))
+		}
+	}
+	reclaimBefore := db.Stat().ReclaimableSize
+
+	require.NoError(t, db.DeletePrefix([]byte("tenant/a/")))
+	// 范围墓碑之后写入的 key 不受影响
+	require.NoError(t, db.Put([]byte("tenant/a/new"), []byte("v")))
+	require.NoError(t, db.DeleteRange([]byte("tenant/b/010"), []byte("tenant/b/040")))
+	assert.Equal(t, 1+20+50, db.Stat().KeyNum)
+	assert.Greater(t, db.Stat().ReclaimableSize, reclaimBefore)
+
+	assert.Equal(t, ErrInvalidRange, db.DeleteRange([]byte("b"), []byte("a")))
+	assert.Equal(t, ErrInvalidRange, db.DeleteRange([]byte("a"), []byte("a")))
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+
+	check := func(db *DB) {
+		_, err := db.Get([]byte("tenant/a/000"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get([]byte("tenant/a/new"))
+		assert.NoError(t, err)
+		_, err = db.Get([]byte("tenant/b/010"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get([]byte("tenant/b/009"))
+		assert.NoError(t, err)
+		_, err = db.Get([]byte("tenant/b/040"))
+		assert.NoError(t, err)
+		_, err = db.Get([]byte("tenant/c/049"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1+20+50, db.Stat().KeyNum)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	check(db)
+
+	// end 为 nil 时删除起点之后的全部 key
+	require.NoError(t, db.DeleteRange([]byte("tenant/b/"), nil))
+	assert.Equal(t, 1, db.Stat().KeyNum)
+	require.NoError(t, db.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 1, db.Stat().KeyNum)
+}
+
+// TestDB_DeleteRangeWatch ensures watchers whose prefix overlaps the deleted range receive a range event.
+func TestDB_DeleteRangeWatch(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	users, cancelUsers := db.Watch([]byte("user/"))
+	defer cancelUsers()
+	orders, cancelOrders := db.Watch([]byte("order/"))
+	defer cancelOrders()
+
+	require.NoError(t, db.DeletePrefix([]byte("user/1")))
+	select {
+	case e := <-users:
+		assert.Equal(t, EventDeleteRange, e.Type)
+		assert.Equal(t, []byte("user/1"), e.Key)
+		assert.Equal(t, []byte("user/2"), e.End)
+	case <-time.After(time.Second):
+		t.Fatal("range delete event not received")
+	}
+	select {
+	case e := <-orders:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+}
+
+// TestPrefixEnd ensures prefixEnd returns the smallest key after every key with the prefix.
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff, 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
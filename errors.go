@@ -3,15 +3,38 @@ package bitcask_gown
 import "errors"
 
 var (
-	ErrKeyIsEmpty           = errors.New("key is empty")
-	ErrIndexUpdateFailed    = errors.New("index update failed")
-	ErrIndexNotFound        = errors.New("index not found")
-	ErrDataFileNotFound     = errors.New("data file not found")
-	ErrDirPathIsEmpty       = errors.New("directory path is empty")
-	ErrInvalidDataFileSize  = errors.New("invalid data file size, database file size must be greater than 0")
-	ErrKeyNotFound          = errors.New("key not found")
-	ErrIndexDeleteFailed    = errors.New("index delete failed")
-	ErrPendingWritesInvalid = errors.New("pending writes unvalid")
-	ErrExceedMaxBatchNum    = errors.New("exceed max batch num")
-	ErrActiveFileNotExist   = errors.New("active file not exist")
+	ErrKeyIsEmpty             = errors.New("key is empty")
+	ErrIndexUpdateFailed      = errors.New("index update failed")
+	ErrIndexNotFound          = errors.New("index not found")
+	ErrDataFileNotFound       = errors.New("data file not found")
+	ErrDirPathIsEmpty         = errors.New("directory path is empty")
+	ErrInvalidDataFileSize    = errors.New("invalid data file size, database file size must be greater than 0")
+	ErrKeyNotFound            = errors.New("key not found")
+	ErrIndexDeleteFailed      = errors.New("index delete failed")
+	ErrPendingWritesInvalid   = errors.New("pending writes unvalid")
+	ErrExceedMaxBatchNum      = errors.New("exceed max batch num")
+	ErrActiveFileNotExist     = errors.New("active file not exist")
+	ErrInvalidValueThreshold  = errors.New("invalid value threshold, it must not be negative")
+	ErrInvalidDiscardRatio    = errors.New("invalid discard ratio, it must be in (0, 1]")
+	ErrInvalidSyncInterval    = errors.New("invalid sync interval, it must not be negative")
+	ErrInvalidWriteBufferSize = errors.New("invalid write buffer size, it must not be negative")
+	ErrInvalidIOType          = errors.New("invalid io type")
+	ErrInvalidValueCacheSize  = errors.New("invalid value cache size, it must not be negative")
+	ErrReplicaReadOnly        = errors.New("database is a replica, it only accepts data from its leader")
+	ErrNotReplica             = errors.New("database is not opened in replica mode")
+	ErrReplicaDiverged        = errors.New("replica position does not match the leader's data files")
+	ErrReplicationUnsupported = errors.New("replication does not support key-value separation")
+	ErrInvalidFileSystem      = errors.New("invalid file system, InMemory and FileSystem cannot be set together")
+	ErrBackupDirNotEmpty      = errors.New("backup directory already contains data files")
+	ErrReadOnly               = errors.New("database is opened read-only")
+	ErrInvalidReadOnly        = errors.New("invalid read-only mode, it cannot be combined with Replica or InMemory")
+	ErrBucketNotFound         = errors.New("bucket not found")
+	ErrInvalidBucketName      = errors.New("invalid bucket name, it must not be empty")
+	ErrMergeOperatorNotSet    = errors.New("merge operator is not set")
+	ErrInvalidMergeOperand    = errors.New("invalid merge operand")
+	ErrInvalidRange           = errors.New("invalid range, start must be less than end")
+	ErrInvalidBulkLoader      = errors.New("bulk loader cannot prepare an in-memory or read-only database")
+	ErrBulkLoaderFinished     = errors.New("bulk loader is already finished")
+	ErrInvalidImportData      = errors.New("invalid import data")
+	ErrDatabaseClosed         = errors.New("database is closed")
 )
@@ -0,0 +1,120 @@
+package bitcask_gown
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// 导出：将默认 bucket 之中的全部 key（或者某个前缀下的 key）按照 key 的顺序写出，key 以及 value 都使用标准的 base64 编码，
+// 可以安全地保存二进制数据。支持两种格式：
+//   - JSON Lines：每行一个对象，例如 {"serial":1,"key":"azE=","value":"djE="}，serial 只在开启 Serial 时输出
+//   - CSV：第一行为表头 key,value（开启 Serial 时为 serial,key,value），之后每行一条数据
+// 导出期间的写入不会被阻塞，导出的结果不是某一时刻的快照：开始时存在、导出到它之前被删除的 key 会被跳过。
+
+// ExportOptions 导出的配置项
+type ExportOptions struct {
+	// 只导出以 Prefix 为前缀的 key，为空时导出全部的 key
+	Prefix []byte
+	// 是否为每条数据附带写入它的记录的序列号：通过 WriteBatch 写入的记录为事务的序列号，其他写入为 0
+	Serial bool
+}
+
+// exportRecord JSON Lines 之中的一行，[]byte 字段由 encoding/json 编码为标准的 base64
+type exportRecord struct {
+	Serial *uint64 `json:"serial,omitempty"`
+	Key    []byte  `json:"key"`
+	Value  []byte  `json:"value"`
+}
+
+// ExportJSONL 将数据以 JSON Lines 格式写入 w，返回导出的数据条数
+func (db *DB) ExportJSONL(w io.Writer, opts ExportOptions) (int, error) {
+	encoder := json.NewEncoder(w)
+	return db.export(opts, func(serial uint64, key, value []byte) error {
+		record := exportRecord{Key: key, Value: value}
+		if opts.Serial {
+			record.Serial = &serial
+		}
+		return encoder.Encode(record)
+	})
+}
+
+// ExportCSV 将数据以 CSV 格式写入 w，返回导出的数据条数
+func (db *DB) ExportCSV(w io.Writer, opts ExportOptions) (int, error) {
+	writer := csv.NewWriter(w)
+	header := []string{"key", "value"}
+	if opts.Serial {
+		header = append([]string{"serial"}, header...)
+	}
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	n, err := db.export(opts, func(serial uint64, key, value []byte) error {
+		row := []string{base64.StdEncoding.EncodeToString(key), base64.StdEncoding.EncodeToString(value)}
+		if opts.Serial {
+			row = append([]string{strconv.FormatUint(serial, 10)}, row...)
+		}
+		return writer.Write(row)
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	return n, err
+}
+
+// export 按照 key 的顺序遍历需要导出的数据，serial 在 opts.Serial 关闭时为 0
+func (db *DB) export(opts ExportOptions, write func(serial uint64, key, value []byte) error) (int, error) {
+	// 迭代器持有的是索引的副本，读取 value 时才加读锁，导出期间不会阻塞写入
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+
+	var n int
+	for iter.Seek(opts.Prefix); iter.Valid() && bytes.HasPrefix(iter.Key(), opts.Prefix); iter.Next() {
+		var value []byte
+		var serial uint64
+		var err error
+		if opts.Serial {
+			value, serial, err = db.getWithSeqNumber(iter.Key())
+		} else {
+			value, err = db.get("", iter.Key())
+		}
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := write(serial, iter.Key(), value); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// getWithSeqNumber 读取默认 bucket 之中 key 的 value，以及写入它的记录的序列号
+func (db *DB) getWithSeqNumber(key []byte) ([]byte, uint64, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	pos, ok := db.index.Get(key)
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	record, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, seqNumber := parseLogRecordKey(record.Key)
+	value, err := db.getValueByPos(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, seqNumber, nil
+}
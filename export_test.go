@@ -0,0 +1,123 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/utils"
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExportImport_RoundTrip ensures binary keys and values survive an export and import in both formats.
+func TestExportImport_RoundTrip(t *testing.T) {
+	src, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	want := map[string][]byte{
+		"\x00\xff,\n\"": {0x00, '\n', ',', 0xfe},
+		"empty-value":   {},
+	}
+	for i := 0; i < 50; i++ {
+		want[string(utils.GetTestKey(i))] = utils.RandomValue(16)
+	}
+	for key, value := range want {
+		require.NoError(t, src.Put([]byte(key), value))
+	}
+	require.NoError(t, src.Delete(utils.GetTestKey(0)))
+	delete(want, string(utils.GetTestKey(0)))
+
+	exports := map[string]func(*bytes.Buffer) (int, error){
+		"jsonl": func(buf *bytes.Buffer) (int, error) { return src.ExportJSONL(buf, ExportOptions{Serial: true}) },
+		"csv":   func(buf *bytes.Buffer) (int, error) { return src.ExportCSV(buf, ExportOptions{Serial: true}) },
+	}
+	for format, export := range exports {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := export(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, len(want), n)
+
+			dst, cleanup := newDB(t, DefaultOptions)
+			defer cleanup()
+			// 每批两个 key，数据被拆分为多个批次提交
+			setup := WriteBatchSetup{MaxBatchNum: 2}
+			if format == "jsonl" {
+				n, err = dst.ImportJSONL(&buf, setup)
+			} else {
+				n, err = dst.ImportCSV(&buf, setup)
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(want), n)
+
+			assert.Equal(t, len(want), dst.Stat().KeyNum)
+			for key, value := range want {
+				got, err := dst.Get([]byte(key))
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(value, got))
+			}
+		})
+	}
+}
+
+// TestExport_PrefixAndSerial ensures a prefix export is ordered, bounded, and carries record serial numbers only when asked.
+func TestExport_PrefixAndSerial(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	for _, key := range []string{"a", "user:2", "user:1", "user;", "z"} {
+		require.NoError(t, db.Put([]byte(key), []byte("v-"+key)))
+	}
+	// user:1 由事务覆盖写入，导出的序列号为事务的序列号；user:2 是普通写入，序列号为 0
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put([]byte("user:1"), []byte("v-user:1")))
+	require.NoError(t, wb.Commit())
+
+	var buf bytes.Buffer
+	n, err := db.ExportJSONL(&buf, ExportOptions{Prefix: []byte("user:")})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	enc := base64.StdEncoding.EncodeToString
+	assert.Equal(t,
+		`{"key":"`+enc([]byte("user:1"))+`","value":"`+enc([]byte("v-user:1"))+`"}`+"\n"+
+			`{"key":"`+enc([]byte("user:2"))+`","value":"`+enc([]byte("v-user:2"))+`"}`+"\n",
+		buf.String())
+
+	buf.Reset()
+	n, err = db.ExportCSV(&buf, ExportOptions{Prefix: []byte("user:"), Serial: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "serial,key,value\n1,"+enc([]byte("user:1"))+","+enc([]byte("v-user:1"))+"\n0,"+
+		enc([]byte("user:2"))+","+enc([]byte("v-user:2"))+"\n", buf.String())
+
+	buf.Reset()
+	n, err = db.ExportJSONL(&buf, ExportOptions{Prefix: []byte("user:2"), Serial: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, `{"serial":0,"key":"`+enc([]byte("user:2"))+`","value":"`+enc([]byte("v-user:2"))+`"}`+"\n", buf.String())
+}
+
+// TestImport_Invalid ensures malformed input is rejected while earlier batches stay committed.
+func TestImport_Invalid(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	input := `{"key":"azE=","value":"djE="}` + "\n" + `{"key":"azI=","value":"djI="}` + "\n" + `{"key":`
+	n, err := db.ImportJSONL(strings.NewReader(input), WriteBatchSetup{MaxBatchNum: 1})
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+	assert.Equal(t, 1, n)
+	got, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), got)
+
+	_, err = db.ImportCSV(strings.NewReader("k,v\nazE=,djE=\n"), DefaultWriteBatchSetup)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+	_, err = db.ImportCSV(strings.NewReader("key,value\n!!,djE=\n"), DefaultWriteBatchSetup)
+	assert.ErrorIs(t, err, ErrInvalidImportData)
+	_, err = db.ImportJSONL(strings.NewReader(`{"key":"","value":"djE="}`), DefaultWriteBatchSetup)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	_, err = db.ImportJSONL(strings.NewReader(input), WriteBatchSetup{})
+	assert.Equal(t, ErrExceedMaxBatchNum, err)
+}
@@ -0,0 +1,259 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/fio"
+	"bitcask-gown/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFaultyDB opens a DB whose files are all wrapped by a fault injector.
+func newFaultyDB(t *testing.T, setup Options) (*DB, *fio.FaultInjector) {
+	t.Helper()
+	injector := fio.NewFaultInjector()
+	setup.DirPath = t.TempDir()
+	setup.FileSystem = injector.WrapFileSystem(fio.DefaultFileSystem)
+	db, err := Open(setup)
+	require.NoError(t, err)
+	return db, injector
+}
+
+func reopenDB(t *testing.T, db *DB) *DB {
+	t.Helper()
+	_ = db.Close()
+	setup := db.option
+	setup.FileSystem = nil
+	db2, err := Open(setup)
+	require.NoError(t, err)
+	return db2
+}
+
+func indexSize(db *DB) int {
+	it := db.index.Iterator(false)
+	defer it.Close()
+	n := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
+
+// TestDB_FaultPutWriteError ensures failed and torn writes leave no trace and the DB stays usable.
+func TestDB_FaultPutWriteError(t *testing.T) {
+	for _, torn := range []bool{false, true} {
+		db, injector := newFaultyDB(t, DefaultOptions)
+		require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(16)))
+
+		injector.FailWritesAfter(10, torn)
+		assert.ErrorIs(t, db.Put(utils.GetTestKey(1), utils.RandomValue(16)), fio.ErrInjectedFault)
+		_, err := db.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		injector.Reset()
+		value := utils.RandomValue(16)
+		require.NoError(t, db.Put(utils.GetTestKey(2), value))
+
+		db = reopenDB(t, db)
+		got, err := db.Get(utils.GetTestKey(2))
+		require.NoError(t, err)
+		assert.Equal(t, value, got)
+		_, err = db.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		destroyDB(db)
+	}
+}
+
+// TestDB_FaultBatchCommit ensures a batch failing midway has no partial effect, before or after reopening.
+func TestDB_FaultBatchCommit(t *testing.T) {
+	db, injector := newFaultyDB(t, DefaultOptions)
+	defer func() { destroyDB(db) }()
+
+	wb := db.NewWriteBatch(WriteBatchSetup{MaxBatchNum: 10})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	injector.FailWritesAfter(100, true)
+	assert.ErrorIs(t, wb.Commit(), fio.ErrInjectedFault)
+	for i := 0; i < 10; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+
+	injector.Reset()
+	require.NoError(t, db.Put(utils.GetTestKey(100), utils.RandomValue(16)))
+	db = reopenDB(t, db)
+	assert.Equal(t, 1, indexSize(db))
+}
+
+// TestDB_FaultRollbackAcrossFiles ensures a group commit that rotated through several files is rolled back in all of them.
+func TestDB_FaultRollbackAcrossFiles(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 256
+	setup.SyncWrites = true
+	db, injector := newFaultyDB(t, setup)
+	defer func() { destroyDB(db) }()
+
+	require.NoError(t, db.Put(utils.GetTestKey(100), utils.RandomValue(16)))
+	group := make([]*commitRequest, 20)
+	for i := range group {
+		group[i] = &commitRequest{
+			record: &data.LogRecord{Key: addSeqToKey(utils.GetTestKey(i), nonTxnSeqNumber), Value: utils.RandomValue(64), Type: data.LogRecordNormal},
+			apply:  func(pos *data.LogRecordPos) error { return nil },
+		}
+	}
+	// 写入失败之前，这一组记录已经写满了多个文件
+	injector.FailWritesAfter(1200, true)
+	db.commitGroup(group)
+	assert.ErrorIs(t, group[0].err, fio.ErrInjectedFault)
+	assert.Greater(t, db.activeFile.FileID, uint32(2))
+
+	injector.Reset()
+	db = reopenDB(t, db)
+	assert.Equal(t, 1, indexSize(db))
+}
+
+// TestDB_FaultSyncError ensures a synced write whose fsync fails is rolled back.
+func TestDB_FaultSyncError(t *testing.T) {
+	setup := DefaultOptions
+	setup.SyncWrites = true
+	db, injector := newFaultyDB(t, setup)
+	defer func() { destroyDB(db) }()
+
+	injector.FailSync(true)
+	assert.ErrorIs(t, db.Put(utils.GetTestKey(0), utils.RandomValue(16)), fio.ErrInjectedFault)
+	_, err := db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	injector.Reset()
+	require.NoError(t, db.Put(utils.GetTestKey(1), utils.RandomValue(16)))
+	db = reopenDB(t, db)
+	assert.Equal(t, 1, indexSize(db))
+}
+
+// TestDB_FaultBitFlip ensures a corrupted record is reported instead of being served.
+func TestDB_FaultBitFlip(t *testing.T) {
+	db, injector := newFaultyDB(t, DefaultOptions)
+	defer func() { destroyDB(db) }()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(16)))
+	injector.FlipBitOnRead(8)
+	_, err := db.Get(utils.GetTestKey(0))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	injector.Reset()
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.NoError(t, err)
+}
+
+// TestDB_FaultShortRead ensures short reads surface as errors.
+func TestDB_FaultShortRead(t *testing.T) {
+	db, injector := newFaultyDB(t, DefaultOptions)
+	defer func() { destroyDB(db) }()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(16)))
+	injector.ShortReads(true)
+	_, err := db.Get(utils.GetTestKey(0))
+	assert.ErrorIs(t, err, fio.ErrInjectedFault)
+}
+
+// TestDB_FaultOldFileCorruption ensures Open fails cleanly when a sealed data file is corrupted.
+func TestDB_FaultOldFileCorruption(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = smallDataFileSize
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	require.NoError(t, db.Close())
+	require.NotEmpty(t, db.oldFiles)
+
+	injector := fio.NewFaultInjector()
+	injector.FlipBitOnRead(8)
+	setup.FileSystem = injector.WrapFileSystem(fio.DefaultFileSystem)
+	_, err = Open(setup)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+// TestDB_FaultTornTail ensures a torn record at the end of the active file is dropped on reopen.
+func TestDB_FaultTornTail(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer func() { cleanup() }()
+
+	value := utils.RandomValue(16)
+	require.NoError(t, db.Put(utils.GetTestKey(0), value))
+	require.NoError(t, db.Close())
+
+	fileName := data.GetDataFileName(db.option.DirPath, 0, data.DataFileNameSuffix)
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x08})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = Open(db.option)
+	require.NoError(t, err)
+	got, err := db.Get(utils.GetTestKey(0))
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	value2 := utils.RandomValue(16)
+	require.NoError(t, db.Put(utils.GetTestKey(1), value2))
+	db = reopenDB(t, db)
+	got, err = db.Get(utils.GetTestKey(1))
+	require.NoError(t, err)
+	assert.Equal(t, value2, got)
+}
+
+// TestDB_FaultMidFileCorruption ensures a corrupted record in the middle of the active file fails Open instead of being truncated away.
+func TestDB_FaultMidFileCorruption(t *testing.T) {
+	setup := DefaultOptions
+	setup.SyncWrites = true
+	db, cleanup := newDB(t, setup)
+	defer func() { cleanup() }()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	third, _ := db.index.Get(utils.GetTestKey(2))
+	last, _ := db.index.Get(utils.GetTestKey(9))
+	require.NoError(t, db.Close())
+
+	fileName := data.GetDataFileName(db.option.DirPath, 0, data.DataFileNameSuffix)
+	flipByte := func(offset int64) {
+		f, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+		require.NoError(t, err)
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, offset)
+		require.NoError(t, err)
+		b[0] ^= 0xff
+		_, err = f.WriteAt(b, offset)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	fileSize := func() int64 {
+		info, err := os.Stat(fileName)
+		require.NoError(t, err)
+		return info.Size()
+	}
+
+	// 中间的记录损坏，之后还有已经持久化的记录，不能截断
+	size := fileSize()
+	flipByte(third.Offset + third.Size - 1)
+	_, err := Open(db.option)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Equal(t, size, fileSize())
+
+	// 修复之后，最后一条记录损坏时只截断这一条
+	flipByte(third.Offset + third.Size - 1)
+	flipByte(last.Offset + last.Size - 1)
+	db, err = Open(db.option)
+	require.NoError(t, err)
+	assert.Equal(t, last.Offset, fileSize())
+	assert.Equal(t, 9, indexSize(db))
+}
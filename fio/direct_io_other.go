@@ -0,0 +1,17 @@
+//go:build !linux
+
+package fio
+
+import "errors"
+
+var ErrDirectIONotSupported = errors.New("direct io is not supported on this platform")
+
+// DirectIO 非 Linux 平台不支持 O_DIRECT
+type DirectIO struct {
+	IOManager
+}
+
+// NewDirectIOManager 非 Linux 平台不支持 O_DIRECT，直接返回错误
+func NewDirectIOManager(fileName string, preallocSize int64) (*DirectIO, error) {
+	return nil, ErrDirectIONotSupported
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate 为文件分配 size 大小的磁盘空间，并将文件大小扩展到 size
+func fallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	// 部分文件系统不支持 fallocate，此时退化为扩展文件大小
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return f.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// fallocate 非 Linux 平台没有 fallocate，退化为扩展文件大小，扩展的部分读取时为 0
+func fallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
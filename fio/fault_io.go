@@ -0,0 +1,173 @@
+package fio
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrInjectedFault = errors.New("injected io fault")
+
+// FaultInjector 故障注入器，用于测试数据库在 IO 出错时的行为。它通过 Wrap 包装任意的 IOManager，
+// 或者通过 WrapFileSystem 包装文件系统打开的所有文件；所有被包装的 IOManager 共享同一份故障配置，
+// 配置可以在运行过程中随时修改。
+type FaultInjector struct {
+	lock *sync.Mutex
+
+	writeLimit int64 // 累计写入超过该字节数之后，写入返回错误；小于 0 时不限制
+	written    int64 // 自设置 writeLimit 以来累计写入的字节数
+	tornWrite  bool  // 超出限制的那一次写入，是否先写入限制之内的部分（撕裂写）
+	failSync   bool  // Sync 是否返回错误
+	shortRead  bool  // Read 是否只读取一半的数据并返回错误
+	flipBitAt  int64 // 读取覆盖该文件偏移时，将该字节的最低位翻转；小于 0 时不翻转
+}
+
+// NewFaultInjector 创建一个不注入任何故障的故障注入器
+func NewFaultInjector() *FaultInjector {
+	fi := &FaultInjector{lock: new(sync.Mutex)}
+	fi.Reset()
+	return fi
+}
+
+// Reset 清除所有的故障配置
+func (fi *FaultInjector) Reset() {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+
+	fi.writeLimit, fi.written = -1, 0
+	fi.tornWrite, fi.failSync, fi.shortRead = false, false, false
+	fi.flipBitAt = -1
+}
+
+// FailWritesAfter 从现在开始累计写入 n 个字节之后，写入返回 ErrInjectedFault。torn 为 true 时，
+// 超出限制的那一次写入会先写入限制之内的部分，模拟写入过程中崩溃留下的不完整数据。
+func (fi *FaultInjector) FailWritesAfter(n int64, torn bool) {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+
+	fi.writeLimit, fi.written, fi.tornWrite = n, 0, torn
+}
+
+// FailSync 设置 Sync 是否返回 ErrInjectedFault
+func (fi *FaultInjector) FailSync(fail bool) {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+
+	fi.failSync = fail
+}
+
+// ShortReads 设置 Read 是否只返回一半的数据以及 ErrInjectedFault
+func (fi *FaultInjector) ShortReads(short bool) {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+
+	fi.shortRead = short
+}
+
+// FlipBitOnRead 读取覆盖文件偏移 offset 时，翻转该字节的最低位；offset 小于 0 时关闭
+func (fi *FaultInjector) FlipBitOnRead(offset int64) {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+
+	fi.flipBitAt = offset
+}
+
+// Wrap 使用当前的故障注入器包装 ioManager
+func (fi *FaultInjector) Wrap(ioManager IOManager) IOManager {
+	return &FaultyIO{inner: ioManager, injector: fi}
+}
+
+// WrapFileSystem 包装文件系统，使其打开的每一个文件都使用当前的故障注入器包装
+func (fi *FaultInjector) WrapFileSystem(fileSystem FileSystem) FileSystem {
+	return &faultyFileSystem{FileSystem: fileSystem, injector: fi}
+}
+
+type faultyFileSystem struct {
+	FileSystem
+	injector *FaultInjector
+}
+
+func (f *faultyFileSystem) OpenFile(name string, ioType FileIOType, preallocSize int64) (IOManager, error) {
+	ioManager, err := f.FileSystem.OpenFile(name, ioType, preallocSize)
+	if err != nil {
+		return nil, err
+	}
+	return f.injector.Wrap(ioManager), nil
+}
+
+// FaultyIO 按照 FaultInjector 的配置注入故障的 IOManager
+type FaultyIO struct {
+	inner    IOManager
+	injector *FaultInjector
+}
+
+func (f *FaultyIO) Read(buf []byte, offset int64) (int, error) {
+	fi := f.injector
+	fi.lock.Lock()
+	shortRead, flipBitAt := fi.shortRead, fi.flipBitAt
+	fi.lock.Unlock()
+
+	if shortRead && len(buf) > 1 {
+		n, _ := f.inner.Read(buf[:len(buf)/2], offset)
+		return n, ErrInjectedFault
+	}
+
+	n, err := f.inner.Read(buf, offset)
+	if flipBitAt >= offset && flipBitAt < offset+int64(n) {
+		buf[flipBitAt-offset] ^= 1
+	}
+	return n, err
+}
+
+func (f *FaultyIO) Write(buf []byte) (int, error) {
+	fi := f.injector
+	fi.lock.Lock()
+	allowed := int64(len(buf))
+	if fi.writeLimit >= 0 && fi.written+allowed > fi.writeLimit {
+		allowed = fi.writeLimit - fi.written
+		if !fi.tornWrite || allowed < 0 {
+			allowed = 0
+		}
+	}
+	fi.written += allowed
+	fi.lock.Unlock()
+
+	if allowed == int64(len(buf)) {
+		return f.inner.Write(buf)
+	}
+	if allowed == 0 {
+		return 0, ErrInjectedFault
+	}
+	n, _ := f.inner.Write(buf[:allowed])
+	return n, ErrInjectedFault
+}
+
+func (f *FaultyIO) Sync() error {
+	fi := f.injector
+	fi.lock.Lock()
+	failSync := fi.failSync
+	fi.lock.Unlock()
+
+	if failSync {
+		return ErrInjectedFault
+	}
+	return f.inner.Sync()
+}
+
+func (f *FaultyIO) Close() error {
+	return f.inner.Close()
+}
+
+func (f *FaultyIO) Size() (int64, error) {
+	return f.inner.Size()
+}
+
+func (f *FaultyIO) Truncate(size int64) error {
+	return f.inner.Truncate(size)
+}
+
+// SetWriteOffset 转发给被包装的 IOManager
+func (f *FaultyIO) SetWriteOffset(offset int64) {
+	if setter, ok := f.inner.(interface{ SetWriteOffset(int64) }); ok {
+		setter.SetWriteOffset(offset)
+	}
+}
@@ -1,24 +1,71 @@
 package fio
 
-import "os"
+import (
+	"errors"
+	"os"
+)
+
+// ErrReadOnlyFile 向以只读方式打开的文件写入
+var ErrReadOnlyFile = errors.New("file is opened read-only")
 
 // FileIO 创建负责文件输入输出的结构体
 type FileIO struct {
 	f *os.File
+	// writeOff 下一次写入的位置。预分配之后文件的物理大小不再代表数据的末尾，因此不能使用 os.O_APPEND，
+	// 而是自己记录写入位置，通过 WriteAt 写入。
+	writeOff int64
+	readOnly bool
 }
 
 // NewFileIOManager 创建新的文件IO管理器
 func NewFileIOManager(fileName string) (*FileIO, error) {
 	f, err := os.OpenFile(
 		fileName,
-		os.O_CREATE|os.O_RDWR|os.O_APPEND, // os.O_APPEND 尤其重要，因为我们是采用的追加写入的方式！
+		os.O_CREATE|os.O_RDWR,
 		0644,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &FileIO{f: f}, nil
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileIO{f: f, writeOff: stat.Size()}, nil
+}
+
+// NewReadOnlyFileIOManager 以只读方式打开已经存在的文件，文件不存在时返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileIO{f: f, writeOff: stat.Size(), readOnly: true}, nil
+}
+
+// NewPreallocFileIOManager 创建新的文件IO管理器，如果文件小于 size，则将其预分配到 size 大小，预分配的部分全部为 0。
+// 写入位置仍然从预分配之前的文件末尾开始。
+func NewPreallocFileIOManager(fileName string, size int64) (*FileIO, error) {
+	fio, err := NewFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if fio.writeOff < size {
+		if err := fallocate(fio.f, size); err != nil {
+			_ = fio.f.Close()
+			return nil, err
+		}
+	}
+	return fio, nil
 }
 
 // Read 从文件的 offset 处读取数据到 b 中
@@ -26,11 +73,20 @@ func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.f.ReadAt(b, offset)
 }
 
+// Write 在当前写入位置追加写入 b
 func (fio *FileIO) Write(b []byte) (int, error) {
-	return fio.f.Write(b)
+	if fio.readOnly {
+		return 0, ErrReadOnlyFile
+	}
+	n, err := fio.f.WriteAt(b, fio.writeOff)
+	fio.writeOff += int64(n)
+	return n, err
 }
 
 func (fio *FileIO) Sync() error {
+	if fio.readOnly {
+		return nil
+	}
 	return fio.f.Sync()
 }
 
@@ -38,10 +94,24 @@ func (fio *FileIO) Close() error {
 	return fio.f.Close()
 }
 
+// Size 返回数据的逻辑大小，即下一次写入的位置；对于预分配的文件，它可能小于文件的物理大小
 func (fio *FileIO) Size() (int64, error) {
-	stat, err := fio.f.Stat()
-	if err != nil {
-		return 0, err
+	return fio.writeOff, nil
+}
+
+// SetWriteOffset 设置下一次写入的位置。重新打开预分配的文件时，数据的末尾需要由上层扫描之后告知
+func (fio *FileIO) SetWriteOffset(offset int64) {
+	fio.writeOff = offset
+}
+
+// Truncate 将文件截断到 size 大小，写入位置同时移动到 size
+func (fio *FileIO) Truncate(size int64) error {
+	if fio.readOnly {
+		return ErrReadOnlyFile
+	}
+	if err := fio.f.Truncate(size); err != nil {
+		return err
 	}
-	return stat.Size(), nil
+	fio.writeOff = size
+	return nil
 }
@@ -0,0 +1,61 @@
+package fio
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FileSystem 可写的文件系统接口，数据库对文件以及目录的所有操作都通过它完成。
+// 通过实现该接口，可以将数据库放在内存、叠加文件系统或者测试用的文件系统之上。
+type FileSystem interface {
+	// OpenFile 打开文件，如果不存在则创建；ioType 以及 preallocSize 的含义与 NewIOManager 相同，不支持的实现可以忽略
+	OpenFile(name string, ioType FileIOType, preallocSize int64) (IOManager, error)
+	// ReadDir 列出 dirPath 目录下所有条目的名称（不包含目录部分）
+	ReadDir(dirPath string) ([]string, error)
+	// MkdirAll 创建目录以及所有不存在的上级目录
+	MkdirAll(dirPath string) error
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+	// Rename 将文件 oldName 重命名为 newName，newName 已经存在时将其替换
+	Rename(oldName, newName string) error
+	// Join 将多个路径元素拼接成一个路径
+	Join(elem ...string) string
+}
+
+// OSFileSystem 基于操作系统的文件系统
+type OSFileSystem struct{}
+
+// DefaultFileSystem 未指定文件系统时使用的默认文件系统
+var DefaultFileSystem FileSystem = OSFileSystem{}
+
+func (OSFileSystem) OpenFile(name string, ioType FileIOType, preallocSize int64) (IOManager, error) {
+	return NewIOManager(name, ioType, preallocSize)
+}
+
+func (OSFileSystem) ReadDir(dirPath string) ([]string, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		names = append(names, dirEntry.Name())
+	}
+	return names, nil
+}
+
+func (OSFileSystem) MkdirAll(dirPath string) error {
+	return os.MkdirAll(dirPath, os.ModePerm)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
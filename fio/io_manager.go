@@ -1,5 +1,17 @@
 package fio
 
+// FileIOType 文件 IO 的类型
+type FileIOType = byte
+
+const (
+	// StandardFIO 标准文件 IO，读写经过操作系统的 page cache
+	StandardFIO FileIOType = iota
+	// DirectFIO 使用 O_DIRECT 打开文件，读写绕过 page cache
+	DirectFIO
+	// ReadOnlyFIO 以只读方式打开已经存在的文件，写入返回 ErrReadOnlyFile，可以用于只读挂载的文件系统
+	ReadOnlyFIO
+)
+
 // IOManager 通过实现下面四种方法，表现像一个 IOManager。此外，就是可让其他（除了文件IO）实现了这些方式也可以作为 IOManager
 type IOManager interface {
 	Read(buf []byte, offset int64) (int, error)
@@ -9,4 +21,21 @@ type IOManager interface {
 	// Close 关闭文件句柄。
 	Close() error
 	Size() (int64, error)
+	// Truncate 将文件截断到 size 大小，之后的写入从 size 处开始；用于丢弃中断的写入残留的数据
+	Truncate(size int64) error
+}
+
+// NewIOManager 根据 ioType 创建对应的 IOManager，preallocSize 大于 0 时会将文件预分配到该大小
+func NewIOManager(fileName string, ioType FileIOType, preallocSize int64) (IOManager, error) {
+	switch ioType {
+	case DirectFIO:
+		return NewDirectIOManager(fileName, preallocSize)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
+	default:
+		if preallocSize > 0 {
+			return NewPreallocFileIOManager(fileName, preallocSize)
+		}
+		return NewFileIOManager(fileName)
+	}
 }
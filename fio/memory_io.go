@@ -0,0 +1,156 @@
+package fio
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemStore 纯内存的文件系统，以文件名为 key。它替代磁盘上的目录，使数据库可以完全运行在内存之中，
+// 适用于测试以及临时数据库；不同的 MemStore 之间互不影响。目录是隐式存在的，无需创建。
+type MemStore struct {
+	lock  *sync.RWMutex
+	files map[string]*memFile
+}
+
+// memFile 内存文件的内容，可能同时被多个 MemoryIO 打开
+type memFile struct {
+	lock *sync.RWMutex
+	data []byte
+}
+
+// NewMemStore 创建一个空的内存文件集合
+func NewMemStore() *MemStore {
+	return &MemStore{
+		lock:  new(sync.RWMutex),
+		files: make(map[string]*memFile),
+	}
+}
+
+// OpenFile 打开内存文件，如果不存在则创建；内存文件不区分 IO 类型，也不需要预分配
+func (ms *MemStore) OpenFile(fileName string, _ FileIOType, _ int64) (IOManager, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	fileName = filepath.Clean(fileName)
+	file, ok := ms.files[fileName]
+	if !ok {
+		file = &memFile{lock: new(sync.RWMutex)}
+		ms.files[fileName] = file
+	}
+	return &MemoryIO{file: file}, nil
+}
+
+// ReadDir 列出 dirPath 目录下所有文件的文件名（不包含目录部分），按照字典序排序
+func (ms *MemStore) ReadDir(dirPath string) ([]string, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	dirPath = filepath.Clean(dirPath)
+	var names []string
+	for fileName := range ms.files {
+		if filepath.Dir(fileName) == dirPath {
+			names = append(names, filepath.Base(fileName))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// MkdirAll 内存文件系统的目录是隐式存在的，无需创建
+func (ms *MemStore) MkdirAll(string) error {
+	return nil
+}
+
+// Remove 删除内存文件，已经打开的 MemoryIO 仍然可以继续读取
+func (ms *MemStore) Remove(fileName string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	fileName = filepath.Clean(fileName)
+	if _, ok := ms.files[fileName]; !ok {
+		return &fs.PathError{Op: "remove", Path: fileName, Err: fs.ErrNotExist}
+	}
+	delete(ms.files, fileName)
+	return nil
+}
+
+// Rename 重命名内存文件，已经打开的 MemoryIO 仍然读写同一份内容
+func (ms *MemStore) Rename(oldName, newName string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	file, ok := ms.files[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(ms.files, oldName)
+	ms.files[newName] = file
+	return nil
+}
+
+func (ms *MemStore) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// MemoryIO 基于内存文件的 IOManager
+type MemoryIO struct {
+	file *memFile
+}
+
+// Read 从内存文件的 offset 处读取数据到 b 中
+func (mio *MemoryIO) Read(b []byte, offset int64) (int, error) {
+	mio.file.lock.RLock()
+	defer mio.file.lock.RUnlock()
+
+	if offset >= int64(len(mio.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mio.file.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 在内存文件末尾追加写入 b
+func (mio *MemoryIO) Write(b []byte) (int, error) {
+	mio.file.lock.Lock()
+	defer mio.file.lock.Unlock()
+
+	mio.file.data = append(mio.file.data, b...)
+	return len(b), nil
+}
+
+// Sync 内存文件无需持久化
+func (mio *MemoryIO) Sync() error {
+	return nil
+}
+
+// Close 内存文件的内容由 MemStore 持有，关闭时无需释放
+func (mio *MemoryIO) Close() error {
+	return nil
+}
+
+func (mio *MemoryIO) Size() (int64, error) {
+	mio.file.lock.RLock()
+	defer mio.file.lock.RUnlock()
+
+	return int64(len(mio.file.data)), nil
+}
+
+// Truncate 将内存文件截断到 size 大小
+func (mio *MemoryIO) Truncate(size int64) error {
+	mio.file.lock.Lock()
+	defer mio.file.lock.Unlock()
+
+	if size < int64(len(mio.file.data)) {
+		mio.file.data = mio.file.data[:size]
+	} else {
+		mio.file.data = append(mio.file.data, make([]byte, size-int64(len(mio.file.data)))...)
+	}
+	return nil
+}
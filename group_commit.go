@@ -0,0 +1,142 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"sync"
+)
+
+// 组提交：开启 SyncWrites 时，每一次写入都需要 fsync，吞吐量会受限于磁盘的 fsync 速率。
+// 并发的写入者先把记录放入队列，排在队首的写入者成为 leader，把当前队列之中所有的记录编码后通过一次 Write 写入，
+// 并且只执行一次 fsync，随后按照队列顺序更新索引，最后唤醒所有等待者。每一次调用返回时，其记录都已经持久化。
+
+// commitRequest 一次等待组提交的写入
+type commitRequest struct {
+	record   *data.LogRecord
+	apply    func(pos *data.LogRecordPos) error // 记录持久化之后，用于更新索引
+	err      error
+	finished bool
+}
+
+// groupCommitter 组提交的等待队列
+type groupCommitter struct {
+	mu    *sync.Mutex
+	cond  *sync.Cond
+	queue []*commitRequest
+}
+
+func newGroupCommitter() *groupCommitter {
+	mu := new(sync.Mutex)
+	return &groupCommitter{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+	}
+}
+
+// groupCommit 将 record 加入组提交队列，等待其被写入并持久化之后，返回写入的结果
+func (db *DB) groupCommit(record *data.LogRecord, apply func(pos *data.LogRecordPos) error) error {
+	gc := db.committer
+	req := &commitRequest{record: record, apply: apply}
+
+	gc.mu.Lock()
+	gc.queue = append(gc.queue, req)
+	// 等待，直到自己的写入被其他 leader 完成，或者自己排到了队首成为 leader
+	for !req.finished && gc.queue[0] != req {
+		gc.cond.Wait()
+	}
+	if req.finished {
+		gc.mu.Unlock()
+		return req.err
+	}
+
+	// 成为 leader，带走当前队列之中的所有请求；在写入期间到达的请求组成下一组
+	group := gc.queue
+	gc.mu.Unlock()
+
+	db.commitGroup(group)
+
+	gc.mu.Lock()
+	for _, r := range group {
+		r.finished = true
+	}
+	gc.queue = gc.queue[len(group):]
+	gc.cond.Broadcast()
+	gc.mu.Unlock()
+
+	return req.err
+}
+
+// commitGroup 写入一组请求并执行一次持久化，错误会设置到每一个受影响的请求之中
+func (db *DB) commitGroup(group []*commitRequest) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	fid, offset := db.activeFilePos()
+	positions, err := db.writeGroup(group)
+	if err == nil {
+		err = db.syncActiveFiles()
+	}
+	if err != nil {
+		db.rollbackActiveFile(fid, offset)
+		for _, req := range group {
+			req.err = err
+		}
+		return
+	}
+
+	// 按照队列顺序更新索引，保证同一个 key 的多次写入最终指向最新的记录
+	for i, req := range group {
+		req.err = req.apply(positions[i])
+	}
+}
+
+// writeGroup 将一组记录编码到同一个缓冲区，通过一次 Write 写入活跃文件；只有当这组记录需要切换活跃文件时才会拆分为多次写入
+func (db *DB) writeGroup(group []*commitRequest) ([]*data.LogRecordPos, error) {
+	if db.syncErr != nil {
+		return nil, db.syncErr
+	}
+
+	positions := make([]*data.LogRecordPos, len(group))
+	var buf []byte
+
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		err := db.activeFile.Write(buf)
+		if err == nil {
+			db.addBytesWritten(int64(len(buf)))
+		}
+		buf = buf[:0]
+		return err
+	}
+
+	for i, req := range group {
+		record, err := db.separateValue(req.record)
+		if err != nil {
+			return nil, err
+		}
+		encRecord, size := data.EncodeLogRecord(record)
+
+		// 缓冲区加上这条记录会超过文件大小限制，先写入已有的部分再切换活跃文件
+		if db.activeFile == nil || db.activeFile.WriteOff+int64(len(buf))+size > db.option.DataFileSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if err := db.rotateActiveFile(size); err != nil {
+				return nil, err
+			}
+		}
+
+		positions[i] = &data.LogRecordPos{
+			Fid:    db.activeFile.FileID,
+			Offset: db.activeFile.WriteOff + int64(len(buf)),
+			Size:   size,
+		}
+		buf = append(buf, encRecord...)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
@@ -0,0 +1,115 @@
+package bitcask_gown
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 导入：读取 ExportJSONL 或者 ExportCSV 写出的数据并写入默认 bucket，serial 字段会被忽略。
+// 数据通过 WriteBatch 分批提交，每一批至多 setup.MaxBatchNum 个不同的 key；出错时之前已经提交的批次不会回滚。
+
+// ImportJSONL 从 r 之中读取 JSON Lines 格式的数据并写入，返回导入的数据条数
+func (db *DB) ImportJSONL(r io.Reader, setup WriteBatchSetup) (int, error) {
+	decoder := json.NewDecoder(r)
+	var line int
+	return db.importRecords(setup, func() ([]byte, []byte, error) {
+		var record exportRecord
+		if err := decoder.Decode(&record); err != nil {
+			if err == io.EOF {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("%w: record %d: %v", ErrInvalidImportData, line+1, err)
+		}
+		line++
+		return record.Key, record.Value, nil
+	})
+}
+
+// ImportCSV 从 r 之中读取 CSV 格式的数据并写入，第一行必须是表头，返回导入的数据条数
+func (db *DB) ImportCSV(r io.Reader, setup WriteBatchSetup) (int, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+	}
+	keyCol, valueCol := -1, -1
+	for i, name := range header {
+		switch name {
+		case "key":
+			keyCol = i
+		case "value":
+			valueCol = i
+		}
+	}
+	if keyCol < 0 || valueCol < 0 {
+		return 0, fmt.Errorf("%w: csv header must contain key and value columns", ErrInvalidImportData)
+	}
+
+	return db.importRecords(setup, func() ([]byte, []byte, error) {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImportData, err)
+		}
+		line, _ := reader.FieldPos(0)
+		key, err := base64.StdEncoding.DecodeString(row[keyCol])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: key: %v", ErrInvalidImportData, line, err)
+		}
+		value, err := base64.StdEncoding.DecodeString(row[valueCol])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: value: %v", ErrInvalidImportData, line, err)
+		}
+		return key, value, nil
+	})
+}
+
+// importRecords 不断调用 next 读取数据直到 io.EOF，写满 setup.MaxBatchNum 个 key 时提交一个批次
+func (db *DB) importRecords(setup WriteBatchSetup, next func() ([]byte, []byte, error)) (int, error) {
+	if setup.MaxBatchNum == 0 {
+		return 0, ErrExceedMaxBatchNum
+	}
+
+	var committed int
+	wb := db.NewWriteBatch(setup)
+	pending := 0
+	for {
+		key, value, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return committed, err
+		}
+
+		// 同一个 key 在一个批次之中只占一个位置，因此以 pendingWrites 的大小判断批次是否已满
+		if _, exist := wb.pendingWrites[pendingKey("", key)]; !exist && len(wb.pendingWrites) == int(setup.MaxBatchNum) {
+			if err := wb.Commit(); err != nil {
+				return committed, err
+			}
+			committed += pending
+			wb, pending = db.NewWriteBatch(setup), 0
+		}
+		if err := wb.Put(key, value); err != nil {
+			return committed, err
+		}
+		pending++
+	}
+
+	if pending > 0 {
+		if err := wb.Commit(); err != nil {
+			return committed, err
+		}
+		committed += pending
+	}
+	return committed, nil
+}
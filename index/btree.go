@@ -43,6 +43,30 @@ func (b *BTree) Delete(key []byte) bool {
 	return false
 }
 
+// DeleteRange 在一次加锁之中删除 [start, end) 范围内的所有 Item，end 为 nil 时删除 start 之后的全部 Item
+func (b *BTree) DeleteRange(start, end []byte) []*data.LogRecordPos {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var items []*Item
+	collect := func(it btree.Item) bool {
+		items = append(items, it.(*Item))
+		return true
+	}
+	if end == nil {
+		b.tree.AscendGreaterOrEqual(&Item{key: start}, collect)
+	} else {
+		b.tree.AscendRange(&Item{key: start}, &Item{key: end}, collect)
+	}
+
+	positions := make([]*data.LogRecordPos, len(items))
+	for i, it := range items {
+		b.tree.Delete(it)
+		positions[i] = it.pos
+	}
+	return positions
+}
+
 // Get 从索引中获取 key 对应的 Item，如果获取成功返回对应的记录和 true，反之为 nil，false。
 func (b *BTree) Get(key []byte) (*data.LogRecordPos, bool) {
 	b.lock.RLock()
@@ -55,6 +79,14 @@ func (b *BTree) Get(key []byte) (*data.LogRecordPos, bool) {
 	return it.(*Item).pos, true
 }
 
+// Size 返回索引之中 key 的数量
+func (b *BTree) Size() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.tree.Len()
+}
+
 // Item 我们向 btree 之中就是添加 Item
 type Item struct {
 	key []byte
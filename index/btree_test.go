@@ -11,17 +11,17 @@ import (
 func TestBTree_Put(t *testing.T) {
 	bt := NewBTree()
 
-	res1 := bt.Put(nil, &data.LogRecordPos{1, 100})
+	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 	assert.True(t, res1)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{1, 2})
+	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	assert.True(t, res2)
 }
 
 func TestBTree_Get(t *testing.T) {
 	bt := NewBTree()
 
-	bt.Put(nil, &data.LogRecordPos{1, 100})
+	bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 
 	// 测试获取key=nil对应值的情况
 	pos1, ok := bt.Get(nil) // pos1 类型是 *data.LogRecordPos
@@ -30,7 +30,7 @@ func TestBTree_Get(t *testing.T) {
 	assert.Equal(t, int64(100), pos1.Offset)
 
 	// 测试获取key="a"对应值的情况
-	bt.Put([]byte("a"), &data.LogRecordPos{2, 2})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 2})
 
 	pos2, ok := bt.Get([]byte("a")) // []byte类型总感觉怪...
 	assert.True(t, ok)
@@ -38,7 +38,7 @@ func TestBTree_Get(t *testing.T) {
 	assert.Equal(t, int64(2), pos2.Offset)
 
 	// 连续两次Put函数添加，会改变key对应的value，测试value是否如期改变
-	bt.Put([]byte("a"), &data.LogRecordPos{1, 3})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
 	pos3, ok := bt.Get([]byte("a"))
 	assert.True(t, ok)
 	assert.Equal(t, uint32(1), pos3.Fid)
@@ -48,11 +48,45 @@ func TestBTree_Get(t *testing.T) {
 func TestBTree_Delete(t *testing.T) {
 	bt := NewBTree()
 
-	bt.Put(nil, &data.LogRecordPos{1, 100})
+	bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
 	res1 := bt.Delete(nil)
 	assert.True(t, res1)
 
-	bt.Put([]byte("a"), &data.LogRecordPos{2, 111})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 111})
 	res2 := bt.Delete([]byte("a"))
 	assert.True(t, res2)
 }
+
+func TestBTree_DeleteRange(t *testing.T) {
+	bt := NewBTree()
+	for i, key := range []string{"a", "b", "ba", "bz", "c", "d"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	removed := bt.DeleteRange([]byte("b"), []byte("c"))
+	assert.Len(t, removed, 3)
+	assert.Equal(t, int64(1), removed[0].Offset)
+	assert.Equal(t, 3, bt.Size())
+	_, ok := bt.Get([]byte("ba"))
+	assert.False(t, ok)
+	_, ok = bt.Get([]byte("c"))
+	assert.True(t, ok)
+
+	// end 为 nil 时没有上界
+	assert.Len(t, bt.DeleteRange([]byte("c"), nil), 2)
+	assert.Empty(t, bt.DeleteRange([]byte("x"), nil))
+	assert.Equal(t, 1, bt.Size())
+}
+
+func TestBTree_Size(t *testing.T) {
+	bt := NewBTree()
+	assert.Equal(t, 0, bt.Size())
+
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, 2, bt.Size())
+
+	bt.Delete([]byte("a"))
+	assert.Equal(t, 1, bt.Size())
+}
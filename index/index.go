@@ -12,6 +12,10 @@ type Indexer interface {
 	Delete(key []byte) bool
 	// Get 根据 key，从索引中，取出对应位置信息
 	Get(key []byte) (*data.LogRecordPos, bool)
+	// DeleteRange 删除 [start, end) 范围内的所有 key，end 为 nil 时没有上界，返回被删除的位置信息
+	DeleteRange(start, end []byte) []*data.LogRecordPos
+	// Size 索引之中 key 的数量
+	Size() int
 	Iterator(reverse bool) Iterator
 }
 
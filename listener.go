@@ -0,0 +1,84 @@
+package bitcask_gown
+
+import "time"
+
+// Listener 数据库内部事件的监听器，通过 Options.Listener 设置。
+// 回调在触发事件的协程之中同步执行，其中大部分执行时持有 db.lock，因此回调之中不能调用 DB 的方法，并且应当尽快返回。
+// 嵌入 NopListener 之后只需要实现关心的回调。
+type Listener interface {
+	// OnOpen Open 结束时调用，打开失败时 Err 不为 nil
+	OnOpen(info OpenInfo)
+	// OnClose Close 结束时调用
+	OnClose(info CloseInfo)
+	// OnFileRotate 活跃文件写满，切换到新的活跃文件之后调用
+	OnFileRotate(info FileRotateInfo)
+	// OnSync 活跃文件持久化之后调用，包括 Sync、SyncWrites、组提交以及后台持久化
+	OnSync(info SyncInfo)
+	// OnRecoveryProgress Open 时每加载完一个数据文件的索引调用一次
+	OnRecoveryProgress(info RecoveryInfo)
+	// OnBatchCommit WriteBatch.Commit 写入数据文件之后调用，提交失败时 Err 不为 nil
+	OnBatchCommit(info BatchCommitInfo)
+	// OnCorruption 读到校验失败或者不完整的记录时调用
+	OnCorruption(info CorruptionInfo)
+}
+
+// OpenInfo 打开数据库的信息
+type OpenInfo struct {
+	DirPath  string
+	Duration time.Duration // 打开数据库（包括加载索引）的耗时
+	Err      error
+}
+
+// CloseInfo 关闭数据库的信息
+type CloseInfo struct {
+	DirPath string
+	Err     error
+}
+
+// FileRotateInfo 切换活跃文件的信息
+type FileRotateInfo struct {
+	OldFileID uint32 // 写满之后转为旧文件的数据文件
+	NewFileID uint32 // 新的活跃文件
+}
+
+// SyncInfo 持久化的信息
+type SyncInfo struct {
+	FileID   uint32 // 被持久化的活跃文件
+	Duration time.Duration
+	Err      error
+}
+
+// RecoveryInfo 加载索引的进度
+type RecoveryInfo struct {
+	FileID     uint32        // 刚刚加载完成的数据文件
+	FilesDone  int           // 已经加载完成的数据文件数量
+	FilesTotal int           // 需要加载的数据文件总数
+	Records    int           // 该文件之中读取的记录数量
+	Duration   time.Duration // 加载该文件的耗时
+}
+
+// BatchCommitInfo 批量写入提交的信息
+type BatchCommitInfo struct {
+	SeqNumber uint64 // 事务序列号，在写入之前就失败的提交为 0
+	Records   int    // 提交的记录数量
+	Err       error
+}
+
+// CorruptionInfo 损坏记录的信息
+type CorruptionInfo struct {
+	FileID    uint32
+	Offset    int64
+	Err       error
+	Truncated bool // 是否作为活跃文件末尾中断的写入被截断丢弃
+}
+
+// NopListener 所有回调都为空的 Listener，可以嵌入到自定义的 Listener 之中
+type NopListener struct{}
+
+func (NopListener) OnOpen(OpenInfo)                 {}
+func (NopListener) OnClose(CloseInfo)               {}
+func (NopListener) OnFileRotate(FileRotateInfo)     {}
+func (NopListener) OnSync(SyncInfo)                 {}
+func (NopListener) OnRecoveryProgress(RecoveryInfo) {}
+func (NopListener) OnBatchCommit(BatchCommitInfo)   {}
+func (NopListener) OnCorruption(CorruptionInfo)     {}
@@ -0,0 +1,122 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/utils"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingListener collects every event it receives.
+type recordingListener struct {
+	NopListener
+	mu          sync.Mutex
+	opens       []OpenInfo
+	closes      []CloseInfo
+	rotations   []FileRotateInfo
+	syncs       []SyncInfo
+	recoveries  []RecoveryInfo
+	commits     []BatchCommitInfo
+	corruptions []CorruptionInfo
+}
+
+func (l *recordingListener) OnOpen(info OpenInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opens = append(l.opens, info)
+}
+
+func (l *recordingListener) OnClose(info CloseInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closes = append(l.closes, info)
+}
+
+func (l *recordingListener) OnFileRotate(info FileRotateInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotations = append(l.rotations, info)
+}
+
+func (l *recordingListener) OnSync(info SyncInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncs = append(l.syncs, info)
+}
+
+func (l *recordingListener) OnRecoveryProgress(info RecoveryInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recoveries = append(l.recoveries, info)
+}
+
+func (l *recordingListener) OnBatchCommit(info BatchCommitInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commits = append(l.commits, info)
+}
+
+func (l *recordingListener) OnCorruption(info CorruptionInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.corruptions = append(l.corruptions, info)
+}
+
+// TestDB_Listener ensures lifecycle, write path and recovery events reach the listener.
+func TestDB_Listener(t *testing.T) {
+	listener := &recordingListener{}
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 256
+	setup.Listener = listener
+
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.Len(t, listener.opens, 1)
+	assert.NoError(t, listener.opens[0].Err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	require.NotEmpty(t, listener.rotations)
+	assert.Equal(t, FileRotateInfo{OldFileID: 0, NewFileID: 1}, listener.rotations[0])
+	// 切换活跃文件时会持久化旧文件，但不经过 syncActiveFiles
+	require.NoError(t, db.Sync())
+	require.Len(t, listener.syncs, 1)
+	assert.Equal(t, db.activeFile.FileID, listener.syncs[0].FileID)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+	require.NoError(t, wb.Delete(utils.GetTestKey(1)))
+	require.NoError(t, wb.Commit())
+	require.Len(t, listener.commits, 1)
+	assert.Equal(t, BatchCommitInfo{SeqNumber: 1, Records: 2}, listener.commits[0])
+
+	fileNum := len(db.oldFiles) + 1
+	require.NoError(t, db.Close())
+	require.Len(t, listener.closes, 1)
+
+	// 活跃文件末尾追加一段不完整的记录，重新打开时被截断并报告
+	fileName := data.GetDataFileName(setup.DirPath, db.activeFile.FileID, data.DataFileNameSuffix)
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3, 4, 0, 8})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	require.Len(t, listener.recoveries, fileNum)
+	last := listener.recoveries[fileNum-1]
+	assert.Equal(t, fileNum, last.FilesDone)
+	assert.Equal(t, fileNum, last.FilesTotal)
+	assert.Greater(t, last.Records, 0)
+	require.Len(t, listener.corruptions, 1)
+	assert.True(t, listener.corruptions[0].Truncated)
+	assert.Equal(t, db.activeFile.FileID, listener.corruptions[0].FileID)
+}
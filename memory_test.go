@@ -0,0 +1,109 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/fio"
+	"bitcask-gown/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_InMemory ensures an in-memory DB never touches its DirPath on disk.
+func TestDB_InMemory(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = filepath.Join(t.TempDir(), "never-created")
+	setup.InMemory = true
+	setup.DataFileSize = 256
+	setup.ValueThreshold = 64
+
+	db, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(i*2)))
+	}
+	require.NoError(t, db.Delete(utils.GetTestKey(0)))
+	assert.Greater(t, len(db.oldFiles), 0)
+	fileNames, err := db.fs.ReadDir(setup.DirPath)
+	require.NoError(t, err)
+	assert.Greater(t, len(fileNames), len(db.oldFiles))
+
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	got, err := db.Get(utils.GetTestKey(49))
+	require.NoError(t, err)
+	assert.Len(t, got, len("bitcask-go-value-")+98)
+	require.NoError(t, db.RunBlobGC(0.5))
+
+	_, err = os.Stat(setup.DirPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestDB_InMemoryParallel runs many isolated in-memory DB instances side by side.
+func TestDB_InMemoryParallel(t *testing.T) {
+	for i := 0; i < 32; i++ {
+		t.Run(fmt.Sprintf("db-%d", i), func(t *testing.T) {
+			t.Parallel()
+			setup := DefaultOptions
+			setup.InMemory = true // 所有实例共享同一个 DirPath，彼此之间依然互不影响
+			db, err := Open(setup)
+			require.NoError(t, err)
+			defer db.Close()
+
+			key := []byte("shared-key")
+			_, err = db.Get(key)
+			require.Equal(t, ErrKeyNotFound, err)
+			value := utils.RandomValue(16)
+			require.NoError(t, db.Put(key, value))
+			got, err := db.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, value, got)
+		})
+	}
+}
+
+// countingFS records how many files a DB opens through a custom FileSystem.
+type countingFS struct {
+	fio.FileSystem
+	opened int
+}
+
+func (c *countingFS) OpenFile(name string, ioType fio.FileIOType, preallocSize int64) (fio.IOManager, error) {
+	c.opened++
+	return c.FileSystem.OpenFile(name, ioType, preallocSize)
+}
+
+// TestDB_CustomFileSystem ensures a DB runs entirely on a pluggable FileSystem and survives reopening on it.
+func TestDB_CustomFileSystem(t *testing.T) {
+	fileSystem := &countingFS{FileSystem: fio.NewMemStore()}
+	setup := DefaultOptions
+	setup.DirPath = "/virtual/db"
+	setup.DataFileSize = 256
+	setup.FileSystem = fileSystem
+
+	db, err := Open(setup)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	require.NoError(t, db.Close())
+	assert.Greater(t, fileSystem.opened, 1)
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.NoError(t, err)
+
+	_, err = os.Stat(setup.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	setup.InMemory = true
+	_, err = Open(setup)
+	assert.Equal(t, ErrInvalidFileSystem, err)
+}
@@ -0,0 +1,165 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/metrics"
+	"slices"
+	"time"
+)
+
+// 合并操作数（参考 RocksDB 的 Merge）：MergeValue 只追加一条 LogRecordMerge 记录，其中保存操作数以及同一个 key 上一条记录的位置，
+// 不需要读取旧的 value。索引指向最新的操作数，同一个 key 的操作数沿着上一条记录的位置组成一条链，链底是普通的记录（或者 key 此前不存在）。
+// 读取时沿着链找到所有的操作数，由 Options.MergeOperator 合并到链底的 value 之上。
+// 链的长度达到 maxMergeDepth 时，写入直接把合并之后的 value 作为普通记录写入；Open 时恢复出的链也会被合并为一条普通记录。
+
+// maxMergeDepth 一条链之中最多的操作数数量，限制了读取时需要访问的记录数
+const maxMergeDepth = 32
+
+// MergeValue 向 key 追加一个合并操作数，读取时由 Options.MergeOperator 将 key 上所有的操作数依次合并到此前的 value 之上
+func (db *DB) MergeValue(key, operand []byte) (err error) {
+	defer db.observe(metrics.OpPut, time.Now(), &err)
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if db.option.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	record := &data.LogRecord{Key: addSeqToKey(key, nonTxnSeqNumber), Type: data.LogRecordMerge}
+	head, exists := db.index.Get(key)
+	var prev *data.LogRecordPos
+	var depth int
+	if exists {
+		headRec, err := db.readLogRecord(head)
+		if err != nil {
+			return err
+		}
+		if headRec.Type == data.LogRecordMerge {
+			_, depth, _ = data.DecodeMergeOperand(headRec.Value)
+		}
+		// 链已经足够长，将合并之后的 value 作为普通记录写入，旧的链整体失效
+		if depth+1 >= maxMergeDepth {
+			value, err := db.foldMergeChain(headRec, [][]byte{operand})
+			if err != nil {
+				return err
+			}
+			record.Type, record.Value = data.LogRecordNormal, value
+		}
+		prev = head
+	}
+	if record.Type == data.LogRecordMerge {
+		record.Value = data.EncodeMergeOperand(prev, depth+1, operand)
+	}
+
+	pos, err := db.appendLogRecord(record)
+	if err != nil {
+		return err
+	}
+
+	if record.Type == data.LogRecordNormal {
+		err = db.indexPut(db.index, key, pos)
+	} else {
+		err = db.indexMerge(db.index, key, pos)
+	}
+	if err != nil {
+		return err
+	}
+	db.notifyWatchers(EventMerge, key, operand, nonTxnSeqNumber, pos)
+	return nil
+}
+
+// readLogRecord 读取 pos 位置上的记录
+func (db *DB) readLogRecord(pos *data.LogRecordPos) (*data.LogRecord, error) {
+	dataFile, err := db.getDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	rec, _, err := dataFile.ReadLogRecord(pos.Offset)
+	return rec, err
+}
+
+// walkMergeChain 从链头 head 开始沿着上一条记录的位置向前读取，返回按照写入顺序排列的操作数，
+// 以及链底的记录和它的位置（key 此前不存在时均为 nil）
+func (db *DB) walkMergeChain(head *data.LogRecord) ([][]byte, *data.LogRecord, *data.LogRecordPos, error) {
+	var operands [][]byte
+	rec := head
+	for {
+		prev, _, operand := data.DecodeMergeOperand(rec.Value)
+		operands = append(operands, operand)
+		if prev == nil {
+			slices.Reverse(operands)
+			return operands, nil, nil, nil
+		}
+
+		var err error
+		if rec, err = db.readLogRecord(prev); err != nil {
+			return nil, nil, nil, err
+		}
+		if rec.Type != data.LogRecordMerge {
+			slices.Reverse(operands)
+			return operands, rec, prev, nil
+		}
+	}
+}
+
+// foldMergeChain 将以 head 为链头的所有操作数以及之后的 operands 合并到链底的 value 之上
+func (db *DB) foldMergeChain(head *data.LogRecord, operands [][]byte) ([]byte, error) {
+	if db.option.MergeOperator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+
+	chain, base, basePos, err := db.walkMergeChain(head)
+	if err != nil {
+		return nil, err
+	}
+	var existing []byte
+	if base != nil {
+		// 键值分离以及流式写入的记录，value 需要单独读取
+		if base.Type == data.LogRecordNormal {
+			existing = base.Value
+		} else if existing, err = db.readValueByPos(basePos); err != nil {
+			return nil, err
+		}
+	}
+
+	key, _ := parseLogRecordKey(head.Key)
+	return db.option.MergeOperator.Merge(key, existing, append(chain, operands...))
+}
+
+// collapseMergeChains 将恢复时发现的操作数链各自合并为一条普通记录，之后的读取不再需要遍历整条链
+func (db *DB) collapseMergeChains(keys map[string]struct{}) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for key := range keys {
+		head, ok := db.index.Get([]byte(key))
+		if !ok {
+			continue
+		}
+		value, err := db.readValueByPos(head)
+		if err != nil {
+			return err
+		}
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:   addSeqToKey([]byte(key), nonTxnSeqNumber),
+			Value: value,
+			Type:  data.LogRecordNormal,
+		})
+		if err != nil {
+			return err
+		}
+		if err := db.indexPut(db.index, []byte(key), pos); err != nil {
+			return err
+		}
+	}
+	db.logger.Info("merge chains collapsed", "keys", len(keys))
+	return nil
+}
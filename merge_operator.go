@@ -0,0 +1,81 @@
+package bitcask_gown
+
+import "encoding/binary"
+
+// MergeOperator 合并操作数的方式，通过 Options.MergeOperator 设置，配合 DB.MergeValue 实现计数器、追加等原子的读-改-写。
+// 合并可能在读取、写入以及恢复时多次执行，相同的输入必须总是得到相同的结果，并且不能修改传入的切片。
+type MergeOperator interface {
+	// Merge 将按照写入顺序排列的 operands 依次合并到 existing 之上，返回合并之后的 value；key 此前不存在时 existing 为 nil
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// MergeFunc 将普通的函数转换为 MergeOperator
+type MergeFunc func(key, existing []byte, operands [][]byte) ([]byte, error)
+
+// Merge 调用 f 本身
+func (f MergeFunc) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return f(key, existing, operands)
+}
+
+// Int64AddOperator 将 value 以及操作数都视为 8 字节大端序的 int64，合并结果为它们的和，key 不存在时视为 0。
+// value 以及操作数可以通过 EncodeInt64、DecodeInt64 编解码
+type Int64AddOperator struct{}
+
+// Merge 累加所有的操作数
+func (Int64AddOperator) Merge(_, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existing != nil {
+		n, err := DecodeInt64(existing)
+		if err != nil {
+			return nil, err
+		}
+		sum = n
+	}
+	for _, operand := range operands {
+		n, err := DecodeInt64(operand)
+		if err != nil {
+			return nil, err
+		}
+		sum += n
+	}
+	return EncodeInt64(sum), nil
+}
+
+// EncodeInt64 将 n 编码为 Int64AddOperator 使用的 8 字节大端序格式
+func EncodeInt64(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// DecodeInt64 解码 Int64AddOperator 使用的 8 字节大端序格式，长度不正确时返回 ErrInvalidMergeOperand
+func DecodeInt64(buf []byte) (int64, error) {
+	if len(buf) != 8 {
+		return 0, ErrInvalidMergeOperand
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+// AppendOperator 将操作数依次追加到 value 之后，Separator 不为空时插入到相邻的两段之间
+type AppendOperator struct {
+	Separator []byte
+}
+
+// Merge 按照写入顺序拼接所有的操作数
+func (op AppendOperator) Merge(_, existing []byte, operands [][]byte) ([]byte, error) {
+	parts := make([][]byte, 0, len(operands)+1)
+	if existing != nil {
+		parts = append(parts, existing)
+	}
+	parts = append(parts, operands...)
+
+	var value []byte
+	for i, part := range parts {
+		if i > 0 {
+			value = append(value, op.Separator...)
+		}
+		value = append(value, part...)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
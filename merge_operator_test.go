@@ -0,0 +1,53 @@
+package bitcask_gown
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInt64AddOperator ensures the add operator sums operands and rejects malformed ones.
+func TestInt64AddOperator(t *testing.T) {
+	var op Int64AddOperator
+	got, err := op.Merge(nil, nil, [][]byte{EncodeInt64(5), EncodeInt64(-8)})
+	require.NoError(t, err)
+	n, err := DecodeInt64(got)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-3), n)
+
+	got, err = op.Merge(nil, EncodeInt64(10), [][]byte{EncodeInt64(1)})
+	require.NoError(t, err)
+	assert.Equal(t, EncodeInt64(11), got)
+
+	_, err = op.Merge(nil, []byte("1"), nil)
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	_, err = op.Merge(nil, nil, [][]byte{{1, 2}})
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+}
+
+// TestAppendOperator ensures the append operator concatenates operands with the separator.
+func TestAppendOperator(t *testing.T) {
+	got, err := AppendOperator{}.Merge(nil, []byte("a"), [][]byte{[]byte("b"), []byte("c")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), got)
+
+	op := AppendOperator{Separator: []byte("|")}
+	got, err = op.Merge(nil, nil, [][]byte{[]byte("b"), []byte("c")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b|c"), got)
+	got, err = op.Merge(nil, nil, [][]byte{{}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, got)
+}
+
+// TestMergeFunc ensures a plain function can be used as a merge operator.
+func TestMergeFunc(t *testing.T) {
+	var op MergeOperator = MergeFunc(func(key, existing []byte, operands [][]byte) ([]byte, error) {
+		return bytes.ToUpper(operands[len(operands)-1]), nil
+	})
+	got, err := op.Merge([]byte("k"), []byte("old"), [][]byte{[]byte("x"), []byte("new")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("NEW"), got)
+}
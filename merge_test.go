@@ -0,0 +1,143 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/utils"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_MergeValue ensures merge operands fold onto the existing value, survive restarts and are collapsed on recovery.
+func TestDB_MergeValue(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4 * 1024
+	setup.MergeOperator = Int64AddOperator{}
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	counter := []byte("counter")
+	total := int64(0)
+	// 操作数的数量超过 maxMergeDepth，中途会合并为一条普通记录
+	for i := 1; i <= 2*maxMergeDepth+5; i++ {
+		require.NoError(t, db.MergeValue(counter, EncodeInt64(int64(i))))
+		total += int64(i)
+	}
+	got, err := db.Get(counter)
+	require.NoError(t, err)
+	n, err := DecodeInt64(got)
+	require.NoError(t, err)
+	assert.Equal(t, total, n)
+
+	// 覆盖以及删除会丢弃整条链
+	require.NoError(t, db.Put([]byte("reset"), EncodeInt64(100)))
+	require.NoError(t, db.MergeValue([]byte("reset"), EncodeInt64(-1)))
+	require.NoError(t, db.Put([]byte("deleted"), EncodeInt64(100)))
+	require.NoError(t, db.MergeValue([]byte("deleted"), EncodeInt64(1)))
+	require.NoError(t, db.Delete([]byte("deleted")))
+	require.NoError(t, db.MergeValue([]byte("deleted"), EncodeInt64(7)))
+
+	assert.Equal(t, ErrKeyIsEmpty, db.MergeValue(nil, EncodeInt64(1)))
+	require.NoError(t, db.MergeValue([]byte("bad"), []byte("x")))
+	_, err = db.Get([]byte("bad"))
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	require.NoError(t, db.Delete([]byte("bad")))
+
+	check := func(db *DB) {
+		for key, want := range map[string]int64{"counter": total, "reset": 99, "deleted": 7} {
+			got, err := db.Get([]byte(key))
+			require.NoError(t, err)
+			n, err := DecodeInt64(got)
+			require.NoError(t, err)
+			assert.Equal(t, want, n, key)
+		}
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 没有设置 MergeOperator 时，无法读取仍然是操作数链的 key，恢复时也不会合并它们
+	setup.MergeOperator = nil
+	db, err = Open(setup)
+	require.NoError(t, err)
+	_, err = db.Get(counter)
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+	assert.Equal(t, ErrMergeOperatorNotSet, db.MergeValue(counter, EncodeInt64(1)))
+	require.NoError(t, db.Close())
+
+	setup.MergeOperator = Int64AddOperator{}
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+	check(db)
+	pos, _ := db.index.Get(counter)
+	rec, err := db.readLogRecord(pos)
+	require.NoError(t, err)
+	assert.Equal(t, data.LogRecordNormal, rec.Type)
+}
+
+// TestDB_MergeValueConcurrent ensures concurrent merges never lose an operand.
+func TestDB_MergeValueConcurrent(t *testing.T) {
+	setup := DefaultOptions
+	setup.MergeOperator = Int64AddOperator{}
+	db, cleanup := newDB(t, setup)
+	defer cleanup()
+
+	const workers, increments = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				require.NoError(t, db.MergeValue([]byte("hits"), EncodeInt64(1)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := db.Get([]byte("hits"))
+	require.NoError(t, err)
+	n, err := DecodeInt64(got)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*increments), n)
+}
+
+// TestDB_MergeValueBlobGC ensures blob GC keeps merge chains whose base value lives in a collected blob file.
+func TestDB_MergeValueBlobGC(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 1024
+	setup.ValueThreshold = 16
+	setup.MergeOperator = AppendOperator{Separator: []byte(",")}
+	db, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+
+	base := utils.RandomValue(64)
+	require.NoError(t, db.Put([]byte("log"), base))
+	require.NoError(t, db.MergeValue([]byte("log"), []byte("a")))
+	require.NoError(t, db.MergeValue([]byte("log"), []byte("b")))
+	want := append(append([]byte{}, base...), []byte(",a,b")...)
+	reader, err := db.GetReader([]byte("log"))
+	require.NoError(t, err)
+	defer reader.Close()
+	buf := make([]byte, len(want))
+	_, err = reader.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, buf)
+
+	// 反复覆盖其他 key，让链底所在的 blob 文件之中大部分都是垃圾
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put([]byte("other"), utils.RandomValue(64)))
+	}
+	filesBefore := blobFileCount(t, setup.DirPath)
+	require.NoError(t, db.RunBlobGC(0.5))
+	assert.Less(t, blobFileCount(t, setup.DirPath), filesBefore)
+
+	got, err := db.Get([]byte("log"))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,197 @@
+// Package metrics 以 Prometheus 文本格式导出数据库的运行指标，不依赖任何第三方库。
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 被统计延迟的操作
+const (
+	OpPut         = "put"
+	OpGet         = "get"
+	OpDelete      = "delete"
+	OpBatchCommit = "batch_commit"
+	OpSync        = "sync"
+)
+
+var ops = []string{OpPut, OpGet, OpDelete, OpBatchCommit, OpSync}
+
+// latencyBuckets 延迟直方图的桶上界（秒），从 10µs 到 1s
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// Metrics 数据库的运行指标，所有方法都可以被并发调用。一个 Metrics 只应当交给一个 DB 使用。
+type Metrics struct {
+	latencies map[string]*histogram
+
+	bytesWritten atomic.Int64
+	bytesRead    atomic.Int64
+	rotations    atomic.Uint64
+
+	lock   *sync.Mutex
+	errors map[errorKey]uint64
+	gauges map[string]*gauge
+}
+
+type errorKey struct {
+	op   string
+	name string
+}
+
+type gauge struct {
+	help string
+	f    func() float64
+}
+
+// histogram 固定桶的累积直方图
+type histogram struct {
+	counts []atomic.Uint64 // 与 latencyBuckets 一一对应，最后一个为 +Inf
+	sumNs  atomic.Int64
+}
+
+// New 创建一组空的指标
+func New() *Metrics {
+	m := &Metrics{
+		latencies: make(map[string]*histogram, len(ops)),
+		lock:      new(sync.Mutex),
+		errors:    make(map[errorKey]uint64),
+		gauges:    make(map[string]*gauge),
+	}
+	for _, op := range ops {
+		m.latencies[op] = &histogram{counts: make([]atomic.Uint64, len(latencyBuckets)+1)}
+	}
+	return m
+}
+
+// ObserveLatency 记录一次 op 操作的耗时
+func (m *Metrics) ObserveLatency(op string, d time.Duration) {
+	h, ok := m.latencies[op]
+	if !ok {
+		return
+	}
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[i].Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// AddBytesWritten 累计写入数据文件的字节数
+func (m *Metrics) AddBytesWritten(n int64) {
+	m.bytesWritten.Add(n)
+}
+
+// AddBytesRead 累计读取的 value 字节数
+func (m *Metrics) AddBytesRead(n int64) {
+	m.bytesRead.Add(n)
+}
+
+// IncRotation 记录一次活跃文件的切换
+func (m *Metrics) IncRotation() {
+	m.rotations.Add(1)
+}
+
+// IncError 记录一次 op 操作返回的错误，name 为错误变量的名称
+func (m *Metrics) IncError(op, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.errors[errorKey{op: op, name: name}]++
+}
+
+// SetGauge 注册名为 name 的瞬时值指标，每次导出时调用 f 获取当前值；同名的指标会被替换
+func (m *Metrics) SetGauge(name, help string, f func() float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.gauges[name] = &gauge{help: help, f: f}
+}
+
+// Handler 返回以 Prometheus 文本格式导出所有指标的 http.Handler
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WriteText(w)
+	})
+}
+
+// WriteText 将所有指标以 Prometheus 文本格式写入 w
+func (m *Metrics) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeHeader(bw, "bitcask_op_duration_seconds", "Latency of database operations.", "histogram")
+	for _, op := range ops {
+		h := m.latencies[op]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i].Load()
+			fmt.Fprintf(bw, "bitcask_op_duration_seconds_bucket{op=%q,le=%q} %d\n", op, formatFloat(le), cumulative)
+		}
+		cumulative += h.counts[len(latencyBuckets)].Load()
+		fmt.Fprintf(bw, "bitcask_op_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, cumulative)
+		fmt.Fprintf(bw, "bitcask_op_duration_seconds_sum{op=%q} %s\n", op, formatFloat(time.Duration(h.sumNs.Load()).Seconds()))
+		fmt.Fprintf(bw, "bitcask_op_duration_seconds_count{op=%q} %d\n", op, cumulative)
+	}
+
+	writeHeader(bw, "bitcask_written_bytes_total", "Bytes appended to data and blob files.", "counter")
+	fmt.Fprintf(bw, "bitcask_written_bytes_total %d\n", m.bytesWritten.Load())
+	writeHeader(bw, "bitcask_read_bytes_total", "Value bytes returned by reads.", "counter")
+	fmt.Fprintf(bw, "bitcask_read_bytes_total %d\n", m.bytesRead.Load())
+	writeHeader(bw, "bitcask_file_rotations_total", "Number of active data file rotations.", "counter")
+	fmt.Fprintf(bw, "bitcask_file_rotations_total %d\n", m.rotations.Load())
+
+	m.lock.Lock()
+	errorKeys := make([]errorKey, 0, len(m.errors))
+	for k := range m.errors {
+		errorKeys = append(errorKeys, k)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i].op != errorKeys[j].op {
+			return errorKeys[i].op < errorKeys[j].op
+		}
+		return errorKeys[i].name < errorKeys[j].name
+	})
+	writeHeader(bw, "bitcask_errors_total", "Errors returned by database operations, by error variable.", "counter")
+	for _, k := range errorKeys {
+		fmt.Fprintf(bw, "bitcask_errors_total{op=%q,error=%q} %d\n", k.op, k.name, m.errors[k])
+	}
+
+	gaugeNames := make([]string, 0, len(m.gauges))
+	for name := range m.gauges {
+		gaugeNames = append(gaugeNames, name)
+	}
+	sort.Strings(gaugeNames)
+	gauges := make([]*gauge, len(gaugeNames))
+	for i, name := range gaugeNames {
+		gauges[i] = m.gauges[name]
+	}
+	m.lock.Unlock()
+
+	// 在锁外调用 gauge 的回调，回调可能需要获取数据库的锁
+	for i, name := range gaugeNames {
+		writeHeader(bw, name, gauges[i].help, "gauge")
+		fmt.Fprintf(bw, "%s %s\n", name, formatFloat(gauges[i].f()))
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
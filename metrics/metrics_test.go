@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_WriteText(t *testing.T) {
+	m := New()
+	m.ObserveLatency(OpPut, 20*time.Microsecond)
+	m.ObserveLatency(OpPut, 2*time.Second)
+	m.ObserveLatency("unknown", time.Second) // 未知的操作被忽略
+	m.AddBytesWritten(100)
+	m.AddBytesRead(10)
+	m.IncRotation()
+	m.IncError(OpGet, "ErrKeyNotFound")
+	m.IncError(OpGet, "ErrKeyNotFound")
+	m.SetGauge("bitcask_keys", "Number of keys.", func() float64 { return 42 })
+
+	var sb strings.Builder
+	require.NoError(t, m.WriteText(&sb))
+	text := sb.String()
+
+	assert.Contains(t, text, "# TYPE bitcask_op_duration_seconds histogram\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_bucket{op="put",le="1e-05"} 0`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_bucket{op="put",le="5e-05"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_bucket{op="put",le="1"} 1`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_bucket{op="put",le="+Inf"} 2`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_sum{op="put"} 2.00002`+"\n")
+	assert.Contains(t, text, `bitcask_op_duration_seconds_count{op="get"} 0`+"\n")
+	assert.Contains(t, text, "bitcask_written_bytes_total 100\n")
+	assert.Contains(t, text, "bitcask_read_bytes_total 10\n")
+	assert.Contains(t, text, "bitcask_file_rotations_total 1\n")
+	assert.Contains(t, text, `bitcask_errors_total{op="get",error="ErrKeyNotFound"} 2`+"\n")
+	assert.Contains(t, text, "# TYPE bitcask_keys gauge\nbitcask_keys 42\n")
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.IncRotation()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "bitcask_file_rotations_total 1\n")
+}
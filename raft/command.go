@@ -0,0 +1,121 @@
+package raft
+
+import (
+	bitcask "bitcask-gown"
+	"encoding/binary"
+)
+
+// opType 命令之中单个写操作的类型
+type opType byte
+
+const (
+	opPut opType = iota
+	opDelete
+)
+
+type op struct {
+	typ   opType
+	key   []byte
+	value []byte
+}
+
+// WriteBatch 通过 Raft 原子地提交一批写入，所有操作编码为同一个日志条目，在每个节点上作为一个 bitcask.WriteBatch 应用
+type WriteBatch struct {
+	node *Node
+	ops  []op
+}
+
+// NewWriteBatch 创建一个绑定到 n 的批量写入
+func (n *Node) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{node: n}
+}
+
+// Put 暂存一次写入
+func (wb *WriteBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, op{typ: opPut, key: key, value: value})
+	return nil
+}
+
+// Delete 暂存一次删除
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, op{typ: opDelete, key: key})
+	return nil
+}
+
+// Commit 提交暂存的所有操作，在日志条目被多数节点复制并在本节点应用之后返回
+func (wb *WriteBatch) Commit() error {
+	if len(wb.ops) == 0 {
+		return nil
+	}
+	return wb.node.propose(encodeCommand(wb.ops))
+}
+
+// encodeCommand 将一组写操作编码为日志条目的数据：
+//
+//	+----------+--------+-------------+-----+---------------+-------+-----
+//	| 操作数量 | 类型   | key 长度    | key | value 长度    | value | ...
+//	| uvarint  | 1 字节 | uvarint     |     | uvarint       |       |
+//	+----------+--------+-------------+-----+---------------+-------+-----
+func encodeCommand(ops []op) []byte {
+	size := binary.MaxVarintLen64
+	for _, o := range ops {
+		size += 1 + 2*binary.MaxVarintLen64 + len(o.key) + len(o.value)
+	}
+	buf := make([]byte, size)
+	index := binary.PutUvarint(buf, uint64(len(ops)))
+	for _, o := range ops {
+		buf[index] = byte(o.typ)
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(len(o.key)))
+		index += copy(buf[index:], o.key)
+		index += binary.PutUvarint(buf[index:], uint64(len(o.value)))
+		index += copy(buf[index:], o.value)
+	}
+	return buf[:index]
+}
+
+// decodeCommand 解码 encodeCommand 编码的数据
+func decodeCommand(buf []byte) ([]op, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidCommand
+	}
+	buf = buf[n:]
+
+	var ops []op
+	for i := uint64(0); i < count; i++ {
+		if len(buf) == 0 {
+			return nil, ErrInvalidCommand
+		}
+		o := op{typ: opType(buf[0])}
+		buf = buf[1:]
+
+		var err error
+		if o.key, buf, err = readBytes(buf); err != nil {
+			return nil, err
+		}
+		if o.value, buf, err = readBytes(buf); err != nil {
+			return nil, err
+		}
+		ops = append(ops, o)
+	}
+	if len(buf) != 0 {
+		return nil, ErrInvalidCommand
+	}
+	return ops, nil
+}
+
+// readBytes 读取一段以 uvarint 长度开头的数据，返回数据以及剩余的部分
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return nil, nil, ErrInvalidCommand
+	}
+	return buf[n : n+int(size)], buf[n+int(size):], nil
+}
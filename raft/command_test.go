@@ -0,0 +1,25 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodeCommand ensures commands round-trip and truncated data is rejected.
+func TestEncodeCommand(t *testing.T) {
+	ops := []op{
+		{typ: opPut, key: []byte("name"), value: []byte("bitcask-go")},
+		{typ: opDelete, key: []byte("age"), value: []byte{}},
+		{typ: opPut, key: []byte("empty"), value: []byte{}},
+	}
+	buf := encodeCommand(ops)
+	decoded, err := decodeCommand(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, ops, decoded)
+
+	for i := 0; i < len(buf); i++ {
+		_, err := decodeCommand(buf[:i])
+		assert.Equal(t, ErrInvalidCommand, err)
+	}
+}
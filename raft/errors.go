@@ -0,0 +1,14 @@
+package raft
+
+import "errors"
+
+var (
+	ErrNotLeader       = errors.New("raft: node is not the leader")
+	ErrLeadershipLost  = errors.New("raft: leadership lost before the entry was applied")
+	ErrNodeClosed      = errors.New("raft: node is closed")
+	ErrInvalidConfig   = errors.New("raft: invalid config")
+	ErrInvalidCommand  = errors.New("raft: invalid command in log entry")
+	ErrInvalidSnapshot = errors.New("raft: invalid snapshot")
+	ErrCorruptedState  = errors.New("raft: persisted raft state is corrupted")
+	ErrDataDirNotEmpty = errors.New("raft: state machine data directory is not empty but there is no raft state for it")
+)
@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnreachable 目标节点没有注册，或者与发送方之间的网络被分区
+var ErrUnreachable = errors.New("raft: node is unreachable")
+
+// InmemNetwork 进程内的模拟网络，所有节点通过直接调用对方的 Handler 通信，
+// 可以模拟网络分区，用于在一个进程之中运行整个集群的测试。
+type InmemNetwork struct {
+	lock     *sync.RWMutex
+	handlers map[string]Handler
+	groups   map[string]int // 节点所在的分区，只有同一分区内的节点可以通信
+}
+
+// NewInmemNetwork 创建一个没有分区的模拟网络
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		lock:     new(sync.RWMutex),
+		handlers: make(map[string]Handler),
+		groups:   make(map[string]int),
+	}
+}
+
+// Register 将节点 id 接入网络，之后发往 id 的请求由 h 处理；重复注册会替换之前的 Handler
+func (n *InmemNetwork) Register(id string, h Handler) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.handlers[id] = h
+}
+
+// Unregister 将节点 id 从网络之中移除，发往它的请求都会返回 ErrUnreachable
+func (n *InmemNetwork) Unregister(id string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.handlers, id)
+}
+
+// Partition 将 ids 与其余节点隔离到不同的分区之中，两侧之间的请求都会返回 ErrUnreachable
+func (n *InmemNetwork) Partition(ids ...string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.groups = make(map[string]int)
+	for _, id := range ids {
+		n.groups[id] = 1
+	}
+}
+
+// Heal 恢复所有节点之间的网络
+func (n *InmemNetwork) Heal() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.groups = make(map[string]int)
+}
+
+// Transport 返回节点 from 用于发送请求的 Transport
+func (n *InmemNetwork) Transport(from string) Transport {
+	return &inmemTransport{network: n, from: from}
+}
+
+// handler 返回 from 可以访问到的 target 的 Handler
+func (n *InmemNetwork) handler(from, target string) (Handler, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	h, ok := n.handlers[target]
+	if !ok || n.groups[from] != n.groups[target] {
+		return nil, ErrUnreachable
+	}
+	return h, nil
+}
+
+type inmemTransport struct {
+	network *InmemNetwork
+	from    string
+}
+
+func (t *inmemTransport) RequestVote(target string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	h, err := t.network.handler(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleRequestVote(req), nil
+}
+
+func (t *inmemTransport) AppendEntries(target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	h, err := t.network.handler(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleAppendEntries(req), nil
+}
+
+func (t *inmemTransport) InstallSnapshot(target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	h, err := t.network.handler(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleInstallSnapshot(req), nil
+}
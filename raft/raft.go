@@ -0,0 +1,878 @@
+package raft
+
+import (
+	bitcask "bitcask-gown"
+	"log/slog"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Raft 复制的 bitcask：集群之中的每个节点都以一个 bitcask.DB 作为状态机，写入先作为日志条目复制到多数节点，
+// 提交之后再由每个节点按照相同的顺序应用到自己的 DB 之中。
+//
+// 任期、投票以及日志在节点对外做出承诺（投票、确认复制）之前持久化到 Config.StateDir 之中，快照同样持久化（见 storage）。
+// 节点重启时加载这些状态，并用最新的快照恢复状态机 DB，快照之后的条目在重新提交之后再次应用：
+// 日志之中只有 Put 以及 Delete，按照顺序重新应用已经应用过的一段条目，得到的状态与之前相同。
+// 持久化、应用条目或者安装快照失败时，节点的状态已经无法保证与磁盘以及其他节点一致，节点随即停止，不再参与集群。
+
+// Config 节点的配置
+type Config struct {
+	ID    string   // 节点的 id，在集群之中唯一
+	Peers []string // 集群之中所有节点的 id，包括自己
+
+	// 状态机 DB 的配置，快照通过操作系统的文件系统备份以及恢复数据目录，因此不支持 InMemory 以及自定义的 FileSystem
+	Options bitcask.Options
+
+	// 持久化 Raft 状态（任期、投票、日志以及快照）的目录，为空时使用 Options.DirPath 之下的 raft 目录
+	StateDir string
+
+	HeartbeatInterval time.Duration // leader 发送心跳的间隔
+	ElectionTimeout   time.Duration // 选举超时，实际的超时在 [ElectionTimeout, 2*ElectionTimeout) 之间随机
+
+	// 自上一次快照以来应用了这么多条目之后，生成新的快照并压缩日志；为 0 时不生成快照
+	SnapshotThreshold uint64
+
+	// 结构化日志，记录选举、快照以及应用失败；为 nil 时不输出日志
+	Logger *slog.Logger
+}
+
+var DefaultConfig = Config{
+	HeartbeatInterval: 50 * time.Millisecond,
+	ElectionTimeout:   500 * time.Millisecond,
+	SnapshotThreshold: 8192,
+}
+
+// maxEntriesPerAppend 单次 AppendEntries 最多携带的条目数量
+const maxEntriesPerAppend = 256
+
+type nodeState int
+
+const (
+	follower nodeState = iota
+	candidate
+	leader
+)
+
+// waiter 等待某个由本节点提出的条目被应用
+type waiter struct {
+	term uint64
+	ch   chan error
+}
+
+// Node Raft 集群之中的一个节点
+type Node struct {
+	config    Config
+	transport Transport
+	logger    *slog.Logger
+
+	mu          *sync.Mutex
+	state       nodeState
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+	log         []Entry // log[0] 是快照包含的最后一个条目，只有 Index 以及 Term 有意义
+	commitIndex uint64
+	lastApplied uint64
+	snapshot    []byte                  // 最新的快照，对应 log[0]
+	pending     *InstallSnapshotRequest // 从 leader 收到、尚未安装的快照
+	deadline    time.Time               // 选举超时的时间点
+
+	nextIndex   map[string]uint64    // leader 为每个节点维护的下一个待发送的条目
+	matchIndex  map[string]uint64    // leader 已知的每个节点已经复制的最大条目
+	lastContact map[string]time.Time // leader 最近一次收到每个节点响应的时间
+	inflight    map[string]bool      // 是否有正在进行的复制协程
+	waiters     map[uint64]*waiter
+
+	smLock  *sync.RWMutex // 安装快照时需要独占状态机
+	sm      *stateMachine
+	storage *storage
+	err     error // 导致节点停止的持久化、应用条目或者安装快照的错误
+
+	applyCh   chan struct{}
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewNode 打开状态机 DB 并启动节点，之后需要把节点注册到 transport 对应的网络之中才能与其他节点通信
+func NewNode(config Config, transport Transport) (*Node, error) {
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+	stateDir := config.StateDir
+	if stateDir == "" {
+		stateDir = filepath.Join(config.Options.DirPath, "raft")
+	}
+	store, err := openStorage(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := store.load()
+	if err != nil {
+		_ = store.close()
+		return nil, err
+	}
+	sm, err := openStateMachine(config.Options)
+	if err != nil {
+		_ = store.close()
+		return nil, err
+	}
+	if st.empty() && sm.db.Stat().KeyNum > 0 {
+		// 没有任何 Raft 状态时，从头应用的日志会叠加在来历不明的数据之上，各个节点无法收敛到相同的状态
+		err = ErrDataDirNotEmpty
+	} else if st.snapshot != nil {
+		// 状态机可能落后于快照（例如收到快照之后、安装完成之前崩溃），重启时总是从最新的快照恢复
+		err = sm.restore(st.snapshot)
+	}
+	if err != nil {
+		_ = sm.close()
+		_ = store.close()
+		return nil, err
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	n := &Node{
+		config:      config,
+		transport:   transport,
+		logger:      logger.With("node", config.ID),
+		mu:          new(sync.Mutex),
+		currentTerm: st.term,
+		votedFor:    st.votedFor,
+		log:         st.log,
+		commitIndex: st.log[0].Index,
+		lastApplied: st.log[0].Index,
+		snapshot:    st.snapshot,
+		waiters:     make(map[uint64]*waiter),
+		smLock:      new(sync.RWMutex),
+		sm:          sm,
+		storage:     store,
+		applyCh:     make(chan struct{}, 1),
+		closeCh:     make(chan struct{}),
+	}
+	n.resetElectionTimer()
+
+	n.wg.Add(2)
+	go n.run()
+	go n.runApplier()
+	return n, nil
+}
+
+func checkConfig(config Config) error {
+	if config.ID == "" || !slices.Contains(config.Peers, config.ID) {
+		return ErrInvalidConfig
+	}
+	if config.HeartbeatInterval <= 0 || config.ElectionTimeout <= config.HeartbeatInterval {
+		return ErrInvalidConfig
+	}
+	if config.Options.InMemory || config.Options.FileSystem != nil || config.Options.Replica {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// Put 通过 Raft 写入一条数据，只有 leader 可以写入，条目提交并在本节点应用之后返回
+func (n *Node) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(encodeCommand([]op{{typ: opPut, key: key, value: value}}))
+}
+
+// Delete 通过 Raft 删除一条数据，只有 leader 可以删除
+func (n *Node) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(encodeCommand([]op{{typ: opDelete, key: key}}))
+}
+
+// Get 从本节点的状态机读取数据。任何节点都可以读取，但是非 leader 节点可能读到落后的数据；节点停止之后返回 ErrNodeClosed
+func (n *Node) Get(key []byte) ([]byte, error) {
+	n.smLock.RLock()
+	defer n.smLock.RUnlock()
+	if n.stopped() || n.sm.db == nil {
+		return nil, ErrNodeClosed
+	}
+	return n.sm.db.Get(key)
+}
+
+// IsLeader 本节点当前是否为 leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == leader
+}
+
+// Leader 返回本节点所知的 leader 的 id，未知时为空
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// Close 停止节点并关闭状态机 DB，等待中的写入返回 ErrNodeClosed。节点此前因为持久化、应用条目或者安装快照失败而停止时，返回导致停止的错误
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.closeCh)
+	})
+	n.wg.Wait()
+
+	n.mu.Lock()
+	n.failWaiters(ErrNodeClosed)
+	failure := n.err
+	n.mu.Unlock()
+
+	n.smLock.Lock()
+	defer n.smLock.Unlock()
+	err := n.sm.close()
+	if storageErr := n.storage.close(); err == nil {
+		err = storageErr
+	}
+	if err == nil {
+		err = failure
+	}
+	return err
+}
+
+// stopped 节点是否已经停止
+func (n *Node) stopped() bool {
+	select {
+	case <-n.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail 持久化 Raft 状态、应用条目或者安装快照失败时停止节点：内存之中的状态已经无法与磁盘以及状态机保持一致，继续参与集群会破坏 Raft 的安全性。
+// 调用方持有 n.mu
+func (n *Node) fail(err error) {
+	if n.err == nil {
+		n.err = err
+		n.logger.Error("stopping node", "term", n.currentTerm, "err", err)
+	}
+	n.state = follower
+	n.failWaiters(ErrNodeClosed)
+	n.closeOnce.Do(func() {
+		close(n.closeCh)
+	})
+}
+
+// persistState 持久化任期以及投票，失败时停止节点并返回 false；节点已经停止时不再写入。调用方持有 n.mu
+func (n *Node) persistState() bool {
+	if n.stopped() {
+		return false
+	}
+	if err := n.storage.saveState(n.currentTerm, n.votedFor); err != nil {
+		n.fail(err)
+		return false
+	}
+	return true
+}
+
+// persistEntries 持久化追加的条目，并删除 oldLast 之前被它们替换掉的条目，失败时停止节点并返回 false；节点已经停止时不再写入。调用方持有 n.mu
+func (n *Node) persistEntries(entries []Entry, oldLast uint64) bool {
+	if n.stopped() {
+		return false
+	}
+	if err := n.storage.saveEntries(entries, oldLast); err != nil {
+		n.fail(err)
+		return false
+	}
+	return true
+}
+
+// propose 作为 leader 追加一个命令条目，等待其被应用
+func (n *Node) propose(command []byte) error {
+	n.mu.Lock()
+	if n.stopped() {
+		n.mu.Unlock()
+		return ErrNodeClosed
+	}
+	if n.state != leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	index, ok := n.appendEntry(EntryCommand, command)
+	if !ok {
+		n.mu.Unlock()
+		return ErrNodeClosed
+	}
+	w := &waiter{term: n.currentTerm, ch: make(chan error, 1)}
+	n.waiters[index] = w
+	n.mu.Unlock()
+
+	select {
+	case err := <-w.ch:
+		return err
+	case <-n.closeCh:
+		return ErrNodeClosed
+	}
+}
+
+// run 驱动选举超时以及 leader 的心跳
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state == leader {
+			// 长时间联系不上多数节点的 leader 主动退位，使等待中的写入尽快失败
+			if !n.hasQuorumContact() {
+				n.logger.Warn("stepping down, lost contact with quorum", "term", n.currentTerm)
+				n.becomeFollower(n.currentTerm)
+			} else {
+				n.broadcast()
+			}
+		} else if time.Now().After(n.deadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) firstIndex() uint64 {
+	return n.log[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// term 返回条目 index 的任期，index 不在日志之中（已被快照压缩或者还不存在）时 ok 为 false
+func (n *Node) term(index uint64) (uint64, bool) {
+	if index < n.firstIndex() || index > n.lastIndex() {
+		return 0, false
+	}
+	return n.log[index-n.firstIndex()].Term, true
+}
+
+// entries 返回 [from, to] 之间的条目
+func (n *Node) entries(from, to uint64) []Entry {
+	first := n.firstIndex()
+	return slices.Clone(n.log[from-first : to-first+1])
+}
+
+// appendEntry leader 在日志末尾追加一个条目，持久化之后复制给其他节点；持久化失败时返回 false
+func (n *Node) appendEntry(typ EntryType, data []byte) (uint64, bool) {
+	entry := Entry{Index: n.lastIndex() + 1, Term: n.currentTerm, Type: typ, Data: data}
+	if !n.persistEntries([]Entry{entry}, n.lastIndex()) {
+		return 0, false
+	}
+	n.log = append(n.log, entry)
+	n.matchIndex[n.config.ID] = entry.Index
+	n.advanceCommitIndex()
+	n.broadcast()
+	return entry.Index, true
+}
+
+func (n *Node) resetElectionTimer() {
+	timeout := n.config.ElectionTimeout + rand.N(n.config.ElectionTimeout)
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *Node) startElection() {
+	n.state = candidate
+	n.currentTerm++
+	n.votedFor = n.config.ID
+	n.leaderID = ""
+	n.resetElectionTimer()
+	if !n.persistState() {
+		return
+	}
+	n.logger.Info("starting election", "term", n.currentTerm)
+
+	votes := 1
+	if votes > len(n.config.Peers)/2 {
+		n.becomeLeader()
+		return
+	}
+
+	req := &RequestVoteRequest{
+		Term:         n.currentTerm,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		go func(peer string) {
+			resp, err := n.transport.RequestVote(peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.currentTerm {
+				n.becomeFollower(resp.Term)
+				return
+			}
+			if n.state != candidate || n.currentTerm != req.Term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes > len(n.config.Peers)/2 {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.state = leader
+	n.leaderID = n.config.ID
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.inflight = make(map[string]bool)
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.lastContact[peer] = time.Now()
+	}
+	n.logger.Info("became leader", "term", n.currentTerm)
+
+	// 追加一个当前任期的空条目，之前任期遗留的条目随之一起提交
+	n.appendEntry(EntryNoop, nil)
+}
+
+// becomeFollower 转变为 follower，term 更大时更新任期并清除投票
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.persistState()
+	}
+	if n.state == leader {
+		n.failWaiters(ErrLeadershipLost)
+	}
+	n.state = follower
+	n.resetElectionTimer()
+}
+
+func (n *Node) hasQuorumContact() bool {
+	contacted := 1
+	for _, peer := range n.config.Peers {
+		if peer != n.config.ID && time.Since(n.lastContact[peer]) < n.config.ElectionTimeout {
+			contacted++
+		}
+	}
+	return contacted > len(n.config.Peers)/2
+}
+
+func (n *Node) failWaiters(err error) {
+	for index, w := range n.waiters {
+		w.ch <- err
+		delete(n.waiters, index)
+	}
+}
+
+// broadcast 向所有没有正在进行复制的节点发送日志条目或者心跳
+func (n *Node) broadcast() {
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID || n.inflight[peer] {
+			continue
+		}
+		n.inflight[peer] = true
+		go n.replicate(peer, n.currentTerm)
+	}
+}
+
+// replicate 持续向 peer 发送日志条目，直到它追上 leader 的日志，或者本节点不再是 term 任期的 leader
+func (n *Node) replicate(peer string, term uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	defer func() {
+		if n.state == leader && n.currentTerm == term {
+			n.inflight[peer] = false
+		}
+	}()
+
+	for n.state == leader && n.currentTerm == term {
+		var ok bool
+		if n.nextIndex[peer] <= n.firstIndex() {
+			ok = n.sendSnapshot(peer, term)
+		} else {
+			ok = n.sendEntries(peer, term)
+		}
+		if !ok || n.nextIndex[peer] > n.lastIndex() {
+			return
+		}
+	}
+}
+
+// sendEntries 发送一次 AppendEntries，调用时持有 n.mu，发送期间释放；发送失败时返回 false
+func (n *Node) sendEntries(peer string, term uint64) bool {
+	prevIndex := n.nextIndex[peer] - 1
+	prevTerm, _ := n.term(prevIndex)
+	req := &AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  prevTerm,
+		LeaderCommit: n.commitIndex,
+	}
+	if prevIndex < n.lastIndex() {
+		req.Entries = n.entries(prevIndex+1, min(n.lastIndex(), prevIndex+maxEntriesPerAppend))
+	}
+
+	n.mu.Unlock()
+	resp, err := n.transport.AppendEntries(peer, req)
+	n.mu.Lock()
+	if err != nil {
+		return false
+	}
+	if resp.Term > n.currentTerm {
+		n.becomeFollower(resp.Term)
+		return false
+	}
+	if n.state != leader || n.currentTerm != term {
+		return false
+	}
+
+	n.lastContact[peer] = time.Now()
+	if !resp.Success {
+		n.nextIndex[peer] = max(resp.NextIndex, 1)
+		return true
+	}
+	match := prevIndex + uint64(len(req.Entries))
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+		n.advanceCommitIndex()
+	}
+	n.nextIndex[peer] = max(n.nextIndex[peer], match+1)
+	return true
+}
+
+// sendSnapshot peer 需要的条目已经被压缩，发送快照，调用时持有 n.mu，发送期间释放
+func (n *Node) sendSnapshot(peer string, term uint64) bool {
+	req := &InstallSnapshotRequest{
+		Term:              term,
+		LeaderID:          n.config.ID,
+		LastIncludedIndex: n.log[0].Index,
+		LastIncludedTerm:  n.log[0].Term,
+		Data:              n.snapshot,
+	}
+	n.logger.Info("sending snapshot", "peer", peer, "index", req.LastIncludedIndex, "size", len(req.Data))
+
+	n.mu.Unlock()
+	resp, err := n.transport.InstallSnapshot(peer, req)
+	n.mu.Lock()
+	if err != nil {
+		return false
+	}
+	if resp.Term > n.currentTerm {
+		n.becomeFollower(resp.Term)
+		return false
+	}
+	if n.state != leader || n.currentTerm != term {
+		return false
+	}
+
+	n.lastContact[peer] = time.Now()
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIncludedIndex)
+	n.nextIndex[peer] = max(n.nextIndex[peer], req.LastIncludedIndex+1)
+	return true
+}
+
+// advanceCommitIndex 提交已经被多数节点复制的、当前任期的条目
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.term(index); term != n.currentTerm {
+			break
+		}
+		replicated := 0
+		for _, peer := range n.config.Peers {
+			if n.matchIndex[peer] >= index {
+				replicated++
+			}
+		}
+		if replicated > len(n.config.Peers)/2 {
+			n.commitIndex = index
+			n.notifyApplier()
+			return
+		}
+	}
+}
+
+// HandleRequestVote 处理候选人的投票请求
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) *RequestVoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.currentTerm && !n.stopped() {
+		n.becomeFollower(req.Term)
+	}
+	resp := &RequestVoteResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm || n.stopped() {
+		return resp
+	}
+
+	// 只投票给日志至少和自己一样新的候选人
+	upToDate := req.LastLogTerm > n.lastTerm() ||
+		(req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		// 投票在回复之前持久化，重启之后不会在同一个任期之内再投给其他候选人
+		n.votedFor = req.CandidateID
+		if !n.persistState() {
+			return resp
+		}
+		n.resetElectionTimer()
+		resp.VoteGranted = true
+	}
+	return resp
+}
+
+// HandleAppendEntries 处理 leader 的日志复制以及心跳
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm || n.stopped() {
+		return &AppendEntriesResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm || n.state != follower {
+		n.becomeFollower(req.Term)
+	}
+	resp := &AppendEntriesResponse{Term: n.currentTerm}
+	if n.stopped() {
+		return resp
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	// 快照之中的条目都已经提交，与 leader 必然一致，跳过它们
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if prevIndex < n.firstIndex() {
+		skip := min(n.firstIndex()-prevIndex, uint64(len(entries)))
+		prevIndex, prevTerm, entries = n.firstIndex(), n.log[0].Term, entries[skip:]
+	}
+
+	if prevIndex > n.lastIndex() {
+		resp.NextIndex = n.lastIndex() + 1
+		return resp
+	}
+	if term, _ := n.term(prevIndex); term != prevTerm {
+		// 已经提交的条目与 leader 一定一致，从提交位置之后重新开始
+		resp.NextIndex = n.commitIndex + 1
+		return resp
+	}
+
+	oldLast := n.lastIndex()
+	for i, entry := range entries {
+		if term, ok := n.term(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			// 删除冲突的条目以及之后的所有条目
+			n.log = n.log[:entry.Index-n.firstIndex()]
+		}
+		n.log = append(n.log, entries[i:]...)
+		// 条目持久化之后才能向 leader 确认复制成功
+		if !n.persistEntries(entries[i:], oldLast) {
+			return resp
+		}
+		break
+	}
+
+	if commitIndex := min(req.LeaderCommit, prevIndex+uint64(len(entries))); commitIndex > n.commitIndex {
+		n.commitIndex = commitIndex
+		n.notifyApplier()
+	}
+	resp.Success = true
+	return resp
+}
+
+// HandleInstallSnapshot 处理 leader 发送的快照，快照由应用协程在之后安装
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm || n.stopped() {
+		return &InstallSnapshotResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm || n.state != follower {
+		n.becomeFollower(req.Term)
+	}
+	resp := &InstallSnapshotResponse{Term: n.currentTerm}
+	if n.stopped() {
+		return resp
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	if req.LastIncludedIndex <= n.commitIndex {
+		return resp
+	}
+
+	// 日志之中包含快照的最后一个条目时保留其后的条目，否则丢弃整个日志；快照以及日志的变化在回复之前持久化
+	term, ok := n.term(req.LastIncludedIndex)
+	keep := ok && term == req.LastIncludedTerm
+	dropTo := req.LastIncludedIndex
+	if !keep {
+		dropTo = max(dropTo, n.lastIndex())
+	}
+	if err := n.storage.saveSnapshot(req.LastIncludedIndex, req.LastIncludedTerm, req.Data, n.firstIndex()+1, dropTo); err != nil {
+		n.fail(err)
+		return resp
+	}
+	if keep {
+		n.log = slices.Clone(n.log[req.LastIncludedIndex-n.firstIndex():])
+	} else {
+		n.log = []Entry{{Index: req.LastIncludedIndex, Term: req.LastIncludedTerm}}
+	}
+	n.log[0].Type, n.log[0].Data = EntryNoop, nil
+	n.snapshot = req.Data
+	n.commitIndex = req.LastIncludedIndex
+	n.pending = req
+	n.notifyApplier()
+	n.logger.Info("snapshot received", "index", req.LastIncludedIndex, "size", len(req.Data))
+	return resp
+}
+
+func (n *Node) notifyApplier() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// runApplier 按顺序将已提交的条目应用到状态机，安装收到的快照，并在需要时生成新的快照
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case <-n.applyCh:
+		}
+
+		for n.applyCommitted() {
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// applyCommitted 安装待安装的快照，或者应用一批已提交的条目；没有可做的事情时返回 false
+func (n *Node) applyCommitted() bool {
+	n.mu.Lock()
+	if n.stopped() {
+		n.mu.Unlock()
+		return false
+	}
+	if pending := n.pending; pending != nil {
+		n.pending = nil
+		n.mu.Unlock()
+
+		n.smLock.Lock()
+		err := n.sm.restore(pending.Data)
+		n.smLock.Unlock()
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if err != nil {
+			// 状态机没有到达快照的状态，可能已经关闭，不能再应用快照之后的条目
+			n.logger.Error("restore snapshot failed", "index", pending.LastIncludedIndex, "err", err)
+			n.fail(err)
+			return false
+		}
+		n.lastApplied = max(n.lastApplied, pending.LastIncludedIndex)
+		return true
+	}
+	if n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return false
+	}
+	entries := n.entries(n.lastApplied+1, n.commitIndex)
+	n.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Type == EntryCommand {
+			n.smLock.RLock()
+			err := n.sm.apply(entry.Data)
+			n.smLock.RUnlock()
+			if err != nil {
+				// 其他节点可能已经成功应用了这个条目，跳过它会让本节点的状态机与集群分叉
+				n.mu.Lock()
+				n.logger.Error("apply entry failed", "index", entry.Index, "err", err)
+				n.fail(err)
+				n.mu.Unlock()
+				return false
+			}
+		}
+
+		n.mu.Lock()
+		n.lastApplied = entry.Index
+		if w, ok := n.waiters[entry.Index]; ok {
+			var err error
+			if w.term != entry.Term {
+				err = ErrLeadershipLost // 本节点提出的条目被新的 leader 覆盖
+			}
+			w.ch <- err
+			delete(n.waiters, entry.Index)
+		}
+		n.mu.Unlock()
+	}
+	return true
+}
+
+// maybeSnapshot 自上一次快照以来应用的条目超过 SnapshotThreshold 时生成快照，并压缩已经包含在快照之中的日志
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	if n.stopped() {
+		n.mu.Unlock()
+		return
+	}
+	index := n.lastApplied
+	term, ok := n.term(index)
+	first := n.firstIndex()
+	n.mu.Unlock()
+	if n.config.SnapshotThreshold == 0 || !ok || index-first < n.config.SnapshotThreshold {
+		return
+	}
+
+	// 应用协程是状态机唯一的写入者，此时状态机恰好处于 index 之后的状态
+	n.smLock.RLock()
+	snapshot, err := n.sm.snapshot()
+	n.smLock.RUnlock()
+	if err != nil {
+		n.logger.Error("take snapshot failed", "index", index, "err", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped() || index <= n.firstIndex() {
+		return
+	}
+	if err := n.storage.saveSnapshot(index, term, snapshot, n.firstIndex()+1, index); err != nil {
+		n.fail(err)
+		return
+	}
+	n.log = slices.Clone(n.log[index-n.firstIndex():])
+	n.log[0] = Entry{Index: index, Term: term, Type: EntryNoop}
+	n.snapshot = snapshot
+	n.logger.Info("snapshot taken", "index", index, "size", len(snapshot))
+}
@@ -0,0 +1,392 @@
+package raft
+
+import (
+	"archive/tar"
+	bitcask "bitcask-gown"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cluster is an in-process Raft cluster connected through an InmemNetwork.
+type cluster struct {
+	t       *testing.T
+	config  Config
+	network *InmemNetwork
+	nodes   map[string]*Node
+	dirs    map[string]string
+}
+
+func newCluster(t *testing.T, size int, snapshotThreshold uint64) *cluster {
+	t.Helper()
+	config := DefaultConfig
+	// 每一个条目都需要 fsync，超时过短时慢速磁盘上会频繁地重新选举
+	config.HeartbeatInterval = 30 * time.Millisecond
+	config.ElectionTimeout = 300 * time.Millisecond
+	config.SnapshotThreshold = snapshotThreshold
+	for i := 1; i <= size; i++ {
+		config.Peers = append(config.Peers, fmt.Sprintf("node-%d", i))
+	}
+
+	c := &cluster{t: t, config: config, network: NewInmemNetwork(), nodes: make(map[string]*Node), dirs: make(map[string]string)}
+	for _, id := range config.Peers {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			_ = node.Close()
+		}
+	})
+	return c
+}
+
+// start starts node id on a fresh, empty data directory.
+func (c *cluster) start(id string) *Node {
+	c.t.Helper()
+	c.dirs[id] = c.t.TempDir()
+	return c.restart(id)
+}
+
+// restart starts node id on the data directory it used last time.
+func (c *cluster) restart(id string) *Node {
+	c.t.Helper()
+	config := c.config
+	config.ID = id
+	config.Options = bitcask.DefaultOptions
+	config.Options.DirPath = c.dirs[id]
+	node, err := NewNode(config, c.network.Transport(id))
+	require.NoError(c.t, err)
+	c.network.Register(id, node)
+	c.nodes[id] = node
+	return node
+}
+
+// stop disconnects node id and closes it.
+func (c *cluster) stop(id string) {
+	c.network.Unregister(id)
+	require.NoError(c.t, c.nodes[id].Close())
+	delete(c.nodes, id)
+}
+
+// waitLeader waits until exactly one of ids is the leader and returns it.
+func (c *cluster) waitLeader(ids ...string) *Node {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.config.Peers
+	}
+	var leader *Node
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		for _, id := range ids {
+			if node, ok := c.nodes[id]; ok && node.IsLeader() {
+				if leader != nil {
+					return false
+				}
+				leader = node
+			}
+		}
+		return leader != nil
+	}, 5*time.Second, 5*time.Millisecond)
+	return leader
+}
+
+// propose runs op against the current leader among ids, retrying while leadership changes hands, and returns the
+// node that accepted it.
+func (c *cluster) propose(op func(leader *Node) error, ids ...string) *Node {
+	c.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		leader := c.waitLeader(ids...)
+		err := op(leader)
+		if (err != ErrNotLeader && err != ErrLeadershipLost) || time.Now().After(deadline) {
+			require.NoError(c.t, err)
+			return leader
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// put writes key through the current leader among ids.
+func (c *cluster) put(key, value []byte, ids ...string) *Node {
+	c.t.Helper()
+	return c.propose(func(leader *Node) error { return leader.Put(key, value) }, ids...)
+}
+
+// waitValue waits until every node in ids serves value for key; a nil value means the key is absent.
+func (c *cluster) waitValue(key, value []byte, ids ...string) {
+	c.t.Helper()
+	for _, id := range ids {
+		node := c.nodes[id]
+		require.Eventually(c.t, func() bool {
+			got, err := node.Get(key)
+			if value == nil {
+				return err == bitcask.ErrKeyNotFound
+			}
+			return err == nil && string(got) == string(value)
+		}, 10*time.Second, 5*time.Millisecond, "node %s key %s", id, key)
+	}
+}
+
+// TestCluster_Replicate ensures Put, Delete and WriteBatch committed on the leader are applied on every node.
+func TestCluster_Replicate(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	for i := 0; i < 50; i++ {
+		c.put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	c.propose(func(leader *Node) error { return leader.Delete([]byte("key-0")) })
+	leader := c.propose(func(leader *Node) error {
+		wb := leader.NewWriteBatch()
+		require.NoError(t, wb.Put([]byte("batch-1"), []byte("v1")))
+		require.NoError(t, wb.Put([]byte("batch-2"), []byte("v2")))
+		require.NoError(t, wb.Delete([]byte("key-1")))
+		return wb.Commit()
+	})
+
+	// leader 在写入返回时已经应用
+	got, err := leader.Get([]byte("batch-2"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), got)
+
+	c.waitValue([]byte("key-49"), []byte("value-49"), c.config.Peers...)
+	c.waitValue([]byte("batch-1"), []byte("v1"), c.config.Peers...)
+	c.waitValue([]byte("key-0"), nil, c.config.Peers...)
+	c.waitValue([]byte("key-1"), nil, c.config.Peers...)
+
+	leader = c.waitLeader()
+	for _, node := range c.nodes {
+		if node != leader {
+			assert.Equal(t, ErrNotLeader, node.Put([]byte("k"), []byte("v")))
+			assert.Equal(t, leader.config.ID, node.Leader())
+		}
+	}
+}
+
+// TestCluster_Partition ensures the majority side elects a new leader and the old leader converges after healing.
+func TestCluster_Partition(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	oldLeader := c.put([]byte("before"), []byte("1"))
+
+	var majority []string
+	for _, id := range c.config.Peers {
+		if id != oldLeader.config.ID {
+			majority = append(majority, id)
+		}
+	}
+	c.network.Partition(oldLeader.config.ID)
+
+	// 少数派一侧的写入无法提交，leader 联系不上多数节点之后退位
+	err := oldLeader.Put([]byte("lost"), []byte("x"))
+	assert.Contains(t, []error{ErrNotLeader, ErrLeadershipLost}, err)
+
+	c.put([]byte("during"), []byte("2"), majority...)
+	c.waitValue([]byte("during"), []byte("2"), majority...)
+	_, err = oldLeader.Get([]byte("during"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	c.network.Heal()
+	c.put([]byte("after"), []byte("3"))
+	c.waitValue([]byte("after"), []byte("3"), c.config.Peers...)
+	c.waitValue([]byte("during"), []byte("2"), c.config.Peers...)
+	c.waitValue([]byte("lost"), nil, c.config.Peers...)
+}
+
+// TestCluster_SnapshotRestore ensures a node replaced with an empty directory is restored from the leader's snapshot.
+func TestCluster_SnapshotRestore(t *testing.T) {
+	c := newCluster(t, 3, 20)
+	leader := c.waitLeader()
+
+	var replaced string
+	for _, id := range c.config.Peers {
+		if id != leader.config.ID {
+			replaced = id
+			break
+		}
+	}
+	c.stop(replaced)
+
+	for i := 0; i < 100; i++ {
+		c.put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	leader = c.propose(func(leader *Node) error { return leader.Delete([]byte("key-0")) })
+	// 日志已经被快照压缩，快照由应用协程在应用之后异步生成
+	require.Eventually(t, func() bool {
+		leader.mu.Lock()
+		defer leader.mu.Unlock()
+		return leader.firstIndex() > 0
+	}, 10*time.Second, 5*time.Millisecond)
+
+	node := c.start(replaced)
+	c.waitValue([]byte("key-99"), []byte("value-99"), replaced)
+	c.waitValue([]byte("key-0"), nil, replaced)
+	for i := 1; i < 100; i++ {
+		got, err := node.Get([]byte(fmt.Sprintf("key-%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(got))
+	}
+
+	// 恢复之后继续正常复制新的条目
+	c.put([]byte("after"), []byte("restore"))
+	c.waitValue([]byte("after"), []byte("restore"), replaced)
+}
+
+// TestNewNode_InvalidConfig ensures misconfigured nodes are rejected before opening the DB.
+func TestNewNode_InvalidConfig(t *testing.T) {
+	config := DefaultConfig
+	config.ID = "a"
+	config.Peers = []string{"b", "c"}
+	config.Options = bitcask.DefaultOptions
+	config.Options.DirPath = t.TempDir()
+	_, err := NewNode(config, NewInmemNetwork().Transport("a"))
+	assert.Equal(t, ErrInvalidConfig, err)
+
+	config.Peers = []string{"a"}
+	config.Options.InMemory = true
+	_, err = NewNode(config, NewInmemNetwork().Transport("a"))
+	assert.Equal(t, ErrInvalidConfig, err)
+}
+
+// TestCluster_Restart ensures nodes restarted on their existing directories keep their data and rejoin the cluster.
+func TestCluster_Restart(t *testing.T) {
+	c := newCluster(t, 3, 20)
+	for i := 0; i < 50; i++ {
+		c.put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	leader := c.propose(func(leader *Node) error { return leader.Delete([]byte("key-0")) })
+	c.waitValue([]byte("key-0"), nil, c.config.Peers...)
+
+	// 单个 follower 重启之后追赶它停止期间提交的条目
+	var follower string
+	for _, id := range c.config.Peers {
+		if id != leader.config.ID {
+			follower = id
+			break
+		}
+	}
+	c.stop(follower)
+	c.put([]byte("while-down"), []byte("1"))
+	c.restart(follower)
+	c.waitValue([]byte("while-down"), []byte("1"), follower)
+
+	// 整个集群重启，已提交的数据仍然存在，并且可以继续写入
+	for _, id := range c.config.Peers {
+		c.stop(id)
+	}
+	for _, id := range c.config.Peers {
+		c.restart(id)
+	}
+	c.waitValue([]byte("key-49"), []byte("value-49"), c.config.Peers...)
+	c.waitValue([]byte("key-0"), nil, c.config.Peers...)
+	c.waitValue([]byte("while-down"), []byte("1"), c.config.Peers...)
+	c.put([]byte("after"), []byte("restart"))
+	c.waitValue([]byte("after"), []byte("restart"), c.config.Peers...)
+}
+
+// TestNode_PersistState ensures the term, vote, log and snapshot a node had when it stopped are loaded on restart.
+func TestNode_PersistState(t *testing.T) {
+	c := newCluster(t, 3, 20)
+	for i := 0; i < 30; i++ {
+		c.put([]byte(fmt.Sprintf("key-%d", i)), []byte("v"))
+	}
+
+	for _, id := range c.config.Peers {
+		node := c.nodes[id]
+		c.stop(id)
+
+		store, err := openStorage(filepath.Join(c.dirs[id], "raft"))
+		require.NoError(t, err)
+		st, err := store.load()
+		require.NoError(t, err)
+		require.NoError(t, store.close())
+		assert.Equal(t, node.currentTerm, st.term)
+		assert.Equal(t, node.votedFor, st.votedFor)
+		assert.Equal(t, node.snapshot, st.snapshot)
+		// 空的 Data 加载之后为 []byte{}，逐个字段比较
+		require.Len(t, st.log, len(node.log))
+		for i, entry := range node.log {
+			assert.Equal(t, entry.Index, st.log[i].Index)
+			assert.Equal(t, entry.Term, st.log[i].Term)
+			assert.Equal(t, entry.Type, st.log[i].Type)
+			assert.True(t, bytes.Equal(entry.Data, st.log[i].Data))
+		}
+	}
+}
+
+// TestNewNode_DataDirNotEmpty ensures a node refuses a populated data directory that has no Raft state.
+func TestNewNode_DataDirNotEmpty(t *testing.T) {
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := bitcask.Open(options)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k"), []byte("v")))
+	require.NoError(t, db.Close())
+
+	config := DefaultConfig
+	config.ID = "a"
+	config.Peers = []string{"a"}
+	config.Options = options
+	_, err = NewNode(config, NewInmemNetwork().Transport("a"))
+	assert.Equal(t, ErrDataDirNotEmpty, err)
+}
+
+// TestNode_FailedRestoreStops ensures a snapshot that cannot be installed stops the node instead of applying later entries.
+func TestNode_FailedRestoreStops(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil.data", Mode: 0644}))
+	require.NoError(t, tw.Close())
+
+	node := newFollower(t)
+	resp := node.HandleInstallSnapshot(&InstallSnapshotRequest{Term: 1, LeaderID: "b", LastIncludedIndex: 5, LastIncludedTerm: 1, Data: buf.Bytes()})
+	assert.Equal(t, uint64(1), resp.Term)
+	require.Eventually(t, node.stopped, 5*time.Second, 5*time.Millisecond)
+
+	node.mu.Lock()
+	assert.Equal(t, uint64(0), node.lastApplied)
+	node.mu.Unlock()
+	_, err := node.Get([]byte("k"))
+	assert.Equal(t, ErrNodeClosed, err)
+	assert.Equal(t, ErrNodeClosed, node.Put([]byte("k"), []byte("v")))
+	assert.False(t, node.HandleAppendEntries(&AppendEntriesRequest{Term: 1, LeaderID: "b", PrevLogIndex: 5, PrevLogTerm: 1}).Success)
+	assert.Equal(t, ErrInvalidSnapshot, node.Close())
+}
+
+// TestNode_FailedApplyStops ensures an entry the state machine cannot apply stops the node instead of being skipped.
+func TestNode_FailedApplyStops(t *testing.T) {
+	node := newFollower(t)
+	resp := node.HandleAppendEntries(&AppendEntriesRequest{
+		Term:         1,
+		LeaderID:     "b",
+		Entries:      []Entry{{Index: 1, Term: 1, Type: EntryCommand, Data: []byte{1}}},
+		LeaderCommit: 1,
+	})
+	assert.True(t, resp.Success)
+	require.Eventually(t, node.stopped, 5*time.Second, 5*time.Millisecond)
+
+	node.mu.Lock()
+	assert.Equal(t, uint64(0), node.lastApplied)
+	node.mu.Unlock()
+	assert.Equal(t, ErrInvalidCommand, node.Close())
+}
+
+// newFollower starts node a of a three-node cluster whose peers never answer, so it stays a follower for the
+// election timeout and the test can drive it through its RPC handlers.
+func newFollower(t *testing.T) *Node {
+	t.Helper()
+	config := DefaultConfig
+	config.ID = "a"
+	config.Peers = []string{"a", "b", "c"}
+	config.Options = bitcask.DefaultOptions
+	config.Options.DirPath = t.TempDir()
+	node, err := NewNode(config, NewInmemNetwork().Transport("a"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = node.Close()
+	})
+	return node
+}
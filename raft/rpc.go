@@ -0,0 +1,79 @@
+package raft
+
+// EntryType 日志条目的类型
+type EntryType byte
+
+const (
+	// EntryCommand 写入状态机的命令，由 Put、Delete 以及 Commit 提交
+	EntryCommand EntryType = iota
+	// EntryNoop leader 当选之后追加的空条目，用于提交之前任期遗留的条目
+	EntryNoop
+)
+
+// Entry Raft 日志之中的一个条目
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// RequestVoteRequest 候选人请求投票
+type RequestVoteRequest struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteResponse 投票的结果
+type RequestVoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesRequest leader 复制日志条目，Entries 为空时即为心跳
+type AppendEntriesRequest struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendEntriesResponse 复制日志条目的结果。失败时 NextIndex 提示 leader 下一次从哪里开始发送
+type AppendEntriesResponse struct {
+	Term      uint64
+	Success   bool
+	NextIndex uint64
+}
+
+// InstallSnapshotRequest leader 需要的日志条目已经被快照压缩时，直接发送快照
+type InstallSnapshotRequest struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Data              []byte
+}
+
+// InstallSnapshotResponse 安装快照的结果
+type InstallSnapshotResponse struct {
+	Term uint64
+}
+
+// Handler 处理来自其他节点的 RPC，Node 实现了该接口
+type Handler interface {
+	HandleRequestVote(req *RequestVoteRequest) *RequestVoteResponse
+	HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse
+	HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse
+}
+
+// Transport 节点之间的通信方式。实现需要把请求送达 target 节点的 Handler 并返回其响应，
+// 无法送达时返回错误；调用可能来自多个协程并发进行。
+type Transport interface {
+	RequestVote(target string, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	AppendEntries(target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+}
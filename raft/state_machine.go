@@ -0,0 +1,222 @@
+package raft
+
+import (
+	"archive/tar"
+	bitcask "bitcask-gown"
+	"bitcask-gown/data"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// stateMachine 以 bitcask.DB 作为 Raft 的状态机：已提交的日志条目被应用为 DB 的写入，
+// 快照为数据目录的一份备份，安装快照时用备份之中的文件替换数据目录之中的文件之后重新打开 DB。
+type stateMachine struct {
+	options bitcask.Options
+	db      *bitcask.DB
+}
+
+func openStateMachine(options bitcask.Options) (*stateMachine, error) {
+	sm := &stateMachine{options: options}
+	// 上一次安装快照的过程中崩溃时残留的临时目录
+	if err := os.RemoveAll(sm.stagingDir()); err != nil {
+		return nil, err
+	}
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	sm.db = db
+	return sm, nil
+}
+
+// apply 应用一个命令，单个操作直接写入，多个操作作为一个 WriteBatch 原子地写入
+func (sm *stateMachine) apply(command []byte) error {
+	ops, err := decodeCommand(command)
+	if err != nil {
+		return err
+	}
+
+	if len(ops) == 1 {
+		return sm.applyOp(ops[0])
+	}
+
+	wb := sm.db.NewWriteBatch(bitcask.WriteBatchSetup{
+		MaxBatchNum: uint(len(ops)),
+		SyncWrites:  sm.options.SyncWrites,
+	})
+	for _, o := range ops {
+		switch o.typ {
+		case opPut:
+			err = wb.Put(o.key, o.value)
+		case opDelete:
+			err = wb.Delete(o.key)
+		default:
+			err = ErrInvalidCommand
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+func (sm *stateMachine) applyOp(o op) error {
+	switch o.typ {
+	case opPut:
+		return sm.db.Put(o.key, o.value)
+	case opDelete:
+		return sm.db.Delete(o.key)
+	default:
+		return ErrInvalidCommand
+	}
+}
+
+// snapshot 通过 DB.Backup 将数据目录备份到临时目录，再打包为 tar 格式的快照
+func (sm *stateMachine) snapshot() ([]byte, error) {
+	backupDir, err := os.MkdirTemp("", "bitcask-raft-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(backupDir)
+
+	if err := sm.db.Backup(backupDir); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(backupDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{Name: entry.Name(), Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restore 安装快照：先将快照之中的文件解压到数据目录旁边的临时目录并校验，之后关闭 DB，删除旧的提示文件，
+// 通过重命名将快照之中的文件移入数据目录，最后删除不在快照之中的旧文件并重新打开 DB。
+// 快照无效时数据目录保持不变；替换的过程中失败时 DB 保持关闭，节点重启时会用持久化的快照重新恢复
+func (sm *stateMachine) restore(snapshot []byte) error {
+	stagingDir := sm.stagingDir()
+	defer os.RemoveAll(stagingDir)
+	names, err := sm.extract(snapshot, stagingDir)
+	if err != nil {
+		return err
+	}
+
+	if err := sm.db.Close(); err != nil {
+		return err
+	}
+	// 恢复完成之前状态机不可用，失败时保持不可用
+	sm.db = nil
+
+	entries, err := os.ReadDir(sm.options.DirPath)
+	if err != nil {
+		return err
+	}
+	// 提示文件需要先删除，否则旧的提示文件会与快照之中同 id 的数据文件配对
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			if err := os.Remove(filepath.Join(sm.options.DirPath, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(stagingDir, name), filepath.Join(sm.options.DirPath, name)); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if isDataFile(entry.Name()) && !slices.Contains(names, entry.Name()) {
+			if err := os.Remove(filepath.Join(sm.options.DirPath, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	db, err := bitcask.Open(sm.options)
+	if err != nil {
+		return err
+	}
+	sm.db = db
+	return nil
+}
+
+// extract 将快照之中的文件解压到 dir 并持久化，以只读的方式打开确认其中的记录完整之后，返回这些文件的名称
+func (sm *stateMachine) extract(snapshot []byte, dir string) ([]string, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(snapshot))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 快照之中只有数据目录下的数据文件，拒绝任何其他的路径
+		if header.Name != filepath.Base(header.Name) || !isDataFile(header.Name) || slices.Contains(names, header.Name) {
+			return nil, ErrInvalidSnapshot
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileSync(filepath.Join(dir, header.Name), content); err != nil {
+			return nil, err
+		}
+		names = append(names, header.Name)
+	}
+
+	options := sm.options
+	options.DirPath, options.ReadOnly = dir, true
+	options.Metrics, options.Listener = nil, nil
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return names, db.Close()
+}
+
+// stagingDir 安装快照时解压快照的临时目录，与数据目录位于同一个文件系统，以便通过重命名移入数据目录
+func (sm *stateMachine) stagingDir() string {
+	return filepath.Clean(sm.options.DirPath) + ".restore"
+}
+
+func (sm *stateMachine) close() error {
+	if sm.db == nil {
+		return nil
+	}
+	return sm.db.Close()
+}
+
+func isDataFile(name string) bool {
+	return strings.HasSuffix(name, data.DataFileNameSuffix) || strings.HasSuffix(name, data.BlobFileNameSuffix)
+}
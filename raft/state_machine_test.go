@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"archive/tar"
+	bitcask "bitcask-gown"
+	"bitcask-gown/data"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStateMachine_RestoreRemovesHints ensures restoring a snapshot drops hint files left by the replaced data files.
+func TestStateMachine_RestoreRemovesHints(t *testing.T) {
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	loader, err := bitcask.NewBulkLoader(options)
+	require.NoError(t, err)
+	require.NoError(t, loader.Add([]byte("old"), []byte("v")))
+	require.NoError(t, loader.Finish())
+
+	sm, err := openStateMachine(options)
+	require.NoError(t, err)
+	defer func() { _ = sm.close() }()
+
+	sourceOptions := bitcask.DefaultOptions
+	sourceOptions.DirPath = t.TempDir()
+	source, err := openStateMachine(sourceOptions)
+	require.NoError(t, err)
+	defer func() { _ = source.close() }()
+	require.NoError(t, source.db.Put([]byte("new"), []byte("v")))
+	snapshot, err := source.snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, sm.restore(snapshot))
+	_, err = os.Stat(filepath.Join(options.DirPath, data.FileName(0, data.HintFileNameSuffix)))
+	assert.True(t, os.IsNotExist(err))
+	_, err = sm.db.Get([]byte("old"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	got, err := sm.db.Get([]byte("new"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), got)
+}
+
+// TestStateMachine_RestoreInvalidKeepsData ensures a snapshot that fails validation leaves the existing data in place.
+func TestStateMachine_RestoreInvalidKeepsData(t *testing.T) {
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	sm, err := openStateMachine(options)
+	require.NoError(t, err)
+	defer func() { _ = sm.close() }()
+	require.NoError(t, sm.db.Put([]byte("old"), []byte("v")))
+	snapshot, err := sm.snapshot()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil.data", Mode: 0644}))
+	require.NoError(t, tw.Close())
+
+	// 截断的快照、包含非法路径的快照都不会改动数据目录
+	for _, bad := range [][]byte{snapshot[:512+5], buf.Bytes()} {
+		assert.Error(t, sm.restore(bad))
+		got, err := sm.db.Get([]byte("old"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v"), got)
+		_, err = os.Stat(sm.stagingDir())
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	require.NoError(t, sm.close())
+	sm, err = openStateMachine(options)
+	require.NoError(t, err)
+	got, err := sm.db.Get([]byte("old"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), got)
+}
@@ -0,0 +1,329 @@
+package raft
+
+import (
+	bitcask "bitcask-gown"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storage 持久化 Raft 的状态：任期、投票以及日志条目保存在子目录 log 之中一个开启了 SyncWrites 的 bitcask.DB 之中，
+// 快照的数据保存为单独的文件，DB 之中只记录快照最后一个条目的 index、term 以及数据的 CRC。
+// 每一次修改都在返回之前持久化，节点在此之后才会对外做出承诺（投票、确认复制或者提交）。
+//
+// 被快照压缩或者被替换的条目删除之后仍然占用 DB 的空间，可回收的空间超过 compactSize 并且超过一半时，
+// 将存活的记录写入新的 DB（log.compact），再通过重命名替换 log 目录。
+//
+//	state          -> currentTerm(8) + votedFor
+//	snapshot       -> lastIncludedIndex(8) + lastIncludedTerm(8) + crc(4)，数据位于文件 snapshot-<index>
+//	log + index(8) -> term(8) + type(1) + data，只保存快照之后的条目
+type storage struct {
+	dir         string
+	db          *bitcask.DB
+	compactSize int64 // 触发压缩的可回收空间的下限
+}
+
+// persistentState 重启时从 storage 之中加载的状态
+type persistentState struct {
+	term     uint64
+	votedFor string
+	log      []Entry // log[0] 是快照包含的最后一个条目，与 Node.log 的格式相同
+	snapshot []byte
+}
+
+var (
+	stateKey    = []byte("state")
+	snapshotKey = []byte("snapshot")
+)
+
+const (
+	logKeyPrefix       = "log"
+	snapshotFilePrefix = "snapshot-"
+
+	logDirName         = "log"
+	compactDirName     = "log.compact"
+	oldLogDirName      = "log.old"
+	defaultCompactSize = 4 * 1024 * 1024
+)
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := recoverCompaction(dir); err != nil {
+		return nil, err
+	}
+	db, err := openLogDB(filepath.Join(dir, logDirName), true)
+	if err != nil {
+		return nil, err
+	}
+	return &storage{dir: dir, db: db, compactSize: defaultCompactSize}, nil
+}
+
+func openLogDB(dir string, syncWrites bool) (*bitcask.DB, error) {
+	options := bitcask.DefaultOptions
+	options.DirPath = dir
+	options.SyncWrites = syncWrites
+	return bitcask.Open(options)
+}
+
+// recoverCompaction 处理压缩过程中崩溃留下的目录：log 已经被移走时，log.compact 是完整写入并持久化之后的新 DB，完成替换；
+// 否则 log.compact 可能不完整，直接丢弃
+func recoverCompaction(dir string) error {
+	logDir, compactDir := filepath.Join(dir, logDirName), filepath.Join(dir, compactDirName)
+	if _, err := os.Stat(logDir); os.IsNotExist(err) {
+		if _, err := os.Stat(compactDir); err == nil {
+			if err := os.Rename(compactDir, logDir); err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(compactDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(dir, oldLogDirName))
+}
+
+func logKey(index uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(logKeyPrefix), index)
+}
+
+// load 加载持久化的状态，从未保存过任何状态时返回空的日志
+func (s *storage) load() (*persistentState, error) {
+	st := &persistentState{log: []Entry{{}}}
+
+	value, err := s.db.Get(stateKey)
+	if err == nil {
+		if len(value) < 8 {
+			return nil, ErrCorruptedState
+		}
+		st.term, st.votedFor = binary.BigEndian.Uint64(value), string(value[8:])
+	} else if !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	value, err = s.db.Get(snapshotKey)
+	if err == nil {
+		if len(value) != 20 {
+			return nil, ErrCorruptedState
+		}
+		index, term := binary.BigEndian.Uint64(value), binary.BigEndian.Uint64(value[8:])
+		snapshot, err := os.ReadFile(s.snapshotPath(index))
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(snapshot) != binary.BigEndian.Uint32(value[16:]) {
+			return nil, ErrCorruptedState
+		}
+		st.log[0] = Entry{Index: index, Term: term, Type: EntryNoop}
+		st.snapshot = snapshot
+	} else if !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	// 快照之后的条目是连续的，依次读取直到第一个不存在的条目
+	for index := st.log[0].Index + 1; ; index++ {
+		value, err := s.db.Get(logKey(index))
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(value) < 9 {
+			return nil, ErrCorruptedState
+		}
+		st.log = append(st.log, Entry{
+			Index: index,
+			Term:  binary.BigEndian.Uint64(value),
+			Type:  EntryType(value[8]),
+			Data:  value[9:],
+		})
+	}
+	return st, nil
+}
+
+// empty 是否从未保存过任何状态
+func (st *persistentState) empty() bool {
+	return st.term == 0 && st.votedFor == "" && len(st.log) == 1 && st.log[0].Index == 0
+}
+
+// saveState 保存任期以及投票
+func (s *storage) saveState(term uint64, votedFor string) error {
+	value := binary.BigEndian.AppendUint64(nil, term)
+	return s.db.Put(stateKey, append(value, votedFor...))
+}
+
+// saveEntries 原子地保存追加的条目 entries，并删除 (entries 的末尾, oldLast] 之中被替换掉的旧条目
+func (s *storage) saveEntries(entries []Entry, oldLast uint64) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	newLast := entries[len(entries)-1].Index
+	var stale uint64
+	if oldLast > newLast {
+		stale = oldLast - newLast
+	}
+
+	wb := s.db.NewWriteBatch(bitcask.WriteBatchSetup{MaxBatchNum: uint(uint64(len(entries)) + stale), SyncWrites: true})
+	for _, entry := range entries {
+		value := binary.BigEndian.AppendUint64(nil, entry.Term)
+		value = append(append(value, byte(entry.Type)), entry.Data...)
+		if err := wb.Put(logKey(entry.Index), value); err != nil {
+			return err
+		}
+	}
+	for index := newLast + 1; index <= oldLast; index++ {
+		if err := wb.Delete(logKey(index)); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// saveSnapshot 保存以 (index, term) 为最后一个条目的快照，并原子地删除 [dropFrom, dropTo] 之间已经不再需要的日志条目。
+// 快照文件先于记录它的元数据持久化，崩溃之后最多留下一个没有被引用的快照文件
+func (s *storage) saveSnapshot(index, term uint64, snapshot []byte, dropFrom, dropTo uint64) error {
+	if err := writeFileSync(s.snapshotPath(index), snapshot); err != nil {
+		return err
+	}
+
+	var dropped uint64
+	if dropTo >= dropFrom {
+		dropped = dropTo - dropFrom + 1
+	}
+	wb := s.db.NewWriteBatch(bitcask.WriteBatchSetup{MaxBatchNum: uint(dropped + 1), SyncWrites: true})
+	value := binary.BigEndian.AppendUint64(nil, index)
+	value = binary.BigEndian.AppendUint64(value, term)
+	value = binary.BigEndian.AppendUint32(value, crc32.ChecksumIEEE(snapshot))
+	if err := wb.Put(snapshotKey, value); err != nil {
+		return err
+	}
+	for i := dropFrom; i <= dropTo; i++ {
+		if err := wb.Delete(logKey(i)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	// 旧的快照文件已经不再被引用
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	current := filepath.Base(s.snapshotPath(index))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), snapshotFilePrefix) && entry.Name() != current {
+			_ = os.Remove(filepath.Join(s.dir, entry.Name()))
+		}
+	}
+
+	stat := s.db.Stat()
+	if stat.ReclaimableSize < s.compactSize || stat.ReclaimableSize*2 < stat.DiskSize {
+		return nil
+	}
+	return s.compact(index)
+}
+
+// compact 将存活的记录（状态、快照的元数据以及 snapshotIndex 之后的条目）写入新的 DB 并持久化，随后替换 log 目录
+func (s *storage) compact(snapshotIndex uint64) error {
+	compactDir := filepath.Join(s.dir, compactDirName)
+	if err := os.RemoveAll(compactDir); err != nil {
+		return err
+	}
+	db, err := openLogDB(compactDir, false)
+	if err != nil {
+		return err
+	}
+	// 返回 key 是否存在
+	copyKey := func(key []byte) (bool, error) {
+		value, err := s.db.Get(key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, db.Put(key, value)
+	}
+
+	err = func() error {
+		for _, key := range [][]byte{stateKey, snapshotKey} {
+			if _, err := copyKey(key); err != nil {
+				return err
+			}
+		}
+		for index := snapshotIndex + 1; ; index++ {
+			ok, err := copyKey(logKey(index))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return db.Sync()
+			}
+		}
+	}()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// 关闭旧的 DB 之后依次重命名，崩溃时由 recoverCompaction 完成或者丢弃这次压缩
+	logDir, oldDir := filepath.Join(s.dir, logDirName), filepath.Join(s.dir, oldLogDirName)
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	s.db = nil
+	if err := os.Rename(logDir, oldDir); err != nil {
+		return err
+	}
+	if err := os.Rename(compactDir, logDir); err != nil {
+		return err
+	}
+	if s.db, err = openLogDB(logDir, true); err != nil {
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+func (s *storage) snapshotPath(index uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d", snapshotFilePrefix, index))
+}
+
+func (s *storage) close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// writeFileSync 写入文件并持久化：先写入临时文件，持久化之后再重命名为 name
+func writeFileSync(name string, content []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
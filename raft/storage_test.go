@@ -0,0 +1,58 @@
+package raft
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage_Compact ensures snapshots bound the log DB on disk and the compacted state loads back intact.
+func TestStorage_Compact(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStorage(dir)
+	require.NoError(t, err)
+	s.compactSize = 1024
+
+	var last, snapshotIndex uint64
+	for round := 1; round <= 20; round++ {
+		entries := make([]Entry, 50)
+		for i := range entries {
+			last++
+			entries[i] = Entry{Index: last, Term: uint64(round), Type: EntryCommand, Data: bytes.Repeat([]byte{'x'}, 100)}
+		}
+		require.NoError(t, s.saveEntries(entries, last-50))
+		require.NoError(t, s.saveState(uint64(round), "a"))
+		// 快照包含除了最后 10 个条目之外的所有条目
+		require.NoError(t, s.saveSnapshot(last-10, uint64(round), []byte("snapshot"), snapshotIndex+1, last-10))
+		snapshotIndex = last - 10
+	}
+	// 不压缩时 DB 之中有 1000 个条目，超过 100KB
+	assert.Less(t, s.db.Stat().DiskSize, int64(16*1024))
+
+	check := func(s *storage) {
+		st, err := s.load()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(20), st.term)
+		assert.Equal(t, "a", st.votedFor)
+		assert.Equal(t, []byte("snapshot"), st.snapshot)
+		require.Len(t, st.log, 11)
+		assert.Equal(t, snapshotIndex, st.log[0].Index)
+		assert.Equal(t, last, st.log[10].Index)
+	}
+	check(s)
+	require.NoError(t, s.close())
+
+	// 模拟压缩在替换 log 目录的过程中崩溃：log 已经被移走，log.compact 是完整的新 DB
+	require.NoError(t, os.Rename(filepath.Join(dir, logDirName), filepath.Join(dir, compactDirName)))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, oldLogDirName), 0755))
+	s, err = openStorage(dir)
+	require.NoError(t, err)
+	defer func() { _ = s.close() }()
+	check(s)
+	_, err = os.Stat(filepath.Join(dir, oldLogDirName))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,91 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/utils"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_ReadOnly ensures a read-only DB serves reads, rejects writes and never modifies its files.
+func TestDB_ReadOnly(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4 * 1024
+	setup.ValueThreshold = 128
+	setup.PreallocateDataFile = true
+	db, err := Open(setup)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(i*4)))
+	}
+	require.NoError(t, db.Delete(utils.GetTestKey(0)))
+	lastFile := data.GetDataFileName(setup.DirPath, db.activeFile.FileID, data.DataFileNameSuffix)
+	require.NoError(t, db.Close())
+
+	// 模拟崩溃时被中断的写入，只读模式下不会被截断
+	f, err := os.OpenFile(lastFile, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before := snapshotDir(t, setup.DirPath)
+
+	setup.ReadOnly = true
+	reader1, err := Open(setup)
+	require.NoError(t, err)
+	reader2, err := Open(setup)
+	require.NoError(t, err)
+
+	for _, reader := range []*DB{reader1, reader2} {
+		_, err := reader.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		got, err := reader.Get(utils.GetTestKey(99))
+		require.NoError(t, err)
+		assert.Len(t, got, len("bitcask-go-value-")+99*4)
+
+		assert.Equal(t, ErrReadOnly, reader.Put([]byte("k"), []byte("v")))
+		assert.Equal(t, ErrReadOnly, reader.Delete(utils.GetTestKey(1)))
+		assert.Equal(t, ErrReadOnly, reader.PutStream([]byte("k"), bytes.NewReader([]byte("v")), 1))
+		assert.Equal(t, ErrReadOnly, reader.RunBlobGC(0.5))
+		assert.Equal(t, ErrReadOnly, reader.Sync())
+		wb := reader.NewWriteBatch(DefaultWriteBatchSetup)
+		assert.Equal(t, ErrReadOnly, wb.Put([]byte("k"), []byte("v")))
+		assert.Equal(t, ErrReadOnly, wb.Delete(utils.GetTestKey(1)))
+		assert.Equal(t, ErrReadOnly, wb.Commit())
+	}
+	require.NoError(t, reader1.Close())
+	require.NoError(t, reader2.Close())
+	assert.Equal(t, before, snapshotDir(t, setup.DirPath))
+
+	// 只读模式下不会创建不存在的目录
+	setup.DirPath = filepath.Join(t.TempDir(), "missing")
+	_, err = Open(setup)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(setup.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	setup.ValueThreshold = 0
+	setup.Replica = true
+	_, err = Open(setup)
+	assert.Equal(t, ErrInvalidReadOnly, err)
+}
+
+// snapshotDir returns the name and content of every file in dir.
+func snapshotDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = content
+	}
+	return files
+}
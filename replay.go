@@ -0,0 +1,137 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/index"
+)
+
+// logReplayer 按照写入的顺序重放数据文件之中的记录来更新索引，事务的记录会被暂存，直到读到其完成标记。
+// Open 时由 loadIndex 使用；从库接收到主库的数据之后，也使用它增量地更新索引。
+type logReplayer struct {
+	db *DB
+	// 暂存事务的映射，即事务号 -> 事务 （logRecord 形成的数组）
+	txnBuf          map[uint64][]*data.TxnLogRecord
+	newestSeqNumber uint64
+	// 不为 nil 时，记录默认 bucket 之中最新的记录是合并操作数的 key
+	mergeHeads map[string]struct{}
+}
+
+func newLogReplayer(db *DB) *logReplayer {
+	return &logReplayer{
+		db:     db,
+		txnBuf: make(map[uint64][]*data.TxnLogRecord),
+	}
+}
+
+// replay 重放位于 pos 的一条记录，调用方需要持有 db.lock（Open 期间除外）
+func (r *logReplayer) replay(record *data.LogRecord, pos *data.LogRecordPos) error {
+	// 解析 logRecord.Key，获取 realKey、seqNumber
+	realKey, seqNumber := parseLogRecordKey(record.Key)
+
+	// 根据 seqNumber，如果不是事务，则立即更新内存索引
+	if seqNumber == nonTxnSeqNumber {
+		if err := r.updateIndex(record, realKey, pos); err != nil {
+			return err
+		}
+	} else {
+		// 如果是事务的话...即读取到了事务结束的标志，则将暂存的记录统一更新到索引
+		if record.Type == data.LogRecordTxnFinished {
+			r.db.reclaimSize += pos.Size // 事务完成的标记本身不再需要
+			for _, txnRec := range r.txnBuf[seqNumber] {
+				if err := r.updateIndex(txnRec.Record, txnRec.Record.Key, txnRec.Pos); err != nil {
+					return err
+				}
+			}
+			delete(r.txnBuf, seqNumber) // 写入完毕后，执行删除操作
+		} else {
+			// 反之，如果没有读到事务结束标记，则将其记录到我们的 txnBuf 之中
+			record.Key = realKey
+			r.txnBuf[seqNumber] = append(r.txnBuf[seqNumber], &data.TxnLogRecord{
+				Record: record,
+				Pos:    pos,
+			})
+		}
+	}
+
+	// 更新序列号
+	if seqNumber > r.newestSeqNumber {
+		r.newestSeqNumber = seqNumber
+	}
+	return nil
+}
+
+// replayHint 重放提示文件之中的一条提示，提示对应的都是默认 bucket 之中不属于事务的普通记录
+func (r *logReplayer) replayHint(key []byte, pos *data.LogRecordPos) error {
+	return r.updateIndex(&data.LogRecord{Type: data.LogRecordNormal}, key, pos)
+}
+
+// updateIndex 更新记录所属 bucket 的内存索引，realKey 为去掉序列号之后的 key
+func (r *logReplayer) updateIndex(record *data.LogRecord, realKey []byte, pos *data.LogRecordPos) error {
+	typ, bucketID := record.Type, record.Bucket
+	switch typ {
+	case data.LogRecordBucketCreate:
+		r.db.addBucket(&bucket{id: bucketID, name: string(realKey), index: index.NewBTree()})
+		return nil
+	case data.LogRecordBucketDrop:
+		r.db.reclaimSize += pos.Size
+		if bkt := r.db.bucketIDs[bucketID]; bkt != nil {
+			r.db.removeBucket(bkt)
+		}
+		return nil
+	}
+
+	// bucket 已经被删除，其中的记录全部失效
+	bkt := r.db.bucketIDs[bucketID]
+	if bkt == nil {
+		r.db.reclaimSize += pos.Size
+		return nil
+	}
+	if r.mergeHeads != nil && bkt == r.db.defaultBucket {
+		if typ == data.LogRecordRangeDelete {
+			r.forgetMergeHeads(realKey, rangeEnd(record.Value))
+		} else if typ == data.LogRecordMerge {
+			r.mergeHeads[string(realKey)] = struct{}{}
+		} else {
+			delete(r.mergeHeads, string(realKey))
+		}
+	}
+
+	switch typ {
+	case data.LogRecordToDelete:
+		// 删除一个索引中已不存在的 key 是正常情况（例如事务中先写后删），不视为错误
+		r.db.indexDelete(bkt.index, realKey, pos)
+		return nil
+	case data.LogRecordMerge:
+		return r.db.indexMerge(bkt.index, realKey, pos)
+	case data.LogRecordRangeDelete:
+		// 范围墓碑只影响在它之前写入的 key，之后写入的记录会按照顺序重新加入索引
+		r.db.indexDeleteRange(bkt.index, realKey, rangeEnd(record.Value), pos)
+		return nil
+	}
+	return r.db.indexPut(bkt.index, realKey, pos)
+}
+
+// forgetMergeHeads 范围墓碑删除了 [start, end) 范围内的 key，它们不再是操作数链
+func (r *logReplayer) forgetMergeHeads(start, end []byte) {
+	for key := range r.mergeHeads {
+		if keyInRange([]byte(key), start, end) {
+			delete(r.mergeHeads, key)
+		}
+	}
+}
+
+// discardUnfinished 丢弃所有没有完成标记的事务，它们永远不会生效，其记录全部可以回收
+func (r *logReplayer) discardUnfinished() {
+	if len(r.txnBuf) == 0 {
+		return
+	}
+	var discardedRecords int
+	for _, txnRecs := range r.txnBuf {
+		for _, txnRec := range txnRecs {
+			r.db.reclaimSize += txnRec.Pos.Size
+		}
+		discardedRecords += len(txnRecs)
+	}
+	r.db.logger.Warn("discarded unfinished transactions", "txns", len(r.txnBuf), "records", discardedRecords)
+	r.txnBuf = make(map[uint64][]*data.TxnLogRecord)
+}
@@ -0,0 +1,262 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// 复制协议：从库连接之后先发送自己当前的位置 fileId(4) + offset(8)，之后主库持续发送数据帧
+// fileId(4) + offset(8) + length(4) + 数据文件之中从 offset 开始的 length 个原始字节。
+// 从库按照主库的文件边界写入相同的数据文件，因此断开之后可以从自己的活跃文件末尾继续复制。
+const (
+	replicaPositionSize  = 4 + 8
+	replicationFrameHead = 4 + 8 + 4
+	replicationChunkSize = 64 * 1024
+)
+
+// appendSignal 数据文件有新数据写入时，唤醒所有等待的复制协程
+type appendSignal struct {
+	lock *sync.Mutex
+	ch   chan struct{}
+}
+
+func newAppendSignal() *appendSignal {
+	return &appendSignal{lock: new(sync.Mutex)}
+}
+
+// wait 返回在下一次写入时被关闭的 channel
+func (s *appendSignal) wait() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+// notify 唤醒所有等待者，没有等待者时什么也不做
+func (s *appendSignal) notify() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// ServeReplica 作为主库为一个从库提供日志复制：先从 conn 读取从库当前的位置，之后把从该位置开始的数据文件内容持续发送给从库，
+// 没有新数据时等待新的写入，直到 conn 出错或者数据库被关闭。每个从库使用独立的 conn，并在独立的协程之中调用。
+func (db *DB) ServeReplica(conn io.ReadWriter) error {
+	if db.option.ValueThreshold > 0 {
+		return ErrReplicationUnsupported
+	}
+
+	posBuf := make([]byte, replicaPositionSize)
+	if _, err := io.ReadFull(conn, posBuf); err != nil {
+		return err
+	}
+	fid := binary.LittleEndian.Uint32(posBuf)
+	offset := int64(binary.LittleEndian.Uint64(posBuf[4:]))
+	db.logger.Info("replica connected", "file", fid, "offset", offset)
+
+	for {
+		select {
+		case <-db.syncClose:
+			return nil
+		default:
+		}
+
+		chunk, nextFile, wait, err := db.readReplicationChunk(fid, offset)
+		if err != nil {
+			db.logger.Error("serve replica failed", "file", fid, "offset", offset, "err", err)
+			return err
+		}
+		switch {
+		case len(chunk) > 0:
+			if err := writeReplicationFrame(conn, fid, offset, chunk); err != nil {
+				return err
+			}
+			offset += int64(len(chunk))
+		case nextFile:
+			fid, offset = fid+1, 0
+		default:
+			select {
+			case <-wait:
+			case <-db.syncClose:
+				return nil
+			}
+		}
+	}
+}
+
+// readReplicationChunk 读取数据文件 fid 之中从 offset 开始的一段数据。该文件已经发送完毕并且不是活跃文件时 nextFile 为 true；
+// 没有新数据时返回的 wait 会在下一次写入时被关闭。写入在持有 db.lock 期间完成（包括失败时的回滚），因此这里读到的都是完整的写入。
+func (db *DB) readReplicationChunk(fid uint32, offset int64) (chunk []byte, nextFile bool, wait <-chan struct{}, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	wait = db.appended.wait()
+	if db.activeFile == nil {
+		if fid != 0 || offset != 0 {
+			return nil, false, nil, ErrReplicaDiverged
+		}
+		return nil, false, wait, nil
+	}
+	if fid > db.activeFile.FileID {
+		return nil, false, nil, ErrReplicaDiverged
+	}
+	// 流式写入的文件完成之前，从库需要等待，之后的文件依赖其中的记录
+	if _, ok := db.streaming[fid]; ok {
+		return nil, false, wait, nil
+	}
+
+	dataFile, err := db.getDataFile(fid)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	end := dataFile.WriteOff
+	if offset > end {
+		return nil, false, nil, ErrReplicaDiverged
+	}
+	if offset == end {
+		return nil, dataFile != db.activeFile, wait, nil
+	}
+
+	chunk = make([]byte, min(end-offset, replicationChunkSize))
+	if _, err := dataFile.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, nil, err
+	}
+	return chunk, false, nil, nil
+}
+
+func writeReplicationFrame(w io.Writer, fid uint32, offset int64, chunk []byte) error {
+	buf := make([]byte, replicationFrameHead+len(chunk))
+	binary.LittleEndian.PutUint32(buf, fid)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(offset))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(chunk)))
+	copy(buf[replicationFrameHead:], chunk)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReplicateFrom 作为从库通过 conn 从主库复制数据：先发送自己当前的位置，之后持续接收主库发送的数据，写入数据文件并增量更新索引，
+// 直到 conn 出错或者被关闭（此时返回 nil）。断开之后使用新的连接再次调用，即可从断开的位置继续复制。
+// 只有以 Options.Replica 打开的数据库可以调用，复制期间可以正常地并发读取。
+func (db *DB) ReplicateFrom(conn io.ReadWriter) error {
+	if !db.option.Replica {
+		return ErrNotReplica
+	}
+
+	db.lock.RLock()
+	fid, offset := db.activeFilePos()
+	db.lock.RUnlock()
+
+	posBuf := make([]byte, replicaPositionSize)
+	binary.LittleEndian.PutUint32(posBuf, fid)
+	binary.LittleEndian.PutUint64(posBuf[4:], uint64(offset))
+	if _, err := conn.Write(posBuf); err != nil {
+		return err
+	}
+	db.logger.Info("replicating from leader", "file", fid, "offset", offset)
+
+	head := make([]byte, replicationFrameHead)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		fid := binary.LittleEndian.Uint32(head)
+		offset := int64(binary.LittleEndian.Uint64(head[4:]))
+		chunk := make([]byte, binary.LittleEndian.Uint32(head[12:]))
+		if _, err := io.ReadFull(conn, chunk); err != nil {
+			return err
+		}
+		if err := db.applyReplicationFrame(fid, offset, chunk); err != nil {
+			db.logger.Error("apply replicated data failed", "file", fid, "offset", offset, "err", err)
+			return err
+		}
+	}
+}
+
+// applyReplicationFrame 将主库数据文件 fid 之中 offset 处的数据写入本地对应的数据文件，并重放其中完整的记录
+func (db *DB) applyReplicationFrame(fid uint32, offset int64, chunk []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.activeFile == nil || fid != db.activeFile.FileID {
+		if err := db.switchReplicaFile(fid, offset); err != nil {
+			return err
+		}
+	}
+	if offset != db.activeFile.WriteOff {
+		return ErrReplicaDiverged
+	}
+
+	if err := db.activeFile.Write(chunk); err != nil {
+		db.rollbackActiveFile(fid, offset)
+		return err
+	}
+	db.addBytesWritten(int64(len(chunk)))
+	if db.option.SyncWrites {
+		if err := db.syncActiveFiles(); err != nil {
+			return err
+		}
+	}
+	return db.replayActiveFile()
+}
+
+// switchReplicaFile 主库切换到了新的活跃文件，本地同样切换；旧的活跃文件此时已经完整，其中的记录全部重放完毕
+func (db *DB) switchReplicaFile(fid uint32, offset int64) error {
+	if offset != 0 || (db.activeFile != nil && fid <= db.activeFile.FileID) {
+		return ErrReplicaDiverged
+	}
+
+	if db.activeFile != nil {
+		if err := db.replayActiveFile(); err != nil {
+			return err
+		}
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		db.oldFiles[db.activeFile.FileID] = db.activeFile
+	}
+
+	dataFile, err := data.OpenDataFileWithOptions(db.option.DirPath, fid, db.dataFileOptions())
+	if err != nil {
+		return err
+	}
+	db.activeFile = dataFile
+	db.replayOff = 0
+	db.logger.Info("replica switched active file", "file", fid)
+	return nil
+}
+
+// replayActiveFile 从上次重放到的位置开始，重放活跃文件之中所有完整的记录；末尾不完整的记录等待之后的数据到达后再重放
+func (db *DB) replayActiveFile() error {
+	for {
+		record, size, err := db.activeFile.ReadLogRecord(db.replayOff)
+		if err != nil {
+			if err == io.EOF || err == data.ErrIncompleteLogRecord {
+				break
+			}
+			if err == data.ErrInvalidCRC {
+				db.listener.OnCorruption(CorruptionInfo{FileID: db.activeFile.FileID, Offset: db.replayOff, Err: err})
+			}
+			return err
+		}
+		pos := &data.LogRecordPos{Fid: db.activeFile.FileID, Offset: db.replayOff, Size: size}
+		if err := db.replayer.replay(record, pos); err != nil {
+			return err
+		}
+		db.replayOff += size
+	}
+	db.seqNumber = db.replayer.newestSeqNumber
+	return nil
+}
@@ -0,0 +1,137 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/utils"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startReplication connects follower to leader over an in-process pipe and returns a function that disconnects them.
+func startReplication(t *testing.T, leader, follower *DB) func() {
+	t.Helper()
+	leaderConn, followerConn := net.Pipe()
+	followerDone := make(chan error, 1)
+	go func() { _ = leader.ServeReplica(leaderConn) }()
+	go func() { followerDone <- follower.ReplicateFrom(followerConn) }()
+
+	return func() {
+		// 主库一侧断开，从库读到 EOF 后正常返回；主库的协程在下一次写入或者关闭时退出
+		require.NoError(t, leaderConn.Close())
+		require.NoError(t, <-followerDone)
+		_ = followerConn.Close()
+	}
+}
+
+// waitReplicated waits until the follower serves value for key.
+func waitReplicated(t *testing.T, follower *DB, key, value []byte) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		got, err := follower.Get(key)
+		return err == nil && bytes.Equal(got, value)
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+// TestDB_Replication ensures a replica follows puts, deletes, batches and file rotation, and rejects writes.
+func TestDB_Replication(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 32 * 1024
+	leader, cleanup := newDB(t, setup)
+	defer cleanup()
+	setup.Replica = true
+	follower, cleanupFollower := newDB(t, setup)
+	defer cleanupFollower()
+
+	stop := startReplication(t, leader, follower)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, leader.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	wb := leader.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put([]byte("batch-1"), []byte("v1")))
+	require.NoError(t, wb.Put([]byte("batch-2"), []byte("v2")))
+	require.NoError(t, wb.Commit())
+	// 超过单帧大小的 value，从库会先收到不完整的记录
+	large := utils.RandomValue(100 * 1024)
+	require.NoError(t, leader.Put([]byte("large"), large))
+	require.NoError(t, leader.Put([]byte("last"), []byte("value")))
+
+	waitReplicated(t, follower, []byte("last"), []byte("value"))
+	assert.Greater(t, len(leader.oldFiles), 1)
+	assert.Equal(t, leader.index.Size(), follower.index.Size())
+	assert.Equal(t, leader.seqNumber, follower.seqNumber)
+	it := leader.index.Iterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Key()
+		want, err := leader.Get(key)
+		require.NoError(t, err)
+		got, err := follower.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := follower.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Equal(t, ErrReplicaReadOnly, follower.Put([]byte("k"), []byte("v")))
+	assert.Equal(t, ErrReplicaReadOnly, follower.Delete([]byte("last")))
+	wb = follower.NewWriteBatch(DefaultWriteBatchSetup)
+	assert.Equal(t, ErrReplicaReadOnly, wb.Put([]byte("k"), []byte("v")))
+	assert.Equal(t, ErrReplicaReadOnly, wb.Commit())
+	assert.Equal(t, ErrNotReplica, leader.ReplicateFrom(&bytes.Buffer{}))
+	stop()
+}
+
+// TestDB_ReplicationCatchUp ensures a replica resumes from its own position after a disconnect and a restart.
+func TestDB_ReplicationCatchUp(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 16 * 1024
+	leader, cleanup := newDB(t, setup)
+	defer cleanup()
+	setup.Replica = true
+	follower, cleanupFollower := newDB(t, setup)
+	defer func() { cleanupFollower() }()
+
+	stop := startReplication(t, leader, follower)
+	require.NoError(t, leader.Put([]byte("before"), []byte("1")))
+	waitReplicated(t, follower, []byte("before"), []byte("1"))
+	stop()
+
+	// 断开期间主库继续写入并切换了多个活跃文件
+	for i := 0; i < 300; i++ {
+		require.NoError(t, leader.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	require.NoError(t, leader.Put([]byte("during"), []byte("2")))
+	_, err := follower.Get([]byte("during"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	stop = startReplication(t, leader, follower)
+	waitReplicated(t, follower, []byte("during"), []byte("2"))
+	stop()
+
+	// 从库重启之后，同样从本地数据文件的末尾继续复制
+	follower = reopenDB(t, follower)
+	cleanupFollower = func() { destroyDB(follower) }
+	got, err := follower.Get(utils.GetTestKey(299))
+	require.NoError(t, err)
+	want, _ := leader.Get(utils.GetTestKey(299))
+	assert.Equal(t, want, got)
+
+	wb := leader.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put([]byte("after"), []byte("3")))
+	require.NoError(t, wb.Delete([]byte("before")))
+	require.NoError(t, wb.Commit())
+
+	stop = startReplication(t, leader, follower)
+	waitReplicated(t, follower, []byte("after"), []byte("3"))
+	_, err = follower.Get([]byte("before"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, leader.index.Size(), follower.index.Size())
+	stop()
+}
@@ -0,0 +1,87 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/index"
+)
+
+// Stat 数据库的统计信息
+type Stat struct {
+	KeyNum          int   // 所有 bucket 之中 key 的数量
+	DataFileNum     int   // 数据文件的数量
+	DiskSize        int64 // 所有数据文件的数据总大小（字节）
+	ReclaimableSize int64 // 数据文件之中已经失效、可以被回收的字节数的估计值
+}
+
+// Stat 返回数据库的统计信息。可回收的空间在写入时增量维护，调用开销很小，适合被监控频繁调用；
+// 它只统计数据文件，键值分离模式下 blob 文件之中的空间由 RunBlobGC 负责回收。
+func (db *DB) Stat() *Stat {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	stat := &Stat{
+		KeyNum:          db.keyNum(),
+		DataFileNum:     len(db.oldFiles),
+		ReclaimableSize: db.reclaimSize,
+	}
+	for _, dataFile := range db.oldFiles {
+		stat.DiskSize += dataFile.WriteOff
+	}
+	if db.activeFile != nil {
+		stat.DataFileNum++
+		stat.DiskSize += db.activeFile.WriteOff
+	}
+	return stat
+}
+
+// indexPut 更新 key 在索引 idx 之中的位置，被覆盖的旧记录计入可回收的空间；调用方需要持有 db.lock
+func (db *DB) indexPut(idx index.Indexer, key []byte, pos *data.LogRecordPos) error {
+	oldPos, exist := idx.Get(key)
+	if ok := idx.Put(key, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+	if exist {
+		db.reclaimSize += liveSize(oldPos)
+	}
+	return nil
+}
+
+// indexDeleteRange 从索引 idx 之中删除 [start, end) 范围内的所有 key，被删除的记录以及范围墓碑 tombstone 本身都计入可回收的空间；
+// 调用方需要持有 db.lock
+func (db *DB) indexDeleteRange(idx index.Indexer, start, end []byte, tombstone *data.LogRecordPos) int {
+	db.reclaimSize += tombstone.Size
+	removed := idx.DeleteRange(start, end)
+	for _, pos := range removed {
+		db.reclaimSize += liveSize(pos)
+	}
+	return len(removed)
+}
+
+// indexMerge 将合并操作数记录 pos 作为 key 新的链头。此前的记录仍然是链的一部分，不计入可回收的空间，
+// 而是累加到链头的 ChainSize 之中，链被覆盖或者删除时整体回收；调用方需要持有 db.lock
+func (db *DB) indexMerge(idx index.Indexer, key []byte, pos *data.LogRecordPos) error {
+	head := *pos
+	if oldPos, exist := idx.Get(key); exist {
+		head.ChainSize = liveSize(oldPos)
+	}
+	if ok := idx.Put(key, &head); !ok {
+		return ErrIndexUpdateFailed
+	}
+	return nil
+}
+
+// indexDelete 从索引 idx 之中删除 key，被删除的旧记录以及墓碑记录 tombstone 本身都计入可回收的空间；调用方需要持有 db.lock
+func (db *DB) indexDelete(idx index.Indexer, key []byte, tombstone *data.LogRecordPos) bool {
+	oldPos, exist := idx.Get(key)
+	db.reclaimSize += tombstone.Size
+	if !exist {
+		return false
+	}
+	db.reclaimSize += liveSize(oldPos)
+	return idx.Delete(key)
+}
+
+// liveSize 索引之中的 pos 被覆盖或者删除时可以回收的字节数，合并操作数链的链头包括整条链
+func liveSize(pos *data.LogRecordPos) int64 {
+	return pos.Size + pos.ChainSize
+}
@@ -0,0 +1,83 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bitcask-gown/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_Stat ensures Stat tracks keys, files and reclaimable bytes, and that reopening rebuilds the same numbers.
+func TestDB_Stat(t *testing.T) {
+	setup := DefaultOptions
+	setup.DataFileSize = 512
+	db, cleanup := newDB(t, setup)
+	defer func() { cleanup() }()
+
+	stat := db.Stat()
+	assert.Equal(t, &Stat{}, stat)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	stat = db.Stat()
+	assert.Equal(t, 20, stat.KeyNum)
+	assert.Greater(t, stat.DataFileNum, 1)
+	assert.Equal(t, int64(0), stat.ReclaimableSize)
+
+	// 覆盖写入：旧记录可回收
+	oldPos, _ := db.index.Get(utils.GetTestKey(0))
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(16)))
+	assert.Equal(t, oldPos.Size, db.Stat().ReclaimableSize)
+
+	// 删除：旧记录以及墓碑记录都可回收
+	oldPos2, _ := db.index.Get(utils.GetTestKey(1))
+	require.NoError(t, db.Delete(utils.GetTestKey(1)))
+	_, tombSize := data.EncodeLogRecord(&data.LogRecord{Key: addSeqToKey(utils.GetTestKey(1), nonTxnSeqNumber), Type: data.LogRecordToDelete})
+	assert.Equal(t, oldPos.Size+oldPos2.Size+tombSize, db.Stat().ReclaimableSize)
+
+	// 事务：被覆盖的记录以及完成标记可回收
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put(utils.GetTestKey(2), utils.RandomValue(16)))
+	require.NoError(t, wb.Commit())
+
+	stat = db.Stat()
+	assert.Equal(t, 19, stat.KeyNum)
+	assert.Greater(t, stat.ReclaimableSize, oldPos.Size+oldPos2.Size+tombSize)
+
+	var diskSize int64
+	for _, dataFile := range db.oldFiles {
+		diskSize += dataFile.WriteOff
+	}
+	assert.Equal(t, diskSize+db.activeFile.WriteOff, stat.DiskSize)
+
+	db = reopenDB(t, db)
+	assert.Equal(t, stat, db.Stat())
+}
+
+// TestDB_StatMergeChain ensures a merge chain head keeps its own record size and overwriting it reclaims the whole chain.
+func TestDB_StatMergeChain(t *testing.T) {
+	setup := DefaultOptions
+	setup.MergeOperator = Int64AddOperator{}
+	db, cleanup := newDB(t, setup)
+	defer func() { cleanup() }()
+
+	key := []byte("counter")
+	var chainSize int64
+	for i := 0; i < 3; i++ {
+		before := db.Stat().DiskSize
+		require.NoError(t, db.MergeValue(key, EncodeInt64(1)))
+		pos, ok := db.index.Get(key)
+		require.True(t, ok)
+		// 链头的 Size 只是最新的一条操作数记录
+		assert.Equal(t, db.Stat().DiskSize-before, pos.Size)
+		assert.Equal(t, chainSize, pos.ChainSize)
+		chainSize += pos.Size
+	}
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	require.NoError(t, db.Put(key, EncodeInt64(0)))
+	assert.Equal(t, chainSize, db.Stat().ReclaimableSize)
+}
@@ -0,0 +1,173 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// PutStream 以流的方式写入 key 对应的 value，value 从 r 之中读取 size 个字节，分块写入，不会整体读入内存。
+// value 写入单独预留的数据文件，写入期间不持有 db.lock，不会阻塞其他的读写；写入完成之后才登记为旧文件并更新索引。
+// 如果 r 提前结束或者出错，则返回对应错误，且这条记录不会生效。
+func (db *DB) PutStream(key []byte, r io.Reader, size int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	streamFile, seqNumber, err := db.reserveStreamFile()
+	if err != nil {
+		return err
+	}
+
+	// 流式写入可能在中途因为 r 出错而失败，此时已经有部分数据落盘。因此借用事务的机制：
+	// 只有在后面追加了事务完成的标记，这条记录才会在 loadIndex 之中生效。
+	recSize, err := streamFile.WriteStream(addSeqToKey(key, seqNumber), r, size)
+	if err == nil {
+		// 登记之后它就是旧文件，不会再随活跃文件一起持久化
+		err = streamFile.Sync()
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.finishStreamFile(streamFile, err == nil); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	db.addBytesWritten(recSize)
+	pos := &data.LogRecordPos{
+		Fid:    streamFile.FileID,
+		Offset: 0,
+		Size:   recSize,
+	}
+
+	// 写入事务完成的标记，SyncWrites 的持久化也在这里完成
+	finRec := &data.LogRecord{
+		Key:  addSeqToKey([]byte(txnFinKey), seqNumber),
+		Type: data.LogRecordTxnFinished,
+	}
+	finPos, err := db.appendLogRecord(finRec)
+	if err != nil {
+		db.reclaimSize += recSize // 没有完成标记，这条记录不会生效
+		return err
+	}
+
+	db.reclaimSize += finPos.Size // 事务完成的标记本身不再需要
+	if err := db.indexPut(db.index, key, pos); err != nil {
+		return err
+	}
+	db.notifyWatchers(EventPut, key, nil, seqNumber, pos)
+	return nil
+}
+
+// reserveStreamFile 在当前活跃文件之后为流式写入预留一个数据文件 id，并将活跃文件切换到它之后，返回打开的流式写入文件以及分配的序列号
+func (db *DB) reserveStreamFile() (*data.DataFile, uint64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.syncErr != nil {
+		return nil, 0, db.syncErr
+	}
+	if db.activeFile == nil {
+		if err := db.createActiveFile(0); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	fileID := db.activeFile.FileID + 1
+	streamFile, err := data.OpenStreamFile(db.option.DirPath, fileID, db.oldFileOptions())
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := db.switchActiveFile(fileID + 1); err != nil {
+		_ = streamFile.Close()
+		_ = db.removeFile(data.FileName(fileID, data.StreamFileNameSuffix))
+		return nil, 0, err
+	}
+	db.streaming[fileID] = struct{}{}
+	return streamFile, atomic.AddUint64(&db.seqNumber, 1), nil
+}
+
+// finishStreamFile 结束流式写入，需要持有 db.lock。写入成功时将文件重命名为数据文件并登记为旧文件；
+// 失败时删除它，并在原位置创建一个空的数据文件，保持数据文件的 id 连续
+func (db *DB) finishStreamFile(streamFile *data.DataFile, ok bool) error {
+	fileID := streamFile.FileID
+	delete(db.streaming, fileID)
+	// 空数据文件或者完整的数据文件登记之后，等待这个文件的从库可以继续复制
+	defer db.appended.notify()
+
+	closeErr := streamFile.Close()
+	select {
+	case <-db.syncClose:
+		// 数据库已经关闭，流式写入文件留待下一次打开时清理
+		return ErrDatabaseClosed
+	default:
+	}
+
+	// 文件登记失败会在数据文件的 id 之中留下空缺，之后的写入都返回该错误，下一次打开时再清理
+	fail := func(err error) error {
+		if db.syncErr == nil {
+			db.logger.Error("register stream file failed, rejecting further writes", "file", fileID, "err", err)
+			db.syncErr = err
+		}
+		return err
+	}
+	streamName := db.fs.Join(db.option.DirPath, data.FileName(fileID, data.StreamFileNameSuffix))
+	if ok && closeErr == nil {
+		dataName := db.fs.Join(db.option.DirPath, data.FileName(fileID, data.DataFileNameSuffix))
+		if err := db.fs.Rename(streamName, dataName); err != nil {
+			return fail(err)
+		}
+	} else if err := db.fs.Remove(streamName); err != nil {
+		return fail(err)
+	}
+
+	dataFile, err := data.OpenDataFileWithOptions(db.option.DirPath, fileID, db.oldFileOptions())
+	if err != nil {
+		return fail(err)
+	}
+	db.oldFiles[fileID] = dataFile
+	return closeErr
+}
+
+// GetReader 返回 key 对应 value 的读取器。对于 PutStream 写入的数据，value 会按块从数据文件之中读取，
+// CRC 在读取过程中增量校验，读到末尾时若校验失败则返回 data.ErrInvalidCRC。
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	pos, ok := db.index.Get(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	dataFile, err := db.getDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, typ, err := dataFile.NewValueReader(pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if typ == data.LogRecordToDelete {
+		_ = reader.Close()
+		return nil, ErrKeyNotFound
+	}
+	// 合并操作数需要读取整条链之后合并，键值分离的记录需要到 blob 文件之中读取 value，二者都无法直接流式读取
+	if typ == data.LogRecordMerge || typ == data.LogRecordBlobPtr {
+		_ = reader.Close()
+		value, err := db.getValueByPos(pos)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(value)), nil
+	}
+	return reader, nil
+}
@@ -0,0 +1,238 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_PutStreamAndGetReader covers streaming a value in and out, and Get on a streamed value.
+func TestDB_PutStreamAndGetReader(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	key := []byte("artifact")
+	value := bytes.Repeat([]byte("0123456789"), 50000)
+	require.NoError(t, db.PutStream(key, bytes.NewReader(value), int64(len(value))))
+
+	reader, err := db.GetReader(key)
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, value, got)
+
+	got, err = db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	// 普通写入的数据同样可以通过 GetReader 读取
+	require.NoError(t, db.Put([]byte("small"), []byte("v")))
+	reader, err = db.GetReader([]byte("small"))
+	require.NoError(t, err)
+	got, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), got)
+
+	_, err = db.GetReader([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// TestDB_PutStreamShortReader ensures a failed stream neither updates the index nor survives a restart.
+func TestDB_PutStreamShortReader(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	key := []byte("artifact")
+	require.NoError(t, db.Put(key, []byte("old")))
+	err = db.PutStream(key, bytes.NewReader([]byte("abc")), 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	got, err := db.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), got)
+	require.NoError(t, db.Close())
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(reopened)
+	got, err = reopened.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), got)
+}
+
+// TestDB_PutStreamRestart ensures streamed values are replayed by loadIndex.
+func TestDB_PutStreamRestart(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	setup.DataFileSize = 4096
+	db, err := Open(setup)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("x"), 10000) // 超过单个数据文件的大小
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.PutStream([]byte("b"), bytes.NewReader(value), int64(len(value))))
+	require.NoError(t, db.Put([]byte("c"), []byte("3")))
+	require.NoError(t, db.Close())
+
+	reopened, err := Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(reopened)
+
+	got, err := reopened.Get([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	got, err = reopened.Get([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), got)
+	assert.Greater(t, reopened.seqNumber, uint64(0))
+}
+
+// blockingReader returns the first chunk of its data, then blocks until release is closed.
+type blockingReader struct {
+	data    []byte
+	sent    bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		close(r.started)
+		n := copy(p, r.data[:len(r.data)/2])
+		r.data = r.data[n:]
+		return n, nil
+	}
+	<-r.release
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// TestDB_PutStreamDoesNotBlockWriters ensures reads and writes complete while a stream upload is stalled.
+func TestDB_PutStreamDoesNotBlockWriters(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	value := bytes.Repeat([]byte("s"), 64*1024)
+	r := &blockingReader{data: value, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- db.PutStream([]byte("artifact"), r, int64(len(value))) }()
+	<-r.started
+
+	// 上传停顿期间，其他的读写不受影响，未完成的 value 不可见
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	got, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), got)
+	_, err = db.Get([]byte("artifact"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	close(r.release)
+	require.NoError(t, <-done)
+	got, err = db.Get([]byte("artifact"))
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	reopened := reopenDB(t, db)
+	defer destroyDB(reopened)
+	got, err = reopened.Get([]byte("artifact"))
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	got, err = reopened.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), got)
+}
+
+// TestDB_PutStreamFailureKeepsFileIDs ensures a failed stream leaves an empty data file in its reserved slot.
+func TestDB_PutStreamFailureKeepsFileIDs(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	err := db.PutStream([]byte("b"), bytes.NewReader([]byte("abc")), 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	require.NoError(t, db.Put([]byte("c"), []byte("3")))
+
+	fileIds, err := db.listFileIds(data.DataFileNameSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, fileIds)
+	streamIds, err := db.listFileIds(data.StreamFileNameSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, streamIds)
+	assert.Equal(t, int64(0), db.oldFiles[1].WriteOff)
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// TestDB_PutStreamRecoverLeftover ensures Open discards a stream file left by a crash mid-upload.
+func TestDB_PutStreamRecoverLeftover(t *testing.T) {
+	setup := DefaultOptions
+	setup.DirPath = t.TempDir()
+	db, err := Open(setup)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.Close())
+
+	// 模拟上传过程中崩溃：预留的流式写入文件之中只有一条没有完成标记的记录
+	streamFile, err := data.OpenStreamFile(setup.DirPath, 1, data.FileOptions{})
+	require.NoError(t, err)
+	_, err = streamFile.WriteStream(addSeqToKey([]byte("b"), 100), bytes.NewReader([]byte("v")), 1)
+	require.NoError(t, err)
+	require.NoError(t, streamFile.Close())
+
+	db, err = Open(setup)
+	require.NoError(t, err)
+	defer destroyDB(db)
+
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	streamIds, err := db.listFileIds(data.StreamFileNameSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, streamIds)
+	fileIds, err := db.listFileIds(data.DataFileNameSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, fileIds)
+
+	require.NoError(t, db.Put([]byte("c"), []byte("3")))
+	got, err := db.Get([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), got)
+}
+
+// TestDB_ReplicationPutStream ensures a replica receives a streamed value written while later writes overtook it.
+func TestDB_ReplicationPutStream(t *testing.T) {
+	leader, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+	setup := DefaultOptions
+	setup.Replica = true
+	follower, cleanupFollower := newDB(t, setup)
+	defer cleanupFollower()
+
+	stop := startReplication(t, leader, follower)
+	value := bytes.Repeat([]byte("s"), 64*1024)
+	r := &blockingReader{data: value, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- leader.PutStream([]byte("artifact"), r, int64(len(value))) }()
+	<-r.started
+
+	require.NoError(t, leader.Put([]byte("a"), []byte("1")))
+	close(r.release)
+	require.NoError(t, <-done)
+	require.NoError(t, leader.Put([]byte("b"), []byte("2")))
+
+	waitReplicated(t, follower, []byte("b"), []byte("2"))
+	waitReplicated(t, follower, []byte("a"), []byte("1"))
+	waitReplicated(t, follower, []byte("artifact"), value)
+	stop()
+}
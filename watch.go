@@ -0,0 +1,204 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/data"
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWatchBufferSize Watch 默认的事件缓冲区大小
+const DefaultWatchBufferSize = 1024
+
+// EventType 数据变更事件的类型
+type EventType byte
+
+const (
+	// EventPut key 被写入
+	EventPut EventType = iota
+	// EventDelete key 被删除
+	EventDelete
+	// EventMerge 通过 MergeValue 向 key 追加了一个操作数，Value 为操作数本身
+	EventMerge
+	// EventDeleteRange [Key, End) 范围内的所有 key 被删除，End 为 nil 时没有上界
+	EventDeleteRange
+)
+
+// Event 一次 key 的变更。Key 以及 Value 由所有订阅者共享，不能被修改。
+type Event struct {
+	Type      EventType
+	Key       []byte
+	Value     []byte             // 写入的 value 或者合并操作数，只有订阅时设置了 IncludeValue 才会携带；流式写入的 value 不会携带
+	Serial    uint64             // 事件的序号，在数据库打开期间单调递增
+	End       []byte             // EventDeleteRange 范围的结束位置（不包含）
+	SeqNumber uint64             // 所属事务的序列号，不属于事务的写入为 0
+	Pos       *data.LogRecordPos // 变更在数据文件之中的位置，删除时为墓碑记录的位置
+}
+
+// WatchOptions 订阅的配置项
+type WatchOptions struct {
+	// 缓冲区最多容纳的事件数量。消费过慢导致缓冲区放不下新的事件时，订阅会被关闭（channel 被关闭），
+	// 订阅者需要重新订阅并自行同步数据，而不会错过事件却毫不知情，写入也不会因此被阻塞。
+	BufferSize int
+	// 是否在写入事件之中携带 value
+	IncludeValue bool
+}
+
+// DefaultWatchOptions 默认的订阅配置
+var DefaultWatchOptions = WatchOptions{
+	BufferSize:   DefaultWatchBufferSize,
+	IncludeValue: false,
+}
+
+// watcher 一个订阅者
+type watcher struct {
+	prefix       []byte
+	ch           chan Event
+	includeValue bool
+	closed       bool
+}
+
+// watchHub 管理所有的订阅者，并向其分发事件
+type watchHub struct {
+	lock     *sync.Mutex
+	watchers map[*watcher]struct{}
+	count    atomic.Int32 // 订阅者的数量，没有订阅者时写入无需构造事件
+	serial   uint64
+	closed   bool // 数据库已经关闭，之后的订阅直接返回已经关闭的 channel
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		lock:     new(sync.Mutex),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// Watch 订阅 key 以 prefix 开头的变更事件，prefix 为空时订阅所有的 key，使用 DefaultWatchOptions。
+// 事件在写入成功之后按照写入顺序送达，批量写入的所有事件在其事务完成的标记写入之后一次性送达。
+// 调用 cancel 取消订阅并关闭 channel；数据库关闭时所有的订阅也会被关闭，关闭之后订阅得到的 channel 已经被关闭。
+func (db *DB) Watch(prefix []byte) (<-chan Event, func()) {
+	return db.WatchWithOptions(prefix, DefaultWatchOptions)
+}
+
+// WatchWithOptions 按照 opts 订阅 key 以 prefix 开头的变更事件
+func (db *DB) WatchWithOptions(prefix []byte, opts WatchOptions) (<-chan Event, func()) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultWatchBufferSize
+	}
+	w := &watcher{
+		prefix:       append([]byte{}, prefix...),
+		ch:           make(chan Event, opts.BufferSize),
+		includeValue: opts.IncludeValue,
+	}
+
+	hub := db.watchHub
+	hub.lock.Lock()
+	if hub.closed {
+		w.closed = true
+		close(w.ch)
+	} else {
+		hub.watchers[w] = struct{}{}
+		hub.count.Add(1)
+	}
+	hub.lock.Unlock()
+
+	return w.ch, func() {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		hub.remove(w)
+	}
+}
+
+// watching 是否存在订阅者
+func (hub *watchHub) watching() bool {
+	return hub.count.Load() > 0
+}
+
+// publish 将一组事件原子地分发给所有匹配的订阅者：要么全部送达，要么订阅因缓冲区不足而被关闭。
+// 调用方需要持有 db.lock，以保证事件的顺序与写入的顺序一致。
+func (hub *watchHub) publish(events []Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	for i := range events {
+		hub.serial++
+		events[i].Serial = hub.serial
+	}
+
+	for w := range hub.watchers {
+		var matched int
+		for i := range events {
+			if events[i].matches(w.prefix) {
+				matched++
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		// 只有这里会发送事件，并且已经持有 hub.lock，因此剩余的容量只会变多
+		if cap(w.ch)-len(w.ch) < matched {
+			hub.remove(w)
+			continue
+		}
+		for _, e := range events {
+			if !e.matches(w.prefix) {
+				continue
+			}
+			if !w.includeValue {
+				e.Value = nil
+			}
+			w.ch <- e
+		}
+	}
+}
+
+// closeAll 关闭所有的订阅
+func (hub *watchHub) closeAll() {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	hub.closed = true
+	for w := range hub.watchers {
+		hub.remove(w)
+	}
+}
+
+// remove 移除订阅者并关闭其 channel，调用方需要持有 hub.lock
+func (hub *watchHub) remove(w *watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.ch)
+	delete(hub.watchers, w)
+	hub.count.Add(-1)
+}
+
+// matches 判断事件是否涉及以 prefix 为前缀的 key；范围删除的事件只要与前缀的范围相交即可
+func (e Event) matches(prefix []byte) bool {
+	if e.Type != EventDeleteRange {
+		return bytes.HasPrefix(e.Key, prefix)
+	}
+	if end := prefixEnd(prefix); end != nil && bytes.Compare(e.Key, end) >= 0 {
+		return false
+	}
+	return e.End == nil || bytes.Compare(prefix, e.End) < 0
+}
+
+// newWatchEvent 构造一个变更事件，key 以及 value 会被复制，调用方之后可以继续修改它们
+func newWatchEvent(typ EventType, key, value []byte, seqNumber uint64, pos *data.LogRecordPos) Event {
+	e := Event{Type: typ, Key: append([]byte{}, key...), SeqNumber: seqNumber, Pos: pos}
+	if typ != EventDelete && value != nil {
+		e.Value = append([]byte{}, value...)
+	}
+	return e
+}
+
+// notifyWatchers 发布单个变更事件，没有订阅者时什么也不做；调用方需要持有 db.lock
+func (db *DB) notifyWatchers(typ EventType, key, value []byte, seqNumber uint64, pos *data.LogRecordPos) {
+	if !db.watchHub.watching() {
+		return
+	}
+	db.watchHub.publish([]Event{newWatchEvent(typ, key, value, seqNumber, pos)})
+}
@@ -0,0 +1,104 @@
+package bitcask_gown
+
+import (
+	"bitcask-gown/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDB_Watch ensures Put, Delete and WriteBatch.Commit are delivered in order to matching watchers.
+func TestDB_Watch(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	all, cancelAll := db.WatchWithOptions(nil, WatchOptions{BufferSize: 16, IncludeValue: true})
+	defer cancelAll()
+	users, cancelUsers := db.Watch([]byte("user/"))
+
+	require.NoError(t, db.Put([]byte("user/1"), []byte("alice")))
+	require.NoError(t, db.Put([]byte("order/1"), []byte("book")))
+	require.NoError(t, db.Delete([]byte("user/1")))
+	require.NoError(t, db.Delete([]byte("user/404"))) // 删除不存在的 key 不产生事件
+
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	require.NoError(t, wb.Put([]byte("user/3"), []byte("carol")))
+	require.NoError(t, wb.Put([]byte("user/2"), []byte("bob")))
+	require.NoError(t, wb.Commit())
+
+	expect := []struct {
+		typ   EventType
+		key   string
+		value string
+		seq   uint64
+	}{
+		{EventPut, "user/1", "alice", 0},
+		{EventPut, "order/1", "book", 0},
+		{EventDelete, "user/1", "", 0},
+		{EventPut, "user/2", "bob", 1},
+		{EventPut, "user/3", "carol", 1},
+	}
+	var serial uint64
+	for _, want := range expect {
+		e := <-all
+		assert.Equal(t, want.typ, e.Type)
+		assert.Equal(t, want.key, string(e.Key))
+		assert.Equal(t, want.value, string(e.Value))
+		assert.Equal(t, want.seq, e.SeqNumber)
+		assert.Greater(t, e.Serial, serial)
+		serial = e.Serial
+		require.NotNil(t, e.Pos)
+	}
+
+	for _, want := range []string{"user/1", "user/1", "user/2", "user/3"} {
+		e := <-users
+		assert.Equal(t, want, string(e.Key))
+		assert.Nil(t, e.Value)
+	}
+	cancelUsers()
+	_, ok := <-users
+	assert.False(t, ok)
+	cancelUsers() // 重复取消是安全的
+}
+
+// TestDB_WatchSlowConsumer ensures a watcher that cannot take a whole batch is closed instead of blocking writers.
+func TestDB_WatchSlowConsumer(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	slow, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 3})
+	defer cancel()
+
+	require.NoError(t, db.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+	wb := db.NewWriteBatch(DefaultWriteBatchSetup)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(8)))
+	}
+	require.NoError(t, wb.Commit())
+
+	// 批次放不下时一个事件也不会送达，订阅直接被关闭
+	e, ok := <-slow
+	require.True(t, ok)
+	assert.Equal(t, utils.GetTestKey(0), e.Key)
+	_, ok = <-slow
+	assert.False(t, ok)
+}
+
+// TestDB_WatchClose ensures closing the DB closes every watcher, including ones registered afterwards.
+func TestDB_WatchClose(t *testing.T) {
+	db, cleanup := newDB(t, DefaultOptions)
+	defer cleanup()
+
+	ch, cancel := db.Watch(nil)
+	require.NoError(t, db.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+	cancel()
+
+	// 关闭之后的订阅直接得到已经关闭的 channel，不会一直阻塞
+	ch, cancel = db.Watch(nil)
+	_, ok = <-ch
+	assert.False(t, ok)
+	cancel()
+}